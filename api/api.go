@@ -7,66 +7,280 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gdotgordon/fibsrv/apitypes"
+	"github.com/gdotgordon/fibsrv/fibpb"
+	"github.com/gdotgordon/fibsrv/metrics"
 	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gdotgordon/fibsrv/tracing"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
 // Definitions for the supported URL endpoints.
 const (
-	fibURL     = "/v1/fib"     // get fib(n)
-	fibLessURL = "/v1/fibless" // get count(memos) for fib() < n
-	clearURL   = "/v1/clear"   // clear the DB table
+	fibURL     = "/v1/fib"                 // get fib(n)
+	fibLessURL = "/v1/fibless"             // get count(memos) for fib() < n
+	batchURL   = "/v1/fib/batch"           // compute many fib(n) at once
+	jobsURL    = "/v1/jobs"                // submit a background job
+	jobURL     = "/v1/jobs/{id:[0-9a-f]+}" // a job, its progress and result
+	queueURL   = "/v1/queue"               // queue a durable job
+	queuedURL  = "/v1/queue/{id:[0-9]+}"   // a durable job
+	clearURL   = "/v1/clear"               // clear the DB table (deprecated)
+	memosURL   = "/v1/memos"               // the collection of memos
+	memoURL    = "/v1/memos/{n:[0-9]+}"    // a memo and its metadata
+	metricsURL = "/metrics"                // Prometheus metrics
+	openAPIURL = "/v1/openapi.json"        // the OpenAPI document
 )
 
-// StatusResponse is the JSON returned for status notifications.
-type StatusResponse struct {
-	Status string `json:"status"`
+// Page sizes for listing memos.
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// Config holds the optional settings for the API layer.
+type Config struct {
+	// LegacyClear enables the deprecated GET /v1/clear endpoint, which
+	// truncates the whole memo table.
+	LegacyClear bool
+
+	// ConfirmKey is the secret used to sign the confirmation tokens that
+	// a DELETE of memos must present.  If empty, a random key is generated,
+	// so tokens are only valid for the instance that issued them.
+	ConfirmKey []byte
+
+	// Metrics, if set, records the request metrics and serves them
+	// on /metrics.
+	Metrics *metrics.Metrics
+
+	// Tracing starts a span for each request.
+	Tracing bool
+
+	// Health, if set, serves the liveness and readiness probes.
+	Health *Health
+
+	// Auth, if set, requires callers to authenticate, and checks their
+	// roles: reader to compute and browse, admin to delete memos.  The
+	// probes and the metrics are not authenticated.
+	Auth *Authenticator
+
+	// RateLimiter, if set, limits the request rate of each client.
+	RateLimiter *RateLimiter
+
+	// Jobs, if set, serves the jobs api, running the jobs in the
+	// background.
+	Jobs *service.Jobs
+
+	// Queue, if set, lets admins add jobs to the durable queue, which
+	// are run by the workers.
+	Queue store.Queue
+
+	// Encoders are added to those of the media types served by default:
+	// JSON, plain text, CSV, CBOR and protobuf.  An encoder replaces the
+	// default for its media type.
+	Encoders []Encoder
+
+	// ValidateResponses checks the responses against the OpenAPI
+	// document, logging those that don't match.  It is meant for
+	// development, as the responses are buffered.
+	ValidateResponses bool
+}
+
+// LimitResponse is the problem returned when a computation exceeds a
+// limit, with the estimated cost of the request.
+type LimitResponse struct {
+	Problem
+	Limit       string `json:"limit"`
+	N           int    `json:"n"`
+	Writes      int    `json:"writes,omitempty"`
+	EstimatedMS int64  `json:"estimated_ms,omitempty"`
+}
+
+// The bodies of the responses, which are shared with the client.
+type (
+	ResultResponse = apitypes.ResultResponse
+	ClearResponse  = apitypes.ClearResponse
+	ListResponse   = apitypes.ListResponse
+)
+
+// The removal of memos, which is also encoded as the message of the gRPC
+// api.
+type clearResult struct {
+	ClearResponse
+}
+
+func (cr clearResult) protoMessage() proto.Message {
+	return &fibpb.ClearResponse{Count: int64(cr.Count), DryRun: cr.DryRun}
+}
+
+// A page of memos, which is also encoded as a table.
+type memoPage struct {
+	ListResponse
+}
+
+func (lr memoPage) csvRecords() [][]string {
+	recs := [][]string{{"n", "value", "created_at", "last_accessed_at", "hit_count", "writer_id"}}
+	for _, m := range lr.Memos {
+		accessed := ""
+		if m.LastAccessedAt != nil {
+			accessed = m.LastAccessedAt.Format(time.RFC3339Nano)
+		}
+		recs = append(recs, []string{strconv.Itoa(m.Num), strconv.FormatUint(m.Value, 10),
+			m.CreatedAt.Format(time.RFC3339Nano), accessed, strconv.FormatInt(m.HitCount, 10), m.WriterID})
+	}
+	return recs
+}
+
+// The results of fib(n) and FibLess, which are also encoded as the
+// messages of the gRPC api.
+type fibResult struct {
+	ResultResponse
+	n int
+}
+
+func (fr fibResult) plainText() string {
+	return strconv.FormatUint(fr.Result, 10)
 }
 
-// ResultResponse is the JSON returned for status notifications.
-type ResultResponse struct {
-	Result uint64 `json:"result"`
+func (fr fibResult) protoMessage() proto.Message {
+	return &fibpb.FibResponse{N: uint32(fr.n), Value: fr.Result}
+}
+
+type lessResult struct {
+	ResultResponse
+}
+
+func (lr lessResult) plainText() string {
+	return strconv.FormatUint(lr.Result, 10)
+}
+
+func (lr lessResult) protoMessage() proto.Message {
+	return &fibpb.FibLessResponse{Count: int64(lr.Result)}
 }
 
 // API is the item that dispatches to the endpoint implementations
 type apiImpl struct {
-	service *service.FibService
-	log     *zap.SugaredLogger
+	service    *service.FibService
+	log        *zap.SugaredLogger
+	confirmKey []byte
+	auth       *Authenticator
+	limiter    *RateLimiter
+	jobs       *service.Jobs
+	queue      store.Queue
+	spec       *openAPI
+	valid      validator
+	encoders   []Encoder
 }
 
 // Init sets up the endpoint processing.  There is nothing returned, other
 // than potntial errors, because the endpoint handling is configured in
 // the passed-in muxer.
-func Init(ctx context.Context, r *mux.Router, service *service.FibService, log *zap.SugaredLogger) error {
-	ap := apiImpl{service: service, log: log}
-	r.HandleFunc(fibURL, ap.fib).Queries("n", "{n:[0-9]+}").Methods(http.MethodGet)
-	r.HandleFunc(fibLessURL, ap.fibLess).Queries("target", "{target:[0-9]+}").Methods(http.MethodGet)
-	r.HandleFunc(clearURL, ap.clear).Methods(http.MethodGet)
+func Init(ctx context.Context, r *mux.Router, service *service.FibService, log *zap.SugaredLogger, cfg Config) error {
+	ap := apiImpl{service: service, log: log, confirmKey: cfg.ConfirmKey,
+		auth: cfg.Auth, limiter: cfg.RateLimiter, jobs: cfg.Jobs,
+		queue: cfg.Queue, spec: newOpenAPI(), encoders: withEncoders(cfg.Encoders)}
+	ap.valid = validator{doc: ap.spec, log: log, responses: cfg.ValidateResponses}
+	if len(ap.confirmKey) == 0 {
+		ap.confirmKey = make([]byte, 32)
+		if _, err := rand.Read(ap.confirmKey); err != nil {
+			return err
+		}
+	}
+	r.Handle(fibURL, ap.require(RoleReader, ap.limit(ap.fibCost, ap.fib))).
+		Queries("n", "{n:[0-9]+}").Methods(http.MethodGet)
+	r.Handle(fibLessURL, ap.require(RoleReader, ap.limit(cold, ap.fibLess))).
+		Queries("target", "{target:[0-9]+}").Methods(http.MethodGet)
+	r.Handle(batchURL, ap.require(RoleReader, ap.limit(cold, ap.fibBatch))).Methods(http.MethodPost)
+	r.Handle(memosURL, ap.require(RoleReader, ap.limit(cached, ap.listMemos))).Methods(http.MethodGet)
+	r.Handle(memosURL, ap.require(RoleAdmin, ap.deleteMemos)).Methods(http.MethodDelete)
+	r.Handle(memoURL, ap.require(RoleReader, ap.limit(cached, ap.memo))).Methods(http.MethodGet)
+	if cfg.Jobs != nil {
+		r.Handle(jobsURL, ap.require(RoleReader, ap.limit(cold, ap.submitJob))).Methods(http.MethodPost)
+		r.Handle(jobURL, ap.require(RoleReader, ap.limit(cached, ap.job))).Methods(http.MethodGet)
+		r.Handle(jobURL, ap.require(RoleWriter, ap.limit(cached, ap.cancelJob))).Methods(http.MethodDelete)
+	}
+	if cfg.Queue != nil {
+		r.Handle(queueURL, ap.require(RoleAdmin, ap.queueJob)).Methods(http.MethodPost)
+		r.Handle(queuedURL, ap.require(RoleAdmin, ap.queuedJob)).Methods(http.MethodGet)
+	}
+	if cfg.LegacyClear {
+		r.Handle(clearURL, ap.require(RoleAdmin, ap.clear)).Methods(http.MethodGet)
+	}
+	r.HandleFunc(openAPIURL, ap.openAPI).Methods(http.MethodGet)
+	r.NotFoundHandler = requestIDMiddleware(http.HandlerFunc(ap.notFound))
+	r.MethodNotAllowedHandler = requestIDMiddleware(ap.methodNotAllowed(r))
+	if cfg.Health != nil {
+		r.HandleFunc(healthURL, cfg.Health.live).Methods(http.MethodGet)
+		r.HandleFunc(readyURL, cfg.Health.ready).Methods(http.MethodGet)
+	}
+	if cfg.Metrics != nil {
+		r.Handle(metricsURL, cfg.Metrics.Handler()).Methods(http.MethodGet)
+		r.Use(cfg.Metrics.Middleware)
+	}
 
+	// The request is cancelled along with the server context, but keeps
+	// its values, such as the route variables set by the muxer.
 	var wrapContext = func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			rc := r.WithContext(ctx)
+			rc := r.WithContext(serverContext{Context: ctx, values: r.Context()})
 			next.ServeHTTP(w, rc)
 		})
 	}
 
 	var loggingMiddleware = func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			log.Infow("Handling URL", "url", r.URL)
+			log.Infow("Handling URL", "url", r.URL, "request_id", RequestID(r.Context()))
 			next.ServeHTTP(w, r)
 		})
 	}
+	r.Use(requestIDMiddleware)
+	r.Use(noStoreMiddleware)
 	r.Use(loggingMiddleware)
 	r.Use(wrapContext)
+	r.Use(identityMiddleware)
+
+	// Authentication may use the client certificate identity.
+	if cfg.Auth != nil {
+		r.Use(cfg.Auth.middleware)
+	}
+
+	// The span must be started on the context set above.
+	if cfg.Tracing {
+		r.Use(tracing.Middleware)
+	}
+
+	// The requests are validated by require, once the caller is known.
+	r.Use(ap.valid.middleware)
 	return nil
 }
 
+// A context cancelled with the server, with the values of the request.
+type serverContext struct {
+	context.Context
+	values context.Context
+}
+
+func (sc serverContext) Value(key interface{}) interface{} {
+	return sc.values.Value(key)
+}
+
 // Returns fib(n)
 func (a apiImpl) fib(w http.ResponseWriter, r *http.Request) {
 	if r.Body != nil {
@@ -76,20 +290,33 @@ func (a apiImpl) fib(w http.ResponseWriter, r *http.Request) {
 	ntxt := r.URL.Query().Get("n")
 	n, err := strconv.Atoi(ntxt)
 	if err != nil || n < 0 {
-		a.writeErrorResponse(w, http.StatusBadRequest, err)
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, fmt.Errorf("invalid n: %q", ntxt))
+		return
+	}
+
+	// A cached result is still valid without computing it again, as long
+	// as the request is within the limits.  Otherwise Fib checks them.
+	rep, ok := a.negotiate(r, fibResult{})
+	if inm := r.Header.Get("If-None-Match"); ok && inm != "" && etagMatch(inm, fibETag(n, rep)) {
+		if err := a.service.CheckFib(r.Context(), n); err != nil {
+			a.writeComputeError(w, r, err)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		a.cacheFib(w, fibETag(n, rep))
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	res, err := a.service.Fib(r.Context(), n)
 	if err != nil {
-		a.writeErrorResponse(w, http.StatusInternalServerError, err)
+		a.writeComputeError(w, r, err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(http.StatusOK)
-	b, _ := json.MarshalIndent(ResultResponse{res}, "", "  ")
-	w.Write(b)
+	if ok {
+		a.cacheFib(w, fibETag(n, rep))
+	}
+	a.respond(w, r, http.StatusOK, fibResult{ResultResponse{Result: res}, n})
 }
 
 // Count number of memoized items less than target
@@ -101,33 +328,211 @@ func (a apiImpl) fibLess(w http.ResponseWriter, r *http.Request) {
 	ttxt := r.URL.Query().Get("target")
 	target, err := strconv.Atoi(ttxt)
 	if err != nil || target < 0 {
-		a.writeErrorResponse(w, http.StatusBadRequest, err)
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest,
+			fmt.Errorf("invalid target: %q", ttxt))
 		return
 	}
 	resp, err := a.service.FibLess(r.Context(), uint64(target))
 	if err != nil {
-		a.writeErrorResponse(w, http.StatusInternalServerError, err)
+		a.writeComputeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(http.StatusOK)
-	b, _ := json.MarshalIndent(ResultResponse{uint64(resp)}, "", "  ")
-	w.Write(b)
+	a.respond(w, r, http.StatusOK, lessResult{ResultResponse{Result: uint64(resp)}})
 }
 
+// Returns a page of memos in order of n.  The optional "after" query
+// parameter is the opaque cursor from the previous page, and "limit" is
+// the page size.
+func (a apiImpl) listMemos(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	after := -1
+	if c := q.Get("after"); c != "" {
+		n, err := decodeCursor(c)
+		if err != nil {
+			a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, err)
+			return
+		}
+		after = n
+	}
+	limit, err := intParam(q.Get("limit"), defaultListLimit)
+	if err != nil || limit == 0 || limit > maxListLimit {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest,
+			fmt.Errorf("limit must be between 1 and %d", maxListLimit))
+		return
+	}
+
+	// Fetch one extra memo to find out whether there is another page.
+	memos, err := a.service.List(r.Context(), after, limit+1)
+	if err != nil {
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+		return
+	}
+	resp := ListResponse{Memos: make([]apitypes.Memo, 0, len(memos))}
+	for _, m := range memos {
+		resp.Memos = append(resp.Memos, apitypes.Memo(m))
+	}
+	if len(memos) > limit {
+		resp.Memos = resp.Memos[:limit]
+		resp.Next = encodeCursor(memos[limit-1].Num)
+
+		// The cursor is also in a header, for the formats without a place
+		// for it, such as CSV.
+		next := url.Values{"after": {resp.Next}, "limit": {strconv.Itoa(limit)}}
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, memosURL, next.Encode()))
+	}
+	a.respond(w, r, http.StatusOK, memoPage{resp})
+}
+
+// Returns the memo for n and its metadata.
+func (a apiImpl) memo(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(mux.Vars(r)["n"])
+	if err != nil {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, err)
+		return
+	}
+	res, ok, err := a.service.MemoDetail(r.Context(), n)
+	if err != nil {
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+		return
+	}
+	if !ok {
+		a.writeProblem(w, r, http.StatusNotFound, problemNotFound, fmt.Errorf("no memo for %d", n))
+		return
+	}
+
+	a.respond(w, r, http.StatusOK, res)
+}
+
+// Clears the whole table.  This is deprecated, as a GET that deletes data
+// may be invoked by crawlers and prefetchers.  Use DELETE /v1/memos instead.
 func (a *apiImpl) clear(w http.ResponseWriter, r *http.Request) {
+	a.log.Warnw("deprecated endpoint invoked", "url", r.URL, "use", memosURL)
+	w.Header().Set("Deprecation", "true")
 	if err := a.service.Clear(r.Context()); err != nil {
-		a.writeErrorResponse(w, http.StatusInternalServerError, err)
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+	}
+}
+
+// Removes the memos in the (optional) range given by the "from" and "to"
+// query parameters.  A "dry_run" only counts the affected memos and returns
+// a confirmation token, which must then be passed as "confirm" to actually
+// remove the memos in that range.
+func (a *apiImpl) deleteMemos(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := intParam(q.Get("from"), 0)
+	if err != nil {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, err)
+		return
+	}
+	to, err := intParam(q.Get("to"), math.MaxInt32)
+	if err != nil {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, err)
+		return
+	}
+	if to < from {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest,
+			fmt.Errorf("invalid range: from (%d) exceeds to (%d)", from, to))
+		return
+	}
+	dryRun := false
+	if dr := q.Get("dry_run"); dr != "" {
+		dryRun, err = strconv.ParseBool(dr)
+		if err != nil {
+			a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, err)
+			return
+		}
+	}
+
+	token := a.confirmToken(from, to)
+	if !dryRun {
+		confirm := q.Get("confirm")
+		if confirm == "" {
+			a.writeProblem(w, r, http.StatusPreconditionRequired, problemConfirmRequired,
+				errors.New("a confirmation token is required: issue a dry_run first"))
+			return
+		}
+		if !hmac.Equal([]byte(confirm), []byte(token)) {
+			a.writeProblem(w, r, http.StatusPreconditionFailed, problemConfirmInvalid,
+				errors.New("confirmation token does not match the requested range"))
+			return
+		}
+	}
+
+	cnt, err := a.service.ClearRange(r.Context(), from, to, dryRun)
+	if err != nil {
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+		return
 	}
+	resp := ClearResponse{Count: cnt, DryRun: dryRun}
+	if dryRun {
+		resp.Confirm = token
+	}
+
+	a.respond(w, r, http.StatusOK, clearResult{resp})
+}
+
+// The confirmation token for a range is an HMAC of the range, so it is
+// only valid for exactly the range reported by the dry run.
+func (a apiImpl) confirmToken(from, to int) string {
+	mac := hmac.New(sha256.New, a.confirmKey)
+	fmt.Fprintf(mac, "%d:%d", from, to)
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// Cursors are opaque to clients, so the format may change later on.
+const cursorPrefix = "n:"
+
+func encodeCursor(n int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(n)))
 }
 
-// For HTTP bad request responses, serialize a JSON status message with
-// the cause.
-func (a apiImpl) writeErrorResponse(w http.ResponseWriter, code int, err error) {
-	a.log.Errorw("invoke error", "error", err, "code", code)
-	w.WriteHeader(code)
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	b, _ := json.MarshalIndent(StatusResponse{Status: err.Error()}, "", "  ")
-	w.Write(b)
+func decodeCursor(c string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil || !strings.HasPrefix(string(b), cursorPrefix) {
+		return 0, errors.New("invalid cursor")
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(b), cursorPrefix))
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return n, nil
+}
+
+// Parses an optional non-negative integer query parameter.
+func intParam(txt string, def int) (int, error) {
+	if txt == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(txt)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("invalid negative value: %d", v)
+	}
+	return v, nil
+}
+
+// Computations beyond the limits are rejected with an explanation: 422 if
+// the result can't be represented at all, or 413 if the request is too
+// costly under the configured limits.
+func (a apiImpl) writeComputeError(w http.ResponseWriter, r *http.Request, err error) {
+	var le *service.LimitError
+	if !errors.As(err, &le) {
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+		return
+	}
+	code := http.StatusRequestEntityTooLarge
+	if le.OutOfRange() {
+		code = http.StatusUnprocessableEntity
+	}
+	a.log.Infow("Request over limit", "limit", le.Limit, "n", le.Cost.N)
+	writeProblemJSON(w, code, LimitResponse{
+		Problem:     newProblem(r, code, problemLimitExceeded, le),
+		Limit:       le.Limit,
+		N:           le.Cost.N,
+		Writes:      le.Cost.Writes,
+		EstimatedMS: le.Cost.Time.Milliseconds(),
+	})
 }
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// testSetup sets up the service of a test router.  The zero value is a
+// service over a memory store, without limits or jobs.
+type testSetup struct {
+	store  store.Store
+	limits service.Limits
+	jobs   *service.JobConfig
+	log    *zap.SugaredLogger
+}
+
+// Returns a router for the configuration, over the service of the setup.
+// The jobs, if any, are shut down at the end of the test.
+func newTestRouter(t *testing.T, cfg Config, setup testSetup) *mux.Router {
+	if setup.store == nil {
+		setup.store = store.NewMap()
+	}
+	if setup.log == nil {
+		setup.log = zap.NewNop().Sugar()
+	}
+	svc, err := service.NewFib(setup.store, service.WithLimits(setup.limits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setup.jobs != nil {
+		jobs := service.NewJobs(svc, *setup.jobs)
+		t.Cleanup(func() { jobs.Shutdown(context.Background()) })
+		cfg.Jobs = jobs
+	}
+	r := mux.NewRouter()
+	if err := Init(context.Background(), r, svc, setup.log, cfg); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// Tests requests over the computation limits are rejected with the limit.
+func TestComputeLimits(t *testing.T) {
+	r := newTestRouter(t, Config{}, testSetup{limits: service.Limits{MaxWrites: 20}})
+	for i, v := range []struct {
+		url   string
+		code  int
+		limit string
+	}{
+		{"/v1/fib?n=99999999", http.StatusUnprocessableEntity, service.LimitRange},
+		{"/v1/fib?n=30", http.StatusRequestEntityTooLarge, service.LimitWrites},
+		{"/v1/fibless?target=1000000", http.StatusRequestEntityTooLarge, service.LimitWrites},
+		{"/v1/fib?n=19", http.StatusOK, ""},
+		{"/v1/fib?n=30", http.StatusOK, ""},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, v.url, nil))
+		if w.Code != v.code {
+			t.Fatalf("%d: expected status %d, got %d: %s", i, v.code, w.Code, w.Body)
+		}
+		if v.limit == "" {
+			continue
+		}
+		var resp LimitResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Limit != v.limit || resp.Status != v.code || resp.Code != problemLimitExceeded {
+			t.Fatalf("%d: expected %s limit, got %+v", i, v.limit, resp)
+		}
+	}
+}
+
+// Tests a batch returns the results in order, with per item errors.
+func TestFibBatch(t *testing.T) {
+	r := newTestRouter(t, Config{}, testSetup{})
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, batchURL, strings.NewReader(body)))
+		return w
+	}
+
+	w := post(`{"n": [10, 94, 0], "targets": [120]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 3 || *resp.Results[0].Value != 55 || *resp.Results[2].Value != 0 {
+		t.Fatalf("unexpected results: %s", w.Body)
+	}
+	if resp.Results[1].Value != nil || resp.Results[1].Limit != service.LimitRange {
+		t.Fatalf("expected a range error for 94: %s", w.Body)
+	}
+	if len(resp.Less) != 1 || *resp.Less[0].Count != 12 {
+		t.Fatalf("unexpected fibless results: %s", w.Body)
+	}
+
+	// A plain array is also accepted.
+	if w := post(`[3, 4]`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"value": 3`) {
+		t.Fatalf("unexpected response to an array: %d %s", w.Code, w.Body)
+	}
+	for i, v := range []struct {
+		body string
+		code int
+	}{
+		{`{"n": [1], "extra": 1}`, http.StatusBadRequest},
+		{`[1, "two"]`, http.StatusBadRequest},
+		{`[]`, http.StatusRequestEntityTooLarge},
+		{"[" + strings.Repeat("1,", maxBatchItems) + "1]", http.StatusRequestEntityTooLarge},
+	} {
+		if w := post(v.body); w.Code != v.code {
+			t.Fatalf("%d: expected status %d, got %d", i, v.code, w.Code)
+		}
+	}
+}
+
+// Tests a job is submitted, polled for its result and cancelled.
+func TestJobs(t *testing.T) {
+	r := newTestRouter(t, Config{}, testSetup{jobs: &service.JobConfig{Workers: 1}})
+	call := func(method, url, body string) (*httptest.ResponseRecorder, JobResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		var resp JobResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, resp := call(http.MethodPost, jobsURL, `{"n": [30, 94], "targets": [120]}`)
+	if w.Code != http.StatusAccepted || resp.ID == "" {
+		t.Fatalf("expected the job to be accepted, got %d: %s", w.Code, w.Body)
+	}
+	loc := w.Header().Get("Location")
+	if loc != jobsURL+"/"+resp.ID {
+		t.Fatalf("unexpected location: %s", loc)
+	}
+	for i := 0; resp.State != string(service.JobSucceeded); i++ {
+		if i == 1000 {
+			t.Fatalf("the job did not succeed: %+v", resp)
+		}
+		time.Sleep(time.Millisecond)
+		if w, resp = call(http.MethodGet, loc, ""); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+	}
+	if res := resp.Result; res == nil || *res.Results[0].Value != 832040 ||
+		res.Results[1].Limit != service.LimitRange || *res.Less[0].Count != 12 {
+		t.Fatalf("unexpected result: %+v", resp)
+	}
+	if resp.Progress.Done != 31 || resp.Progress.Total != 31 || resp.Finished == nil {
+		t.Fatalf("unexpected progress: %+v", resp)
+	}
+
+	if w, _ := call(http.MethodDelete, loc, ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected a finished job to conflict, got %d", w.Code)
+	}
+	if w, _ := call(http.MethodGet, jobsURL+"/0123abcd", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+	if w, _ := call(http.MethodPost, jobsURL, `[]`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected an empty job to be rejected, got %d", w.Code)
+	}
+}
+
+// Tests jobs are added to the durable queue.
+func TestQueue(t *testing.T) {
+	st := store.NewMap()
+	r := newTestRouter(t, Config{Queue: st.(store.Queue)}, testSetup{store: st})
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return w
+	}
+
+	w := call(http.MethodPost, queueURL, `{"from": 10, "to": 90}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", w.Code, w.Body)
+	}
+	w = call(http.MethodGet, w.Header().Get("Location"), "")
+	var resp QueuedJobResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || resp.State != store.QueuePending || resp.From != 10 || resp.To != 90 ||
+		resp.MaxAttempts != defaultMaxAttempts {
+		t.Fatalf("unexpected queued job: %d %+v", w.Code, resp)
+	}
+
+	for i, v := range []struct {
+		method, url, body string
+		code              int
+	}{
+		{http.MethodPost, queueURL, `{"from": 0, "to": 94}`, http.StatusUnprocessableEntity},
+		{http.MethodPost, queueURL, `{"from": 9, "to": 5}`, http.StatusBadRequest},
+		{http.MethodPost, queueURL, `{"from": 0, "to": 5, "retries": 1}`, http.StatusBadRequest},
+		{http.MethodGet, queueURL + "/99", "", http.StatusNotFound},
+	} {
+		if w := call(v.method, v.url, v.body); w.Code != v.code {
+			t.Fatalf("%d: expected status %d, got %d", i, v.code, w.Code)
+		}
+	}
+}
+
+// Tests a memo is fetched through the router, which sets the route
+// variables the handler reads.
+func TestMemo(t *testing.T) {
+	r := newTestRouter(t, Config{}, testSetup{})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/fib?n=10", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/memos/10", nil))
+	var m store.FibPair
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &m) != nil || m.Num != 10 || m.Value != 55 {
+		t.Fatalf("unexpected memo: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/memos/11", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body)
+	}
+}
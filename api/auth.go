@@ -0,0 +1,357 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The roles, from least to most privileged.  Each role includes the
+// privileges of the ones before it, so an admin may also read.
+const (
+	RoleReader = "reader" // compute and browse
+	RoleWriter = "writer" // submit work that changes the memos
+	RoleAdmin  = "admin"  // destructive operations, such as clearing
+)
+
+var roleRank = map[string]int{RoleReader: 1, RoleWriter: 2, RoleAdmin: 3}
+
+// The authentication methods.
+const (
+	AuthAPIKey     = "api-key"
+	AuthJWT        = "jwt"
+	AuthClientCert = "client-cert"
+)
+
+// Principal is an authenticated caller and its roles.
+type Principal struct {
+	Name   string
+	Method string
+	Roles  []string
+}
+
+// Has tells whether the principal has a role, directly or through a more
+// privileged role.
+func (p Principal) Has(role string) bool {
+	for _, r := range p.Roles {
+		if roleRank[r] >= roleRank[role] {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// CallerPrincipal returns the authenticated caller of a request, if any.
+func CallerPrincipal(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// APIKey is a static API key.  Only the hex encoded SHA-256 hash of the
+// key is configured, so the configuration does not hold the key itself.
+type APIKey struct {
+	Name  string
+	Hash  string
+	Roles []string
+}
+
+// AuthConfig defines the accepted credentials.
+type AuthConfig struct {
+	APIKeys []APIKey
+
+	// JWTSecret is the key for HMAC signed (HS256, HS384 or HS512) JWTs.
+	// The roles are taken from the "roles" claim.
+	JWTSecret   []byte
+	JWTIssuer   string // if set, the "iss" claim must match
+	JWTAudience string // if set, the "aud" claim must include it
+
+	// ClientCertRoles maps the common name of verified client
+	// certificates to their roles.
+	ClientCertRoles map[string][]string
+}
+
+// Authenticator checks the credentials of requests.
+type Authenticator struct {
+	cfg  AuthConfig
+	keys []apiKeyHash
+	now  func() time.Time
+}
+
+type apiKeyHash struct {
+	key  APIKey
+	hash []byte
+}
+
+// NewAuthenticator validates the configuration.
+func NewAuthenticator(cfg AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{cfg: cfg, now: time.Now}
+	for _, k := range cfg.APIKeys {
+		h, err := hex.DecodeString(strings.TrimPrefix(k.Hash, "sha256:"))
+		if err != nil || len(h) != sha256.Size {
+			return nil, fmt.Errorf("API key %q: hash must be a hex encoded SHA-256", k.Name)
+		}
+		if err := checkRoles(k.Roles); err != nil {
+			return nil, fmt.Errorf("API key %q: %v", k.Name, err)
+		}
+		a.keys = append(a.keys, apiKeyHash{key: k, hash: h})
+	}
+	for cn, roles := range cfg.ClientCertRoles {
+		if err := checkRoles(roles); err != nil {
+			return nil, fmt.Errorf("client certificate %q: %v", cn, err)
+		}
+	}
+	return a, nil
+}
+
+func checkRoles(roles []string) error {
+	for _, r := range roles {
+		if _, ok := roleRank[r]; !ok {
+			return fmt.Errorf("unknown role %q", r)
+		}
+	}
+	return nil
+}
+
+// AuthErrorResponse is the problem returned when a request is rejected for
+// missing or insufficient credentials.
+type AuthErrorResponse struct {
+	Problem
+	RequiredRole string `json:"required_role,omitempty"`
+}
+
+// Problem codes of AuthErrorResponse.
+const (
+	authErrUnauthenticated    = "unauthenticated"
+	authErrInvalidCredentials = "invalid_credentials"
+	authErrForbidden          = "forbidden"
+)
+
+// ErrNoCredentials is returned when a request has no credentials.
+var ErrNoCredentials = errors.New("no credentials")
+
+// The probes and the metrics are not authenticated, so their credentials
+// are ignored, rather than failing a probe that sends a stale one.
+var openPaths = map[string]bool{healthURL: true, readyURL: true, metricsURL: true}
+
+// Authenticates the request, from an API key in the X-API-Key header, a
+// bearer token in the Authorization header (an API key or a JWT), or a
+// verified client certificate.  Invalid credentials are rejected here,
+// while the lack of credentials is left to the role check of the route.
+func (a *Authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if openPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := a.authenticate(r)
+		if err == ErrNoCredentials {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			writeProblemJSON(w, http.StatusUnauthorized, AuthErrorResponse{
+				Problem: newProblem(r, http.StatusUnauthorized, authErrInvalidCredentials, err)})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Principal, error) {
+	p, err := a.Authenticate(r.Header.Get("X-API-Key"), r.Header.Get("Authorization"))
+	if err != ErrNoCredentials {
+		return p, err
+	}
+	if id, ok := ClientIdentity(r.Context()); ok {
+		if p, ok := a.ClientCertPrincipal(id.CommonName); ok {
+			return p, nil
+		}
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+// Authenticate checks an API key, or else the value of an Authorization
+// header with a bearer API key or JWT, so other protocols such as gRPC
+// can share the credentials.  It returns ErrNoCredentials if both are
+// empty.
+func (a *Authenticator) Authenticate(apiKey, authorization string) (Principal, error) {
+	if apiKey != "" {
+		return a.apiKey(apiKey)
+	}
+	if authorization == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || strings.ToLower(authorization[:len(prefix)]) != prefix {
+		return Principal{}, errors.New("unsupported authorization scheme")
+	}
+	token := strings.TrimSpace(authorization[len(prefix):])
+	if strings.Count(token, ".") == 2 {
+		return a.jwt(token)
+	}
+	return a.apiKey(token)
+}
+
+// ClientCertPrincipal returns the principal for the common name of a
+// verified client certificate, if it has roles.
+func (a *Authenticator) ClientCertPrincipal(commonName string) (Principal, bool) {
+	roles, ok := a.cfg.ClientCertRoles[commonName]
+	if !ok {
+		return Principal{}, false
+	}
+	return Principal{Name: commonName, Method: AuthClientCert, Roles: roles}, true
+}
+
+func (a *Authenticator) apiKey(key string) (Principal, error) {
+	sum := sha256.Sum256([]byte(key))
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 {
+			return Principal{Name: k.key.Name, Method: AuthAPIKey, Roles: k.key.Roles}, nil
+		}
+	}
+	return Principal{}, errors.New("invalid API key")
+}
+
+// The JWT claims that are checked.
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+	Roles     []string        `json:"roles"`
+}
+
+// Verifies an HMAC signed JWT and its time, issuer and audience claims.
+func (a *Authenticator) jwt(token string) (Principal, error) {
+	if len(a.cfg.JWTSecret) == 0 {
+		return Principal{}, errors.New("JWTs are not accepted")
+	}
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, errors.New("malformed JWT header")
+	}
+	var hf func() hash.Hash
+	switch header.Alg {
+	case "HS256":
+		hf = sha256.New
+	case "HS384":
+		hf = sha512.New384
+	case "HS512":
+		hf = sha512.New
+	default:
+		return Principal{}, fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, errors.New("malformed JWT signature")
+	}
+	mac := hmac.New(hf, a.cfg.JWTSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return Principal{}, errors.New("invalid JWT signature")
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, errors.New("malformed JWT claims")
+	}
+	now := a.now().Unix()
+	if claims.ExpiresAt != nil && now >= *claims.ExpiresAt {
+		return Principal{}, errors.New("JWT has expired")
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return Principal{}, errors.New("JWT is not yet valid")
+	}
+	if a.cfg.JWTIssuer != "" && claims.Issuer != a.cfg.JWTIssuer {
+		return Principal{}, errors.New("JWT has the wrong issuer")
+	}
+	if a.cfg.JWTAudience != "" && !hasAudience(claims.Audience, a.cfg.JWTAudience) {
+		return Principal{}, errors.New("JWT has the wrong audience")
+	}
+	if err := checkRoles(claims.Roles); err != nil {
+		return Principal{}, err
+	}
+	return Principal{Name: claims.Subject, Method: AuthJWT, Roles: claims.Roles}, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// The audience claim may be a single string or an array.
+func hasAudience(raw json.RawMessage, aud string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == aud
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		for _, a := range many {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Wraps a handler to require a role.  If authentication is not configured,
+// every request is allowed.  Invalid requests are only rejected once the
+// caller is known, so they don't tell an unauthenticated caller anything.
+func (a apiImpl) require(role string, h http.HandlerFunc) http.Handler {
+	h = a.valid.requests(h)
+	if a.auth == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := CallerPrincipal(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fibsrv"`)
+			writeProblemJSON(w, http.StatusUnauthorized, AuthErrorResponse{
+				Problem: newProblem(r, http.StatusUnauthorized, authErrUnauthenticated,
+					errors.New("authentication required")),
+				RequiredRole: role,
+			})
+			return
+		}
+		if !p.Has(role) {
+			a.log.Infow("Forbidden", "principal", p.Name, "url", r.URL, "role", role)
+			writeProblemJSON(w, http.StatusForbidden, AuthErrorResponse{
+				Problem: newProblem(r, http.StatusForbidden, authErrForbidden,
+					fmt.Errorf("%s lacks the %s role", p.Name, role)),
+				RequiredRole: role,
+			})
+			return
+		}
+		h(w, r)
+	})
+}
+
+// Writes a JSON response with the status code.
+func writeJSON(w http.ResponseWriter, code int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	b, _ := json.MarshalIndent(resp, "", "  ")
+	w.Write(b)
+}
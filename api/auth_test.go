@@ -0,0 +1,147 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+)
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Signs an HS256 JWT with the claims.
+func signJWT(secret string, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	msg := enc(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + enc(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msg))
+	return msg + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Tests the credentials are checked, and the roles are enforced per route.
+func TestAuth(t *testing.T) {
+	auth, err := NewAuthenticator(AuthConfig{
+		APIKeys: []APIKey{
+			{Name: "reporting", Hash: hashKey("read-key"), Roles: []string{RoleReader}},
+			{Name: "ops", Hash: "sha256:" + hashKey("admin-key"), Roles: []string{RoleAdmin}},
+		},
+		JWTSecret:       []byte("jwt-secret"),
+		JWTAudience:     "fibsrv",
+		ClientCertRoles: map[string][]string{"billing": {RoleReader}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter(t, Config{Auth: auth, Health: NewHealth(store.NewMap())},
+		testSetup{jobs: &service.JobConfig{Workers: 1}})
+
+	now := time.Now().Unix()
+	validJWT := signJWT("jwt-secret", map[string]interface{}{
+		"sub": "alice", "aud": []string{"fibsrv"}, "exp": now + 60, "roles": []string{RoleReader},
+	})
+	expiredJWT := signJWT("jwt-secret", map[string]interface{}{
+		"sub": "alice", "aud": "fibsrv", "exp": now - 60, "roles": []string{RoleReader},
+	})
+	forgedJWT := signJWT("other-secret", map[string]interface{}{
+		"sub": "mallory", "aud": "fibsrv", "roles": []string{RoleAdmin},
+	})
+	wrongAudJWT := signJWT("jwt-secret", map[string]interface{}{
+		"sub": "alice", "aud": "other", "roles": []string{RoleReader},
+	})
+
+	for i, v := range []struct {
+		method, url string
+		header      string
+		value       string
+		cn          string
+		code        int
+		errCode     string
+	}{
+		{method: http.MethodGet, url: "/v1/fib?n=10", code: http.StatusUnauthorized, errCode: authErrUnauthenticated},
+		{method: http.MethodGet, url: "/v1/fib?n=10", header: "X-API-Key", value: "read-key", code: http.StatusOK},
+		{method: http.MethodGet, url: "/v1/fib?n=10", header: "X-API-Key", value: "bad-key",
+			code: http.StatusUnauthorized, errCode: authErrInvalidCredentials},
+		{method: http.MethodGet, url: "/v1/fib?n=10", header: "Authorization", value: "Bearer admin-key", code: http.StatusOK},
+		{method: http.MethodGet, url: "/v1/fib?n=10", header: "Authorization", value: "Basic YTpi",
+			code: http.StatusUnauthorized, errCode: authErrInvalidCredentials},
+		{method: http.MethodGet, url: "/v1/fibless?target=10", header: "Authorization", value: "Bearer " + validJWT, code: http.StatusOK},
+		{method: http.MethodGet, url: "/v1/fib?n=10", header: "Authorization", value: "Bearer " + expiredJWT,
+			code: http.StatusUnauthorized, errCode: authErrInvalidCredentials},
+		{method: http.MethodGet, url: "/v1/fib?n=10", header: "Authorization", value: "Bearer " + forgedJWT,
+			code: http.StatusUnauthorized, errCode: authErrInvalidCredentials},
+		{method: http.MethodGet, url: "/v1/fib?n=10", header: "Authorization", value: "Bearer " + wrongAudJWT,
+			code: http.StatusUnauthorized, errCode: authErrInvalidCredentials},
+		{method: http.MethodGet, url: "/v1/memos", cn: "billing", code: http.StatusOK},
+		{method: http.MethodGet, url: "/v1/memos", cn: "unknown", code: http.StatusUnauthorized, errCode: authErrUnauthenticated},
+		{method: http.MethodDelete, url: "/v1/memos?dry_run=true", header: "X-API-Key", value: "read-key",
+			code: http.StatusForbidden, errCode: authErrForbidden},
+		{method: http.MethodDelete, url: "/v1/memos?dry_run=true", header: "X-API-Key", value: "admin-key", code: http.StatusOK},
+		{method: http.MethodDelete, url: "/v1/jobs/1", header: "X-API-Key", value: "read-key", code: http.StatusForbidden, errCode: authErrForbidden},
+		{method: http.MethodDelete, url: "/v1/jobs/1", header: "X-API-Key", value: "admin-key", code: http.StatusNotFound},
+		{method: http.MethodGet, url: "/v1/memos?limit=abc", code: http.StatusUnauthorized, errCode: authErrUnauthenticated},
+		{method: http.MethodDelete, url: "/v1/memos?dry_run=maybe", header: "X-API-Key", value: "read-key",
+			code: http.StatusForbidden, errCode: authErrForbidden},
+		{method: http.MethodGet, url: "/v1/memos?limit=abc", header: "X-API-Key", value: "read-key",
+			code: http.StatusBadRequest, errCode: problemInvalidRequest},
+		{method: http.MethodGet, url: "/healthz", header: "X-API-Key", value: "bad-key", code: http.StatusOK},
+		{method: http.MethodGet, url: "/readyz", header: "Authorization", value: "Bearer " + expiredJWT, code: http.StatusOK},
+	} {
+		req := httptest.NewRequest(v.method, v.url, nil)
+		if v.header != "" {
+			req.Header.Set(v.header, v.value)
+		}
+		if v.cn != "" {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: v.cn}}
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Fatalf("%d: expected status %d, got %d: %s", i, v.code, w.Code, w.Body)
+		}
+		if v.errCode == "" {
+			continue
+		}
+		var resp AuthErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if resp.Code != v.errCode {
+			t.Fatalf("%d: expected error %q, got %q", i, v.errCode, resp.Code)
+		}
+		if v.code == http.StatusUnauthorized && v.errCode == authErrUnauthenticated &&
+			w.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("%d: expected a WWW-Authenticate header", i)
+		}
+	}
+}
+
+// Tests invalid key hashes and roles are rejected.
+func TestAuthConfig(t *testing.T) {
+	for i, cfg := range []AuthConfig{
+		{APIKeys: []APIKey{{Name: "short", Hash: "abcd", Roles: []string{RoleReader}}}},
+		{APIKeys: []APIKey{{Name: "role", Hash: hashKey("k"), Roles: []string{"root"}}}},
+		{ClientCertRoles: map[string][]string{"cn": {"superuser"}}},
+	} {
+		if _, err := NewAuthenticator(cfg); err == nil {
+			t.Fatalf("%d: expected invalid config", i)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gdotgordon/fibsrv/apitypes"
+	"github.com/gdotgordon/fibsrv/service"
+)
+
+// Bounds on a batch request.
+const (
+	maxBatchItems = 1000
+	maxBatchBytes = 1 << 20
+)
+
+// The bodies of a batch.  A plain JSON array of n values is also accepted
+// as the request.
+type (
+	BatchRequest    = apitypes.BatchRequest
+	BatchResponse   = apitypes.BatchResponse
+	BatchResult     = apitypes.BatchResult
+	BatchLessResult = apitypes.BatchLessResult
+)
+
+// The results of a batch, which are also encoded as a table.
+type batchResult struct {
+	BatchResponse
+}
+
+// Computes fib(n) for many n, and optionally FibLess for many targets, at
+// once.  Items that can't be computed have their own errors, while the
+// whole request fails if it exceeds the limits on the work.
+func (a apiImpl) fibBatch(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeBatch(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	if err != nil {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, err)
+		return
+	}
+	if cnt := len(req.N) + len(req.Targets); cnt == 0 || cnt > maxBatchItems {
+		a.writeProblem(w, r, http.StatusRequestEntityTooLarge, problemBatchSize,
+			fmt.Errorf("a batch must have between 1 and %d items, not %d", maxBatchItems, cnt))
+		return
+	}
+
+	items, less, err := a.service.FibBatch(r.Context(), req.N, req.Targets)
+	if err != nil {
+		a.writeComputeError(w, r, err)
+		return
+	}
+	a.respond(w, r, http.StatusOK, batchResult{batchResponse(items, less)})
+}
+
+// As a table, the results are followed by the FibLess results, with the
+// columns of the other kind left empty.
+func (br batchResult) csvRecords() [][]string {
+	recs := [][]string{{"n", "value", "target", "count", "error", "limit"}}
+	for _, res := range br.Results {
+		val := ""
+		if res.Value != nil {
+			val = strconv.FormatUint(*res.Value, 10)
+		}
+		recs = append(recs, []string{strconv.Itoa(res.N), val, "", "", res.Error, res.Limit})
+	}
+	for _, res := range br.Less {
+		cnt := ""
+		if res.Count != nil {
+			cnt = strconv.Itoa(*res.Count)
+		}
+		recs = append(recs, []string{"", "", strconv.FormatUint(res.Target, 10), cnt, res.Error, res.Limit})
+	}
+	return recs
+}
+
+// Converts the results of a batch to the response.
+func batchResponse(items []service.BatchItem, less []service.BatchLessItem) BatchResponse {
+	resp := BatchResponse{Results: make([]BatchResult, len(items))}
+	for i, it := range items {
+		res := &resp.Results[i]
+		res.N = it.N
+		if it.Err != nil {
+			res.Error, res.Limit = it.Err.Error(), limitName(it.Err)
+		} else {
+			v := it.Value
+			res.Value = &v
+		}
+	}
+	for _, it := range less {
+		res := BatchLessResult{Target: it.Target}
+		if it.Err != nil {
+			res.Error, res.Limit = it.Err.Error(), limitName(it.Err)
+		} else {
+			c := it.Count
+			res.Count = &c
+		}
+		resp.Less = append(resp.Less, res)
+	}
+	return resp
+}
+
+// Decodes either a BatchRequest or a plain array of n values.
+func decodeBatch(r io.Reader) (BatchRequest, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return BatchRequest{}, err
+	}
+	var req BatchRequest
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		err = json.Unmarshal(raw, &req.N)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&req)
+	}
+	if err != nil {
+		return BatchRequest{}, fmt.Errorf("invalid batch: %v", err)
+	}
+	return req, nil
+}
+
+// The limit an item exceeded, if any.
+func limitName(err error) string {
+	var le *service.LimitError
+	if errors.As(err, &le) {
+		return le.Limit
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package api
+
+// fib(n) never changes, so its responses are cached for good, with an
+// ETag for conditional requests.  The other responses depend on the memos,
+// or change them, and are never stored.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The sequence in the ETags of fib(n), so they change if the service ever
+// serves another one.
+const fibSequence = "fib"
+
+// Cache-Control values.  The results are private when the callers are
+// authenticated, so a shared cache doesn't serve them to others.
+const (
+	cacheImmutable        = "public, max-age=31536000, immutable"
+	cacheImmutablePrivate = "private, max-age=31536000, immutable"
+	cacheNoStore          = "no-store"
+)
+
+// Makes the responses uncacheable unless the handler says otherwise.
+func noStoreMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", cacheNoStore)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns the strong ETag of fib(n) in the representation.  The encodings
+// are deterministic, so the tag only depends on n and the media type.
+func fibETag(n int, rep representation) string {
+	h := sha256.Sum256([]byte(rep.enc.ContentType() + "\x00" + strconv.FormatBool(rep.indent)))
+	return `"` + fibSequence + "." + strconv.Itoa(n) + "." + hex.EncodeToString(h[:4]) + `"`
+}
+
+// Sets the caching headers of a fib(n) response.
+func (a apiImpl) cacheFib(w http.ResponseWriter, etag string) {
+	cc := cacheImmutable
+	if a.auth != nil {
+		cc = cacheImmutablePrivate
+	}
+	w.Header().Set("Cache-Control", cc)
+	w.Header().Set("ETag", etag)
+}
+
+// Reports whether the If-None-Match header matches the ETag, with the weak
+// comparison RFC 7232 requires for it.
+func etagMatch(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
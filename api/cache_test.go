@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Tests fib(n) is cached for good with an ETag per representation, and
+// the other responses are never stored.
+func TestCaching(t *testing.T) {
+	r, logs := newSpecRouter(t)
+	call := func(method, url, accept, inm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call(http.MethodGet, "/v1/fib?n=20", "", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || !strings.HasPrefix(etag, `"fib.20.`) ||
+		w.Header().Get("Cache-Control") != cacheImmutable {
+		t.Fatalf("expected an immutable result, got %d %q %q", w.Code, etag, w.Header().Get("Cache-Control"))
+	}
+	if again := call(http.MethodGet, "/v1/fib?n=20", "", "").Header().Get("ETag"); again != etag {
+		t.Fatalf("expected a stable ETag, got %q and %q", etag, again)
+	}
+	for _, accept := range []string{"application/json", "text/plain", "application/cbor"} {
+		if other := call(http.MethodGet, "/v1/fib?n=20", accept, "").Header().Get("ETag"); other == etag {
+			t.Fatalf("expected the ETag of %s to differ", accept)
+		}
+	}
+	if other := call(http.MethodGet, "/v1/fib?n=21", "", "").Header().Get("ETag"); other == etag {
+		t.Fatal("expected the ETag of another n to differ")
+	}
+
+	// Conditional requests.
+	for i, v := range []struct {
+		accept, inm string
+		code        int
+	}{
+		{"", etag, http.StatusNotModified},
+		{"", `"other", W/` + etag, http.StatusNotModified},
+		{"", "*", http.StatusNotModified},
+		{"", `"other"`, http.StatusOK},
+		{"text/plain", etag, http.StatusOK},
+	} {
+		w := call(http.MethodGet, "/v1/fib?n=20", v.accept, v.inm)
+		if w.Code != v.code {
+			t.Fatalf("%d: expected status %d, got %d", i, v.code, w.Code)
+		}
+		if w.Header().Get("ETag") == "" || w.Header().Get("Cache-Control") != cacheImmutable {
+			t.Fatalf("%d: expected the caching headers, got %v", i, w.Header())
+		}
+		if v.code == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Fatalf("%d: expected no body, got %s", i, w.Body)
+		}
+	}
+
+	// The limits apply to conditional requests too.
+	if w := call(http.MethodGet, "/v1/fib?n=90", "", "*"); w.Code == http.StatusNotModified ||
+		w.Code != call(http.MethodGet, "/v1/fib?n=90", "", "").Code {
+		t.Fatalf("expected fib(90) to be over the limits, got %d", w.Code)
+	}
+
+	// Errors, and the results that depend on the memos, are never stored,
+	// whatever the request.
+	for i, url := range []string{"/v1/fib?n=90", "/v1/fib?n=99999999", "/v1/fibless?target=100",
+		clearURL, memosURL, memosURL + "/10", "/v1/nothing"} {
+		w := call(http.MethodGet, url, "", etag)
+		if w.Header().Get("Cache-Control") != cacheNoStore || w.Header().Get("ETag") != "" {
+			t.Fatalf("%d: expected %s not to be stored, got %d %v", i, url, w.Code, w.Header())
+		}
+	}
+
+	if bad := logs.FilterMessage("response does not match the OpenAPI document"); bad.Len() > 0 {
+		t.Fatalf("responses don't match the document: %v", bad.All()[0].ContextMap())
+	}
+}
+
+// Tests the results are only cached privately for authenticated callers.
+func TestCachingPrivate(t *testing.T) {
+	auth, err := NewAuthenticator(AuthConfig{
+		APIKeys: []APIKey{{Name: "reporting", Hash: hashKey("read-key"), Roles: []string{RoleReader}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter(t, Config{Auth: auth}, testSetup{})
+	req := httptest.NewRequest(http.MethodGet, "/v1/fib?n=10", nil)
+	req.Header.Set("X-API-Key", "read-key")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != cacheImmutablePrivate {
+		t.Fatalf("expected a private result, got %d %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
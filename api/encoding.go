@@ -0,0 +1,297 @@
+package api
+
+// Responses are encoded in the media type negotiated from the Accept
+// header.  The handlers only pass their response to respond, and each
+// encoder decides which responses it can represent, so a format is added
+// by adding its encoder.
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// Encoder encodes responses in a media type.
+type Encoder interface {
+	// ContentType is the Content-Type of the encoded responses, such as
+	// "text/csv; charset=UTF-8".
+	ContentType() string
+
+	// CanEncode tells whether the response has a representation in the
+	// media type, without encoding it.
+	CanEncode(v interface{}) bool
+
+	// Encode returns the encoding of the response, or ErrNotEncodable if
+	// the response has no representation in the media type.
+	Encode(v interface{}) ([]byte, error)
+}
+
+// ErrNotEncodable is returned by an Encoder for the responses it can't
+// represent.
+var ErrNotEncodable = errors.New("the response can't be represented in the media type")
+
+// The encoders of the media types served by default, in order of
+// preference.
+func defaultEncoders() []Encoder {
+	cborMode, err := cbor.EncOptions{Sort: cbor.SortCanonical, Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return []Encoder{jsonEncoder{}, textEncoder{}, csvEncoder{}, cborEncoder{cborMode}, protoEncoder{}}
+}
+
+// Adds the encoders to the defaults, replacing those of the same media
+// type.
+func withEncoders(encs []Encoder) []Encoder {
+	res := defaultEncoders()
+	for _, e := range encs {
+		replaced := false
+		for i, d := range res {
+			if encoderType(d) == encoderType(e) {
+				res[i], replaced = e, true
+			}
+		}
+		if !replaced {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Returns the media type of the encoder, without parameters.
+func encoderType(e Encoder) string {
+	mt, _, err := mime.ParseMediaType(e.ContentType())
+	if err != nil {
+		return e.ContentType()
+	}
+	return mt
+}
+
+// A representation of a response: the encoder, and whether JSON is
+// indented.
+type representation struct {
+	enc    Encoder
+	indent bool
+}
+
+// Returns the encoding of the response.
+func (rep representation) encode(v interface{}) ([]byte, error) {
+	b, err := rep.enc.Encode(v)
+	if err != nil || !rep.indent {
+		return b, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Returns the best representation of the response the caller accepts.
+// JSON is indented unless it is asked for by name, so it is readable in
+// browsers and curl.  The response only needs to be of the right type, as
+// the encoders can't refuse a response for its value.
+func (a apiImpl) negotiate(r *http.Request, v interface{}) (representation, bool) {
+	ranges := parseAccept(r.Header.Get("Accept"))
+	type candidate struct {
+		enc   Encoder
+		q     float64
+		named bool
+	}
+	var cands []candidate
+	for _, e := range a.encoders {
+		if q, named := quality(ranges, encoderType(e)); q > 0 {
+			cands = append(cands, candidate{e, q, named})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].q > cands[j].q })
+	for _, c := range cands {
+		if c.enc.CanEncode(v) {
+			_, isJSON := c.enc.(jsonEncoder)
+			return representation{enc: c.enc, indent: isJSON && !c.named}, true
+		}
+	}
+	return representation{}, false
+}
+
+// Writes the response with the status code, in the best media type the
+// caller accepts that can represent it.
+func (a apiImpl) respond(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+	rep, ok := a.negotiate(r, v)
+	if !ok {
+		var types []string
+		for _, e := range a.encoders {
+			if e.CanEncode(v) {
+				types = append(types, encoderType(e))
+			}
+		}
+		a.writeProblem(w, r, http.StatusNotAcceptable, problemNotAcceptable,
+			fmt.Errorf("the response is available as %s", strings.Join(types, ", ")))
+		return
+	}
+	b, err := rep.encode(v)
+	if err != nil {
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+		return
+	}
+	w.Header().Set("Content-Type", rep.enc.ContentType())
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// A media range of the Accept header.
+type mediaRange struct {
+	typ string
+	q   float64
+}
+
+// Parses the Accept header.  A missing header accepts anything.
+func parseAccept(h string) []mediaRange {
+	if strings.TrimSpace(h) == "" {
+		return []mediaRange{{"*/*", 1}}
+	}
+	var res []mediaRange
+	for _, part := range strings.Split(h, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		res = append(res, mediaRange{mt, q})
+	}
+	return res
+}
+
+// Returns the quality of the media type, from the most specific range
+// matching it, and whether that range names it rather than a wildcard.
+func quality(ranges []mediaRange, mt string) (float64, bool) {
+	q, best := 0.0, 0
+	for _, r := range ranges {
+		spec := 0
+		switch {
+		case r.typ == mt:
+			spec = 3
+		case strings.HasSuffix(r.typ, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(r.typ, "*")):
+			spec = 2
+		case r.typ == "*/*":
+			spec = 1
+		}
+		if spec > best {
+			q, best = r.q, spec
+		}
+	}
+	return q, best == 3
+}
+
+// The responses that have a representation as plain text.
+type plainTexter interface {
+	plainText() string
+}
+
+// The responses that have a representation as a table.  The first record
+// is the header.
+type csvTable interface {
+	csvRecords() [][]string
+}
+
+// The responses that have a representation as a protobuf message.
+type protoMessager interface {
+	protoMessage() proto.Message
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json; charset=UTF-8" }
+
+func (jsonEncoder) CanEncode(v interface{}) bool { return true }
+
+func (jsonEncoder) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+type textEncoder struct{}
+
+func (textEncoder) ContentType() string { return "text/plain; charset=UTF-8" }
+
+func (textEncoder) CanEncode(v interface{}) bool {
+	_, ok := v.(plainTexter)
+	return ok
+}
+
+func (textEncoder) Encode(v interface{}) ([]byte, error) {
+	pt, ok := v.(plainTexter)
+	if !ok {
+		return nil, ErrNotEncodable
+	}
+	return []byte(pt.plainText() + "\n"), nil
+}
+
+type csvEncoder struct{}
+
+func (csvEncoder) ContentType() string { return "text/csv; charset=UTF-8" }
+
+func (csvEncoder) CanEncode(v interface{}) bool {
+	_, ok := v.(csvTable)
+	return ok
+}
+
+func (csvEncoder) Encode(v interface{}) ([]byte, error) {
+	t, ok := v.(csvTable)
+	if !ok {
+		return nil, ErrNotEncodable
+	}
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	if err := cw.WriteAll(t.csvRecords()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CBOR has a representation of every response, with the field names of
+// the JSON.
+type cborEncoder struct {
+	mode cbor.EncMode
+}
+
+func (cborEncoder) ContentType() string { return "application/cbor" }
+
+func (cborEncoder) CanEncode(v interface{}) bool { return true }
+
+func (ce cborEncoder) Encode(v interface{}) ([]byte, error) {
+	return ce.mode.Marshal(v)
+}
+
+// Protobuf uses the messages of the gRPC api.
+type protoEncoder struct{}
+
+func (protoEncoder) ContentType() string { return "application/x-protobuf" }
+
+func (protoEncoder) CanEncode(v interface{}) bool {
+	_, ok := v.(protoMessager)
+	return ok
+}
+
+func (protoEncoder) Encode(v interface{}) ([]byte, error) {
+	pm, ok := v.(protoMessager)
+	if !ok {
+		return nil, ErrNotEncodable
+	}
+	return proto.Marshal(pm.protoMessage())
+}
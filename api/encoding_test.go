@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gdotgordon/fibsrv/fibpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// Tests the responses are encoded in the negotiated media type.
+func TestContentNegotiation(t *testing.T) {
+	r, logs := newSpecRouter(t)
+	call := func(method, url, accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, v := range []struct {
+		accept, ctype, body string
+	}{
+		{"", "application/json", "{\n  \"result\": 6765\n}"},
+		{"*/*", "application/json", "{\n  \"result\": 6765\n}"},
+		{"application/json", "application/json", `{"result":6765}`},
+		{"text/plain", "text/plain", "6765\n"},
+		{"text/*;q=0.5, application/json", "application/json", `{"result":6765}`},
+		{"application/cbor;q=0.9, text/plain;q=0.1", "application/cbor", ""},
+		{"application/x-protobuf", "application/x-protobuf", ""},
+	} {
+		w := call(http.MethodGet, "/v1/fib?n=20", v.accept, "")
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), v.ctype) {
+			t.Fatalf("%d: expected %s, got %d %q", i, v.ctype, w.Code, w.Header().Get("Content-Type"))
+		}
+		if v.body != "" && w.Body.String() != v.body {
+			t.Fatalf("%d: expected %q, got %q", i, v.body, w.Body)
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Fatalf("%d: expected to vary by Accept", i)
+		}
+		switch v.ctype {
+		case "application/cbor":
+			var resp ResultResponse
+			if err := cbor.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Result != 6765 {
+				t.Fatalf("%d: unexpected CBOR: %v %+v", i, err, resp)
+			}
+		case "application/x-protobuf":
+			var resp fibpb.FibResponse
+			if err := proto.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.N != 20 || resp.Value != 6765 {
+				t.Fatalf("%d: unexpected protobuf: %v %+v", i, err, &resp)
+			}
+		}
+	}
+
+	// The tables, with the cursor of the next page in a header.
+	w := call(http.MethodGet, memosURL+"?limit=5", "text/csv", "")
+	recs, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 6 || recs[0][0] != "n" || recs[1][0] != "0" || recs[5][1] != "3" {
+		t.Fatalf("unexpected memos: %q", recs)
+	}
+	if link := w.Header().Get("Link"); !strings.Contains(link, "after=") || !strings.Contains(link, `rel="next"`) {
+		t.Fatalf("expected a link to the next page, got %q", link)
+	}
+	w = call(http.MethodPost, batchURL, "text/csv", `{"n": [10, 94], "targets": [100]}`)
+	want := "n,value,target,count,error,limit\n10,55,,,,\n94,,,,"
+	if !strings.HasPrefix(w.Body.String(), want) || !strings.Contains(w.Body.String(), "\n,,100,") {
+		t.Fatalf("unexpected batch: %q", w.Body)
+	}
+
+	// A memo has no plain text representation.
+	w = call(http.MethodGet, memosURL+"/10", "text/plain", "")
+	if w.Code != http.StatusNotAcceptable || !strings.Contains(w.Body.String(), problemNotAcceptable) ||
+		!strings.Contains(w.Body.String(), "application/cbor") {
+		t.Fatalf("expected 406, got %d: %s", w.Code, w.Body)
+	}
+
+	if bad := logs.FilterMessage("response does not match the OpenAPI document"); bad.Len() > 0 {
+		t.Fatalf("responses don't match the document: %v", bad.All()[0].ContextMap())
+	}
+}
+
+// An encoder of every response in Go syntax.
+type goEncoder struct{}
+
+func (goEncoder) ContentType() string { return "text/x-go" }
+
+func (goEncoder) CanEncode(v interface{}) bool { return true }
+
+func (goEncoder) Encode(v interface{}) ([]byte, error) {
+	return []byte(fmt.Sprintf("%#v", v)), nil
+}
+
+// Tests an encoder is added without changing the handlers.
+func TestEncoders(t *testing.T) {
+	r := newTestRouter(t, Config{Encoders: []Encoder{goEncoder{}}}, testSetup{})
+	req := httptest.NewRequest(http.MethodGet, "/v1/fib?n=10", nil)
+	req.Header.Set("Accept", "text/x-go")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Result:0x37") {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+}
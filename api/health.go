@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/store"
+)
+
+// Definitions for the probe endpoints.
+const (
+	healthURL = "/healthz" // liveness
+	readyURL  = "/readyz"  // readiness
+)
+
+// How long the readiness check waits for the store to respond.
+const pingTimeout = 2 * time.Second
+
+// HealthResponse is the JSON returned by the liveness and readiness probes.
+// The store related fields are only filled in if the store supports them.
+type HealthResponse struct {
+	Status        string       `json:"status"`
+	Store         string       `json:"store,omitempty"`
+	SchemaVersion int          `json:"schema_version,omitempty"`
+	DBPool        *DBPoolStats `json:"db_pool,omitempty"`
+}
+
+// DBPoolStats are the database connection pool statistics.
+type DBPoolStats struct {
+	MaxOpen      int     `json:"max_open"`
+	Open         int     `json:"open"`
+	InUse        int     `json:"in_use"`
+	Idle         int     `json:"idle"`
+	WaitCount    int64   `json:"wait_count"`
+	WaitDuration float64 `json:"wait_duration_seconds"`
+}
+
+// Health tracks the readiness of the server.  It is ready as long as the
+// store responds, until SetNotReady is called at shutdown.
+type Health struct {
+	store    store.Store
+	notReady int32
+
+	mu         sync.Mutex
+	onNotReady []func()
+}
+
+// NewHealth returns the health of a server using the store.  This should
+// be the underlying store and not a decorator, so the optional
+// store.Pinger interface is visible.
+func NewHealth(s store.Store) *Health {
+	return &Health{store: s}
+}
+
+// SetNotReady makes the readiness probe fail, so load balancers stop
+// sending traffic before the server shuts down.
+func (h *Health) SetNotReady() {
+	atomic.StoreInt32(&h.notReady, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, f := range h.onNotReady {
+		f()
+	}
+}
+
+// OnNotReady registers a function called by SetNotReady, so other servers,
+// such as gRPC, fail their health checks along with this one.
+func (h *Health) OnNotReady(f func()) {
+	h.mu.Lock()
+	h.onNotReady = append(h.onNotReady, f)
+	h.mu.Unlock()
+}
+
+// Liveness only shows the server is up and handling requests.
+func (h *Health) live(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readiness checks the store, if it can be checked, and reports its
+// connection pool statistics and schema version.
+func (h *Health) ready(w http.ResponseWriter, r *http.Request) {
+	resp := HealthResponse{Status: "ready"}
+	code := http.StatusOK
+
+	if p, ok := h.store.(store.Pinger); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+		defer cancel()
+		if err := p.Ping(ctx); err != nil {
+			resp.Status, resp.Store = "not ready", err.Error()
+			code = http.StatusServiceUnavailable
+		} else {
+			resp.Store = "ok"
+		}
+	}
+	if v, ok := h.store.(interface{ SchemaVersion() int }); ok {
+		resp.SchemaVersion = v.SchemaVersion()
+	}
+	if st, ok := h.store.(interface{ Stats() sql.DBStats }); ok {
+		s := st.Stats()
+		resp.DBPool = &DBPoolStats{
+			MaxOpen:      s.MaxOpenConnections,
+			Open:         s.OpenConnections,
+			InUse:        s.InUse,
+			Idle:         s.Idle,
+			WaitCount:    s.WaitCount,
+			WaitDuration: s.WaitDuration.Seconds(),
+		}
+	}
+	if atomic.LoadInt32(&h.notReady) != 0 {
+		resp.Status = "shutting down"
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, resp)
+}
+
+func writeHealth(w http.ResponseWriter, code int, resp HealthResponse) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	b, _ := json.MarshalIndent(resp, "", "  ")
+	w.Write(b)
+}
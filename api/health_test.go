@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gdotgordon/fibsrv/store"
+)
+
+// A map store whose database may be down.
+type pingStore struct {
+	store.Store
+	err error
+}
+
+func (ps *pingStore) Ping(context.Context) error {
+	return ps.err
+}
+
+// Tests the readiness follows the store, and fails once shutting down.
+func TestReadiness(t *testing.T) {
+	ps := &pingStore{Store: store.NewMap()}
+	h := NewHealth(ps)
+
+	for i, v := range []struct {
+		err      error
+		notReady bool
+		code     int
+		status   string
+	}{
+		{code: http.StatusOK, status: "ready"},
+		{err: errors.New("connection refused"), code: http.StatusServiceUnavailable, status: "not ready"},
+		{notReady: true, code: http.StatusServiceUnavailable, status: "shutting down"},
+	} {
+		ps.err = v.err
+		if v.notReady {
+			h.SetNotReady()
+		}
+		w := httptest.NewRecorder()
+		h.ready(w, httptest.NewRequest(http.MethodGet, readyURL, nil))
+		if w.Code != v.code {
+			t.Fatalf("%d: expected status code %d, got %d", i, v.code, w.Code)
+		}
+		var resp HealthResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != v.status {
+			t.Fatalf("%d: expected status %q, got %q", i, v.status, resp.Status)
+		}
+	}
+
+	// Liveness is unaffected.
+	w := httptest.NewRecorder()
+	h.live(w, httptest.NewRequest(http.MethodGet, healthURL, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected liveness status code 200, got %d", w.Code)
+	}
+}
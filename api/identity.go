@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"net/http"
+)
+
+// Identity is the verified identity of a client that presented a
+// certificate over mutual TLS.
+type Identity struct {
+	CommonName string   `json:"common_name"`
+	DNSNames   []string `json:"dns_names,omitempty"`
+	Emails     []string `json:"emails,omitempty"`
+	URIs       []string `json:"uris,omitempty"`
+}
+
+type identityKey struct{}
+
+// ClientIdentity returns the identity of the client making the request, if
+// it presented a certificate that was verified.  Handlers can use this for
+// authorization.
+func ClientIdentity(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Adds the identity from the verified client certificate to the context.
+// Unverified certificates are ignored.
+func identityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			cert := r.TLS.VerifiedChains[0][0]
+			id := Identity{
+				CommonName: cert.Subject.CommonName,
+				DNSNames:   cert.DNSNames,
+				Emails:     cert.EmailAddresses,
+			}
+			for _, u := range cert.URIs {
+				id.URIs = append(id.URIs, u.String())
+			}
+			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
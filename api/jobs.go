@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gdotgordon/fibsrv/apitypes"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gorilla/mux"
+)
+
+// The bodies of the jobs.
+type (
+	JobResponse = apitypes.JobResponse
+	JobProgress = apitypes.JobProgress
+)
+
+// Submits a job computing a batch, with the same body as a batch.  The
+// job is returned with its location, to be polled for the result.
+func (a apiImpl) submitJob(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeBatch(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	if err != nil {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, err)
+		return
+	}
+	if cnt := len(req.N) + len(req.Targets); cnt == 0 || cnt > maxBatchItems {
+		a.writeProblem(w, r, http.StatusRequestEntityTooLarge, problemBatchSize,
+			fmt.Errorf("a job must have between 1 and %d items, not %d", maxBatchItems, cnt))
+		return
+	}
+
+	job, err := a.jobs.Submit(req.N, req.Targets)
+	switch {
+	case errors.Is(err, service.ErrQueueFull) || errors.Is(err, service.ErrJobsClosed):
+		w.Header().Set("Retry-After", "1")
+		a.writeProblem(w, r, http.StatusServiceUnavailable, problemUnavailable, err)
+		return
+	case err != nil:
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+		return
+	}
+	w.Header().Set("Location", jobsURL+"/"+job.ID)
+	a.respond(w, r, http.StatusAccepted, jobResponse(job))
+}
+
+// Returns the state of a job, and its result once it has finished.
+func (a apiImpl) job(w http.ResponseWriter, r *http.Request) {
+	job, err := a.jobs.Get(mux.Vars(r)["id"])
+	if err != nil {
+		a.writeJobError(w, r, err)
+		return
+	}
+	a.respond(w, r, http.StatusOK, jobResponse(job))
+}
+
+// Cancels a job that is queued or running.
+func (a apiImpl) cancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := a.jobs.Cancel(mux.Vars(r)["id"])
+	if err != nil {
+		a.writeJobError(w, r, err)
+		return
+	}
+	a.respond(w, r, http.StatusOK, jobResponse(job))
+}
+
+func (a apiImpl) writeJobError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrJobNotFound):
+		a.writeProblem(w, r, http.StatusNotFound, problemNotFound, err)
+	case errors.Is(err, service.ErrJobFinished):
+		a.writeProblem(w, r, http.StatusConflict, problemJobFinished, err)
+	default:
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+	}
+}
+
+// Converts a job to the response.
+func jobResponse(job service.Job) JobResponse {
+	resp := JobResponse{
+		ID:       job.ID,
+		State:    string(job.State),
+		Progress: JobProgress{Done: job.Done, Total: job.Total},
+		Created:  job.Created,
+	}
+	if !job.Started.IsZero() {
+		resp.Started = &job.Started
+	}
+	if !job.Finished.IsZero() {
+		resp.Finished = &job.Finished
+	}
+	if job.Err != nil {
+		resp.Error, resp.Limit = job.Err.Error(), limitName(job.Err)
+	}
+	if job.State == service.JobSucceeded {
+		res := batchResponse(job.Items, job.Less)
+		resp.Result = &res
+	}
+	return resp
+}
@@ -0,0 +1,590 @@
+package api
+
+// The OpenAPI 3 document describing the api is built here, rather than
+// kept as a file, so it is compiled into the server and served at
+// /v1/openapi.json.  The same document drives the validation of requests,
+// and of responses in development.  Every route registered by Init must
+// be described, which the tests check.
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+)
+
+// The subset of OpenAPI 3 used to describe the api.
+type openAPI struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       apiInfo               `json:"info"`
+	Paths      map[string]pathItem   `json:"paths"`
+	Components components            `json:"components"`
+	Security   []map[string][]string `json:"security"`
+}
+
+type apiInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// A path item maps the lower case methods to their operations.
+type pathItem map[string]*operation
+
+type operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+	Parameters  []parameter         `json:"parameters,omitempty"`
+	RequestBody *requestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]response `json:"responses"`
+
+	// Security overrides that of the document, and is empty for the
+	// routes that are never authenticated.
+	Security *[]map[string][]string `json:"security,omitempty"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Headers     map[string]header    `json:"headers,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type header struct {
+	Description string  `json:"description"`
+	Schema      *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	OneOf                []*schema          `json:"oneOf,omitempty"`
+	AnyOf                []*schema          `json:"anyOf,omitempty"`
+
+	// The compiled Pattern, for validating the values.
+	re *regexp.Regexp
+}
+
+type components struct {
+	Schemas         map[string]*schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Helpers for building the document.
+
+func ref(name string) *schema {
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+func bound(v float64) *float64 {
+	return &v
+}
+
+func str(desc string) *schema {
+	return &schema{Type: "string", Description: desc}
+}
+
+// A string matching the pattern, which is compiled once here rather than
+// for every value validated.
+func pattern(p, desc string) *schema {
+	return &schema{Type: "string", Pattern: p, Description: desc, re: regexp.MustCompile(p)}
+}
+
+func integer(desc string) *schema {
+	return &schema{Type: "integer", Description: desc}
+}
+
+func natural(desc string) *schema {
+	return &schema{Type: "integer", Minimum: bound(0), Description: desc}
+}
+
+func dateTime(desc string) *schema {
+	return &schema{Type: "string", Format: "date-time", Description: desc}
+}
+
+func array(items *schema) *schema {
+	return &schema{Type: "array", Items: items}
+}
+
+func object(required []string, props map[string]*schema) *schema {
+	return &schema{Type: "object", Required: required, Properties: props}
+}
+
+// An object with no properties other than those listed.
+func closedObject(required []string, props map[string]*schema) *schema {
+	closed := false
+	s := object(required, props)
+	s.AdditionalProperties = &closed
+	return s
+}
+
+func jsonContent(s *schema) map[string]mediaType {
+	return map[string]mediaType{"application/json": {Schema: s}}
+}
+
+func jsonResponse(desc string, s *schema) response {
+	return response{Description: desc, Content: jsonContent(s)}
+}
+
+func problemResponse(desc string, s *schema) response {
+	return response{Description: desc, Content: map[string]mediaType{problemContentType: {Schema: s}}}
+}
+
+// The schema of a problem, with the members added by the kind of problem.
+func problemSchema(codes []string, required []string, extra map[string]*schema) *schema {
+	props := map[string]*schema{
+		"type":       str("A URI identifying the kind of problem."),
+		"title":      str("A summary of the kind of problem."),
+		"status":     integer("The HTTP status code."),
+		"detail":     str("What went wrong with this request."),
+		"instance":   str("The path of the request."),
+		"code":       &schema{Type: "string", Enum: codes, Description: "The stable code of the kind of problem."},
+		"request_id": str("The ID of the request, as in the X-Request-ID header."),
+	}
+	for name, s := range extra {
+		props[name] = s
+	}
+	return object(append([]string{"type", "title", "status", "code"}, required...), props)
+}
+
+func query(name, desc string, required bool, s *schema) parameter {
+	return parameter{Name: name, In: "query", Description: desc, Required: required, Schema: s}
+}
+
+func pathParam(name, desc string, s *schema) parameter {
+	return parameter{Name: name, In: "path", Description: desc, Required: true, Schema: s}
+}
+
+var noSecurity = &[]map[string][]string{}
+
+// The headers of the cached responses.
+var cacheHeaders = map[string]header{
+	"ETag":          {Description: "The tag of the result in the media type.", Schema: str("")},
+	"Cache-Control": {Description: "The result is immutable.", Schema: str("")},
+}
+
+// The error responses, by status code.
+var errorResponses = map[int]response{
+	http.StatusBadRequest:   problemResponse("The request is invalid.", ref("Problem")),
+	http.StatusUnauthorized: problemResponse("The caller is not authenticated.", ref("AuthError")),
+	http.StatusForbidden:    problemResponse("The caller lacks the required role.", ref("AuthError")),
+	http.StatusNotFound:     problemResponse("There is no such resource.", ref("Problem")),
+	http.StatusNotAcceptable: problemResponse("The response is not available in an accepted media type.",
+		ref("Problem")),
+	http.StatusConflict: problemResponse("The resource is in a conflicting state.", ref("Problem")),
+	http.StatusRequestEntityTooLarge: problemResponse(
+		"The request is too large, or its computation exceeds the configured limits.",
+		&schema{AnyOf: []*schema{ref("Limit"), ref("Problem")}}),
+	http.StatusUnprocessableEntity: problemResponse("The result is not representable.", ref("Limit")),
+	http.StatusTooManyRequests: {
+		Description: "The caller is over its rate limit or daily quota.",
+		Headers: map[string]header{"Retry-After": {
+			Description: "Seconds until the request may be retried.", Schema: integer("")}},
+		Content: problemResponse("", ref("RateLimited")).Content,
+	},
+	http.StatusInternalServerError: problemResponse("An unexpected error occurred.", ref("Problem")),
+	http.StatusServiceUnavailable: {
+		Description: "The server can't take the request at the moment.",
+		Headers: map[string]header{"Retry-After": {
+			Description: "Seconds until the request may be retried.", Schema: integer("")}},
+		Content: problemResponse("", ref("Problem")).Content,
+	},
+}
+
+// Builds the responses of an operation from its success response and the
+// codes of its errors.
+func responses(code int, ok response, errs ...int) map[string]response {
+	res := map[string]response{statusKey(code): ok}
+	for _, c := range errs {
+		res[statusKey(c)] = errorResponses[c]
+	}
+	return res
+}
+
+func statusKey(code int) string {
+	return strconv.Itoa(code)
+}
+
+// The errors of every authenticated route.
+var authErrors = []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError}
+
+// The errors of the authenticated routes with negotiated responses.
+func withAuth(codes ...int) []int {
+	return append(append(codes, http.StatusNotAcceptable), authErrors...)
+}
+
+// The media types other than JSON that responses may be negotiated in.
+const (
+	textType  = "text/plain"
+	csvType   = "text/csv"
+	cborType  = "application/cbor"
+	protoType = "application/x-protobuf"
+)
+
+// Adds the other media types of a response.  Their schemas are those of
+// the formats, rather than of the JSON.
+func formats(res response, types ...string) response {
+	for _, t := range types {
+		s := str("")
+		switch t {
+		case cborType:
+			s = &schema{Type: "string", Format: "binary", Description: "The JSON fields, in CBOR."}
+		case protoType:
+			s = &schema{Type: "string", Format: "binary", Description: "The message of the gRPC api."}
+		case csvType:
+			s = str("A header row, then a row per item.")
+		}
+		res.Content[t] = mediaType{Schema: s}
+	}
+	return res
+}
+
+// Builds the document.  It is built once and not changed afterwards.
+func newOpenAPI() *openAPI {
+	batchSpec := &schema{
+		Description: "The n values to compute, and the FibLess targets, as an object, " +
+			"or the n values alone as an array, with at most " + strconv.Itoa(maxBatchItems) +
+			" items in all.",
+		OneOf: []*schema{
+			closedObject(nil, map[string]*schema{
+				"n":       array(integer("")),
+				"targets": array(natural("")),
+			}),
+			array(integer("")),
+		},
+	}
+	limits := []string{service.LimitRange, service.LimitMaxN, service.LimitWrites, service.LimitCPUTime}
+	jobStates := []string{"queued", "running", "succeeded", "failed", "cancelled"}
+
+	doc := &openAPI{
+		OpenAPI: "3.0.3",
+		Info: apiInfo{
+			Title:   "fibsrv",
+			Version: "1.0.0",
+			Description: "Computes memoized Fibonacci numbers.  Authentication and rate limits " +
+				"apply only if the server is configured with them, and some routes are only " +
+				"served if enabled.",
+		},
+		Security: []map[string][]string{{"apiKey": {}}, {"bearer": {}}},
+		Components: components{
+			SecuritySchemes: map[string]securityScheme{
+				"apiKey": {Type: "apiKey", In: "header", Name: "X-API-Key",
+					Description: "A static API key."},
+				"bearer": {Type: "http", Scheme: "bearer",
+					Description: "A JWT signed with HS256, HS384 or HS512, or a static API key."},
+			},
+			Schemas: map[string]*schema{
+				"Problem": problemSchema(problemCodes, nil, nil),
+				"Result": object([]string{"result"}, map[string]*schema{
+					"result": natural("")}),
+				"Limit": problemSchema([]string{problemLimitExceeded}, []string{"limit", "n"}, map[string]*schema{
+					"limit":        &schema{Type: "string", Enum: limits},
+					"n":            integer("The largest n of the request."),
+					"writes":       natural("The memos the request would store."),
+					"estimated_ms": natural("The estimated time of the request."),
+				}),
+				"AuthError": problemSchema([]string{authErrUnauthenticated, authErrInvalidCredentials,
+					authErrForbidden}, nil, map[string]*schema{
+					"required_role": &schema{Type: "string", Enum: []string{RoleReader, RoleWriter, RoleAdmin}},
+				}),
+				"RateLimited": problemSchema([]string{limitErrRateLimited, limitErrQuotaExceeded},
+					[]string{"retry_after"}, map[string]*schema{
+						"retry_after": natural("Seconds until the request may be retried."),
+					}),
+				"Memo": object([]string{"n", "value", "created_at", "hit_count", "writer_id"},
+					map[string]*schema{
+						"n":                natural(""),
+						"value":            natural("fib(n)"),
+						"created_at":       dateTime("When the memo was written."),
+						"last_accessed_at": dateTime("When the memo was last read."),
+						"hit_count":        natural("How often the memo was read."),
+						"writer_id":        str("The instance that wrote the memo."),
+					}),
+				"MemoList": object([]string{"memos"}, map[string]*schema{
+					"memos": array(ref("Memo")),
+					"next":  str("The cursor of the next page, if there is one."),
+				}),
+				"Clear": object([]string{"count", "dry_run"}, map[string]*schema{
+					"count":   natural("The memos removed, or that would be for a dry run."),
+					"dry_run": &schema{Type: "boolean"},
+					"confirm": str("The token confirming the removal, for a dry run."),
+				}),
+				"BatchRequest": batchSpec,
+				"BatchResponse": object([]string{"results"}, map[string]*schema{
+					"results": array(object([]string{"n"}, map[string]*schema{
+						"n":     integer(""),
+						"value": natural("fib(n), unless there is an error."),
+						"error": str(""),
+						"limit": &schema{Type: "string", Enum: limits},
+					})),
+					"less": array(object([]string{"target"}, map[string]*schema{
+						"target": natural(""),
+						"count":  natural("The memos less than the target, unless there is an error."),
+						"error":  str(""),
+						"limit":  &schema{Type: "string", Enum: limits},
+					})),
+				}),
+				"Job": object([]string{"id", "state", "progress", "created"}, map[string]*schema{
+					"id":    str(""),
+					"state": &schema{Type: "string", Enum: jobStates},
+					"progress": object([]string{"done", "total"}, map[string]*schema{
+						"done":  natural("The steps of the sequence computed."),
+						"total": natural("The steps in all, zero until the job starts."),
+					}),
+					"created":  dateTime(""),
+					"started":  dateTime(""),
+					"finished": dateTime(""),
+					"error":    str("Why the job failed or was cancelled."),
+					"limit":    &schema{Type: "string", Enum: limits},
+					"result":   ref("BatchResponse"),
+				}),
+				"QueueRequest": closedObject([]string{"to"}, map[string]*schema{
+					"from":         natural("The first n to precompute, 0 by default."),
+					"to":           natural("The last n to precompute."),
+					"max_attempts": natural("The attempts before the job is dead-lettered, 5 if zero."),
+				}),
+				"QueuedJob": object([]string{"id", "state", "from", "to", "attempts", "max_attempts",
+					"run_at", "created", "updated"}, map[string]*schema{
+					"id": natural(""),
+					"state": &schema{Type: "string", Enum: []string{store.QueuePending, store.QueueRunning,
+						store.QueueDone, store.QueueDead}},
+					"from":         natural(""),
+					"to":           natural(""),
+					"attempts":     natural(""),
+					"max_attempts": natural(""),
+					"run_at":       dateTime("When the job is next due."),
+					"lease_owner":  str("The worker running the job."),
+					"lease_until":  dateTime("When the lease of the worker expires."),
+					"last_error":   str(""),
+					"created":      dateTime(""),
+					"updated":      dateTime(""),
+				}),
+				"Health": object([]string{"status"}, map[string]*schema{
+					"status":         str(""),
+					"store":          str(""),
+					"schema_version": natural(""),
+					"db_pool":        &schema{Type: "object"},
+				}),
+			},
+		},
+	}
+
+	n := natural("")
+	jobID := pathParam("id", "The job ID.", pattern("^[0-9a-f]+$", ""))
+	doc.Paths = map[string]pathItem{
+		fibURL: {"get": {
+			OperationID: "fib", Tags: []string{"compute"},
+			Summary:     "Returns fib(n), computing and memoizing it as needed.",
+			Description: "The result never changes, so it is cached with an ETag per n and media type.",
+			Parameters: []parameter{query("n", "", true, n), {Name: "If-None-Match", In: "header",
+				Description: "The ETags of the cached results.", Schema: str("")}},
+			Responses: mergeResponses(
+				responses(http.StatusOK, formats(response{
+					Description: "fib(n)",
+					Headers:     cacheHeaders,
+					Content:     jsonContent(ref("Result")),
+				}, textType, cborType, protoType),
+					withAuth(http.StatusBadRequest, http.StatusRequestEntityTooLarge,
+						http.StatusUnprocessableEntity, http.StatusTooManyRequests)...),
+				map[string]response{statusKey(http.StatusNotModified): {
+					Description: "The cached result is still valid.", Headers: cacheHeaders}}),
+		}},
+		fibLessURL: {"get": {
+			OperationID: "fibLess", Tags: []string{"compute"},
+			Summary:    "Counts the memos less than the target.",
+			Parameters: []parameter{query("target", "", true, n)},
+			Responses: responses(http.StatusOK, formats(jsonResponse("The count.", ref("Result")), textType, cborType, protoType),
+				withAuth(http.StatusBadRequest, http.StatusRequestEntityTooLarge,
+					http.StatusUnprocessableEntity, http.StatusTooManyRequests)...),
+		}},
+		batchURL: {"post": {
+			OperationID: "fibBatch", Tags: []string{"compute"},
+			Summary:     "Computes fib(n) for many n, and FibLess for many targets, in one pass.",
+			RequestBody: &requestBody{Required: true, Content: jsonContent(ref("BatchRequest"))},
+			Responses: responses(http.StatusOK,
+				formats(jsonResponse("The results, in the order requested.", ref("BatchResponse")),
+					csvType, cborType),
+				withAuth(http.StatusBadRequest, http.StatusRequestEntityTooLarge,
+					http.StatusTooManyRequests)...),
+		}},
+		memosURL: {
+			"get": {
+				OperationID: "listMemos", Tags: []string{"memos"},
+				Summary: "Returns a page of memos in order of n.",
+				Parameters: []parameter{
+					query("after", "The cursor of the page.", false, str("")),
+					query("limit", "The page size.", false, &schema{Type: "integer",
+						Minimum: bound(1), Maximum: bound(maxListLimit)}),
+				},
+				Responses: responses(http.StatusOK, formats(response{
+					Description: "A page of memos.",
+					Headers: map[string]header{"Link": {
+						Description: "The URL of the next page, if there is one.", Schema: str("")}},
+					Content: jsonContent(ref("MemoList")),
+				}, csvType, cborType),
+					withAuth(http.StatusBadRequest, http.StatusTooManyRequests)...),
+			},
+			"delete": {
+				OperationID: "deleteMemos", Tags: []string{"memos"},
+				Summary:     "Removes the memos in a range, after a dry run to confirm it.",
+				Description: "Requires the admin role.",
+				Parameters: []parameter{
+					query("from", "", false, n),
+					query("to", "", false, n),
+					query("dry_run", "", false, &schema{Type: "boolean"}),
+					query("confirm", "The token returned by the dry run.", false, str("")),
+				},
+				Responses: mergeResponses(
+					responses(http.StatusOK, formats(jsonResponse("The memos removed.", ref("Clear")), cborType, protoType),
+						withAuth(http.StatusBadRequest)...),
+					map[string]response{
+						statusKey(http.StatusPreconditionFailed): problemResponse(
+							"The token does not match the range.", ref("Problem")),
+						statusKey(http.StatusPreconditionRequired): problemResponse(
+							"A token is required.", ref("Problem")),
+					}),
+			},
+		},
+		specPath(memoURL): {"get": {
+			OperationID: "memo", Tags: []string{"memos"},
+			Summary:    "Returns the memo for n with its metadata.",
+			Parameters: []parameter{pathParam("n", "", n)},
+			Responses: responses(http.StatusOK, formats(jsonResponse("The memo.", ref("Memo")), cborType),
+				withAuth(http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests)...),
+		}},
+		clearURL: {"get": {
+			OperationID: "clear", Tags: []string{"memos"}, Deprecated: true,
+			Summary:     "Removes all the memos.  Use DELETE /v1/memos instead.",
+			Description: "Requires the admin role, and is only served if enabled.",
+			Responses:   responses(http.StatusOK, response{Description: "The memos were removed."}, authErrors...),
+		}},
+		jobsURL: {"post": {
+			OperationID: "submitJob", Tags: []string{"jobs"},
+			Summary:     "Submits a job computing a batch in the background.",
+			RequestBody: &requestBody{Required: true, Content: jsonContent(ref("BatchRequest"))},
+			Responses: responses(http.StatusAccepted, response{
+				Description: "The job was queued.",
+				Headers:     map[string]header{"Location": {Description: "The URL of the job.", Schema: str("")}},
+				Content:     formats(response{Content: jsonContent(ref("Job"))}, cborType).Content,
+			}, withAuth(http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusTooManyRequests,
+				http.StatusServiceUnavailable)...),
+		}},
+		specPath(jobURL): {
+			"get": {
+				OperationID: "job", Tags: []string{"jobs"},
+				Summary:    "Returns a job, with its progress, and its result once it has succeeded.",
+				Parameters: []parameter{jobID},
+				Responses: responses(http.StatusOK, formats(jsonResponse("The job.", ref("Job")), cborType),
+					withAuth(http.StatusNotFound, http.StatusTooManyRequests)...),
+			},
+			"delete": {
+				OperationID: "cancelJob", Tags: []string{"jobs"},
+				Summary:     "Cancels a job that has not finished.",
+				Description: "Requires the writer role.",
+				Parameters:  []parameter{jobID},
+				Responses: responses(http.StatusOK, formats(jsonResponse("The cancelled job.", ref("Job")), cborType),
+					withAuth(http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests)...),
+			},
+		},
+		queueURL: {"post": {
+			OperationID: "queueJob", Tags: []string{"queue"},
+			Summary:     "Adds a job precomputing a range of n to the durable queue.",
+			Description: "Requires the admin role.",
+			RequestBody: &requestBody{Required: true, Content: jsonContent(ref("QueueRequest"))},
+			Responses: responses(http.StatusAccepted, response{
+				Description: "The job was queued.",
+				Headers:     map[string]header{"Location": {Description: "The URL of the job.", Schema: str("")}},
+				Content:     formats(response{Content: jsonContent(ref("QueuedJob"))}, cborType).Content,
+			}, withAuth(http.StatusBadRequest, http.StatusRequestEntityTooLarge,
+				http.StatusUnprocessableEntity)...),
+		}},
+		specPath(queuedURL): {"get": {
+			OperationID: "queuedJob", Tags: []string{"queue"},
+			Summary:     "Returns a job of the durable queue.",
+			Description: "Requires the admin role.",
+			Parameters:  []parameter{pathParam("id", "The job ID.", n)},
+			Responses: responses(http.StatusOK, formats(jsonResponse("The job.", ref("QueuedJob")), cborType),
+				withAuth(http.StatusBadRequest, http.StatusNotFound)...),
+		}},
+		healthURL: {"get": {
+			OperationID: "live", Tags: []string{"operations"}, Security: noSecurity,
+			Summary:   "The liveness probe.",
+			Responses: responses(http.StatusOK, jsonResponse("The server is up.", ref("Health"))),
+		}},
+		readyURL: {"get": {
+			OperationID: "ready", Tags: []string{"operations"}, Security: noSecurity,
+			Summary: "The readiness probe, which checks the store.",
+			Responses: map[string]response{
+				statusKey(http.StatusOK): jsonResponse("The server is ready.", ref("Health")),
+				statusKey(http.StatusServiceUnavailable): jsonResponse(
+					"The server is not ready, or shutting down.", ref("Health")),
+			},
+		}},
+		metricsURL: {"get": {
+			OperationID: "metrics", Tags: []string{"operations"}, Security: noSecurity,
+			Summary: "The Prometheus metrics.",
+			Responses: map[string]response{statusKey(http.StatusOK): {
+				Description: "The metrics in the Prometheus text format.",
+				Content:     map[string]mediaType{"text/plain": {Schema: str("")}},
+			}},
+		}},
+		openAPIURL: {"get": {
+			OperationID: "openAPI", Tags: []string{"operations"}, Security: noSecurity,
+			Summary: "This document.",
+			Responses: responses(http.StatusOK,
+				jsonResponse("The OpenAPI document.", &schema{Type: "object"})),
+		}},
+	}
+	return doc
+}
+
+func mergeResponses(a, b map[string]response) map[string]response {
+	for k, v := range b {
+		a[k] = v
+	}
+	return a
+}
+
+// Serves the document.
+func (a apiImpl) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.spec)
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gdotgordon/fibsrv/metrics"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// Returns a router serving every route, validating the responses, with
+// the log of the mismatches.
+func newSpecRouter(t *testing.T) (*mux.Router, *observer.ObservedLogs) {
+	st := store.NewMap()
+	core, logs := observer.New(zap.ErrorLevel)
+	r := newTestRouter(t, Config{
+		LegacyClear:       true,
+		Metrics:           metrics.New(),
+		Health:            NewHealth(st),
+		Queue:             st.(store.Queue),
+		ValidateResponses: true,
+	}, testSetup{
+		store:  st,
+		limits: service.Limits{MaxWrites: 50},
+		jobs:   &service.JobConfig{Workers: 1},
+		log:    zap.New(core).Sugar(),
+	})
+	return r, logs
+}
+
+// Tests the document describes exactly the routes of the router.  Adding a
+// route without describing it, or the reverse, fails this test.
+func TestOpenAPIRoutes(t *testing.T) {
+	r, _ := newSpecRouter(t)
+	var routes []string
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, m := range methods {
+			routes = append(routes, m+" "+specPath(tpl))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, openAPIURL, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var doc openAPI
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	var described []string
+	for path, item := range doc.Paths {
+		for m := range item {
+			described = append(described, strings.ToUpper(m)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	sort.Strings(described)
+	if strings.Join(routes, "\n") != strings.Join(described, "\n") {
+		t.Fatalf("the routes and the document differ:\nroutes:\n%s\n\ndocument:\n%s",
+			strings.Join(routes, "\n"), strings.Join(described, "\n"))
+	}
+
+	// The references resolve.
+	var check func(s *schema)
+	check = func(s *schema) {
+		if s == nil {
+			return
+		}
+		if s.Ref != "" {
+			if _, ok := doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]; !ok {
+				t.Fatalf("unresolved reference %s", s.Ref)
+			}
+		}
+		check(s.Items)
+		for _, p := range s.Properties {
+			check(p)
+		}
+		for _, alt := range append(s.OneOf, s.AnyOf...) {
+			check(alt)
+		}
+	}
+	for _, s := range doc.Components.Schemas {
+		check(s)
+	}
+	for _, item := range doc.Paths {
+		for _, op := range item {
+			for _, p := range op.Parameters {
+				check(p.Schema)
+			}
+			if op.RequestBody != nil {
+				for _, mt := range op.RequestBody.Content {
+					check(mt.Schema)
+				}
+			}
+			for _, res := range op.Responses {
+				for _, mt := range res.Content {
+					check(mt.Schema)
+				}
+			}
+		}
+	}
+}
+
+// Tests the responses match the document, and the requests that don't are
+// rejected.
+func TestOpenAPIValidation(t *testing.T) {
+	r, logs := newSpecRouter(t)
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return w
+	}
+
+	for i, v := range []struct {
+		method, url, body string
+		code              int
+	}{
+		{http.MethodGet, "/v1/fib?n=20", "", http.StatusOK},
+		{http.MethodGet, "/v1/fib?n=99999999", "", http.StatusUnprocessableEntity},
+		{http.MethodGet, "/v1/fib?n=90", "", http.StatusRequestEntityTooLarge},
+		{http.MethodGet, "/v1/fibless?target=100", "", http.StatusOK},
+		{http.MethodPost, batchURL, `{"n": [10, 94], "targets": [5]}`, http.StatusOK},
+		{http.MethodPost, batchURL, `[]`, http.StatusRequestEntityTooLarge},
+		{http.MethodGet, memosURL + "?limit=5", "", http.StatusOK},
+		{http.MethodGet, memosURL + "/10", "", http.StatusOK},
+		{http.MethodGet, memosURL + "/45", "", http.StatusNotFound},
+		{http.MethodDelete, memosURL + "?from=0&to=5&dry_run=true", "", http.StatusOK},
+		{http.MethodDelete, memosURL + "?from=0&to=5", "", http.StatusPreconditionRequired},
+		{http.MethodPost, jobsURL, `[5, 6]`, http.StatusAccepted},
+		{http.MethodGet, jobsURL + "/00ff", "", http.StatusNotFound},
+		{http.MethodPost, queueURL, `{"from": 1, "to": 5}`, http.StatusAccepted},
+		{http.MethodPost, queueURL, `{"to": 94}`, http.StatusUnprocessableEntity},
+		{http.MethodGet, queueURL + "/1", "", http.StatusOK},
+		{http.MethodGet, clearURL, "", http.StatusOK},
+		{http.MethodGet, healthURL, "", http.StatusOK},
+		{http.MethodGet, readyURL, "", http.StatusOK},
+		{http.MethodGet, metricsURL, "", http.StatusOK},
+	} {
+		if w := call(v.method, v.url, v.body); w.Code != v.code {
+			t.Fatalf("%d: expected status %d, got %d: %s", i, v.code, w.Code, w.Body)
+		}
+	}
+	if bad := logs.FilterMessage("response does not match the OpenAPI document"); bad.Len() > 0 {
+		for _, e := range bad.All() {
+			t.Errorf("%v", e.ContextMap())
+		}
+		t.FailNow()
+	}
+
+	// Rejected by the validation, rather than the handlers.
+	for i, v := range []struct {
+		method, url, body string
+	}{
+		{http.MethodGet, memosURL + "?limit=0", ""},
+		{http.MethodDelete, memosURL + "?dry_run=maybe", ""},
+		{http.MethodPost, batchURL, `{"n": [1.5]}`},
+		{http.MethodPost, batchURL, ``},
+		{http.MethodPost, queueURL, `{"from": 1}`},
+		{http.MethodPost, queueURL, `{"to": "5"}`},
+	} {
+		w := call(v.method, v.url, v.body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), problemInvalidRequest) {
+			t.Fatalf("%d: expected the request to be invalid, got %d: %s", i, w.Code, w.Body)
+		}
+	}
+
+	// Responses that don't match are found.
+	v := validator{doc: newOpenAPI()}
+	op := v.doc.Paths[fibURL]["get"]
+	for i, body := range []string{`{"value": 1}`, `{"result": -1}`, `{"result": "1"}`} {
+		cw := &captureWriter{ResponseWriter: httptest.NewRecorder()}
+		writeJSON(cw, http.StatusOK, json.RawMessage(body))
+		if err := v.response(op, cw); err == nil {
+			t.Fatalf("%d: expected %s to be invalid", i, body)
+		}
+	}
+	cw := &captureWriter{ResponseWriter: httptest.NewRecorder()}
+	writeJSON(cw, http.StatusTeapot, ResultResponse{Result: 418})
+	if err := v.response(op, cw); err == nil {
+		t.Fatal("expected an undocumented status to be invalid")
+	}
+}
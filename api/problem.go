@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gdotgordon/fibsrv/apitypes"
+	"github.com/gorilla/mux"
+)
+
+// Problem is the body of every error response, an RFC 7807 problem
+// detail.  Some problems add members of their own, such as the limit
+// exceeded.
+type Problem = apitypes.Problem
+
+// The media type of problems.
+const problemContentType = "application/problem+json"
+
+// The type of a problem is this followed by its code.
+const problemTypePrefix = "urn:fibsrv:problem:"
+
+// The codes of the problems, other than those of the authentication and
+// the rate limits.
+const (
+	problemInvalidRequest   = "invalid_request"
+	problemNotFound         = "not_found"
+	problemMethodNotAllowed = "method_not_allowed"
+	problemNotAcceptable    = "not_acceptable"
+	problemBatchSize        = "batch_size"
+	problemBodyTooLarge     = "body_too_large"
+	problemLimitExceeded    = "limit_exceeded"
+	problemConfirmRequired  = "confirmation_required"
+	problemConfirmInvalid   = "confirmation_invalid"
+	problemJobFinished      = "job_finished"
+	problemUnavailable      = "unavailable"
+	problemInternal         = "internal_error"
+)
+
+// The codes of the plain problems, without members of their own.
+var problemCodes = []string{problemInvalidRequest, problemNotFound, problemMethodNotAllowed,
+	problemNotAcceptable, problemBatchSize, problemBodyTooLarge, problemConfirmRequired, problemConfirmInvalid,
+	problemJobFinished, problemUnavailable, problemInternal}
+
+// The titles of the problems, which are the same for every occurrence.
+var problemTitles = map[string]string{
+	problemInvalidRequest:     "The request is invalid",
+	problemNotFound:           "The resource was not found",
+	problemMethodNotAllowed:   "The method is not allowed for the resource",
+	problemNotAcceptable:      "The response is not available in an accepted media type",
+	problemBatchSize:          "The batch has too few or too many items",
+	problemBodyTooLarge:       "The request body is too large",
+	problemLimitExceeded:      "The computation exceeds a limit",
+	problemConfirmRequired:    "A confirmation token is required",
+	problemConfirmInvalid:     "The confirmation token does not match the request",
+	problemJobFinished:        "The job has already finished",
+	problemUnavailable:        "The service is unavailable",
+	problemInternal:           "An internal error occurred",
+	authErrUnauthenticated:    "Authentication is required",
+	authErrInvalidCredentials: "The credentials are invalid",
+	authErrForbidden:          "The caller lacks the required role",
+	limitErrRateLimited:       "The rate limit is exceeded",
+	limitErrQuotaExceeded:     "The daily quota is exceeded",
+}
+
+// Returns the problem of the request, with the error as the detail.
+func newProblem(r *http.Request, status int, code string, err error) Problem {
+	p := Problem{
+		Type:      problemTypePrefix + code,
+		Title:     problemTitles[code],
+		Status:    status,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: RequestID(r.Context()),
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(status)
+	}
+	if err != nil {
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+// Writes a problem, or a response embedding one, with the status code.
+// Problems are never cached.
+func writeProblemJSON(w http.ResponseWriter, status int, resp interface{}) {
+	w.Header().Del("ETag")
+	w.Header().Set("Cache-Control", cacheNoStore)
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	b, _ := json.MarshalIndent(resp, "", "  ")
+	w.Write(b)
+}
+
+// Logs and writes a problem.  Server errors are logged as errors, the
+// others as information, since they are the caller's.
+func (a apiImpl) writeProblem(w http.ResponseWriter, r *http.Request, status int, code string, err error) {
+	p := newProblem(r, status, code, err)
+	if status >= http.StatusInternalServerError {
+		a.log.Errorw("invoke error", "error", err, "code", status, "request_id", p.RequestID)
+	} else {
+		a.log.Infow("Request rejected", "error", err, "code", status, "request_id", p.RequestID)
+	}
+	writeProblemJSON(w, status, p)
+}
+
+// Answers the requests that match no route.
+func (a apiImpl) notFound(w http.ResponseWriter, r *http.Request) {
+	a.writeProblem(w, r, http.StatusNotFound, problemNotFound,
+		fmt.Errorf("no resource at %s", r.URL.Path))
+}
+
+// Answers the requests whose method is not that of the route, listing the
+// methods that are in the Allow header.
+func (a apiImpl) methodNotAllowed(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodPut,
+			http.MethodPatch, http.MethodDelete} {
+			req := r.Clone(r.Context())
+			req.Method = m
+			var match mux.RouteMatch
+			if router.Match(req, &match) && match.MatchErr == nil {
+				allowed = append(allowed, m)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		a.writeProblem(w, r, http.StatusMethodNotAllowed, problemMethodNotAllowed,
+			fmt.Errorf("%s is not allowed for %s", r.Method, r.URL.Path))
+	}
+}
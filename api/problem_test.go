@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// Tests errors are problems with the request ID, including those of the
+// unmatched routes and methods.
+func TestProblems(t *testing.T) {
+	r := newTestRouter(t, Config{}, testSetup{})
+
+	for i, v := range []struct {
+		method, url, reqID string
+		status             int
+		code, allow        string
+	}{
+		{http.MethodGet, "/v1/memos/45", "", http.StatusNotFound, problemNotFound, ""},
+		{http.MethodGet, "/v1/nothing", "trace-1", http.StatusNotFound, problemNotFound, ""},
+		{http.MethodDelete, "/v1/memos?from=1", "", http.StatusPreconditionRequired, problemConfirmRequired, ""},
+		{http.MethodPut, "/v1/memos", "", http.StatusMethodNotAllowed, problemMethodNotAllowed, "GET, DELETE"},
+		{http.MethodGet, "/v1/fib/batch", "bad id!", http.StatusMethodNotAllowed, problemMethodNotAllowed, "POST"},
+	} {
+		req := httptest.NewRequest(v.method, v.url, nil)
+		if v.reqID != "" {
+			req.Header.Set(requestIDHeader, v.reqID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != v.status {
+			t.Fatalf("%d: expected status %d, got %d: %s", i, v.status, w.Code, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+			t.Fatalf("%d: expected a problem, got %q", i, ct)
+		}
+		var p Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		id := w.Header().Get(requestIDHeader)
+		if p.Code != v.code || p.Status != v.status || p.Type != problemTypePrefix+v.code ||
+			p.Title == "" || p.Detail == "" || p.RequestID != id {
+			t.Fatalf("%d: unexpected problem: %+v", i, p)
+		}
+		if v.reqID == "trace-1" && id != v.reqID {
+			t.Fatalf("%d: expected the request ID to be kept, got %q", i, id)
+		}
+		if v.reqID == "bad id!" && (id == v.reqID || id == "") {
+			t.Fatalf("%d: expected the request ID to be replaced, got %q", i, id)
+		}
+		if allow := w.Header().Get("Allow"); allow != v.allow {
+			t.Fatalf("%d: expected Allow %q, got %q", i, v.allow, allow)
+		}
+	}
+
+	// An error without a cause is still a problem.
+	w := httptest.NewRecorder()
+	ap := apiImpl{log: zap.NewNop().Sugar()}
+	ap.writeProblem(w, httptest.NewRequest(http.MethodGet, fibURL, nil), http.StatusInternalServerError,
+		problemInternal, nil)
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.Code != problemInternal || p.Detail != "" {
+		t.Fatalf("unexpected problem without a cause: %v %s", err, w.Body)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gdotgordon/fibsrv/apitypes"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gorilla/mux"
+)
+
+// The attempts of a queued job, unless set in the request.
+const defaultMaxAttempts = 5
+
+// The bodies of the durable queue.
+type (
+	QueueRequest      = apitypes.QueueRequest
+	QueuedJobResponse = apitypes.QueuedJobResponse
+)
+
+// Queues a job precomputing the memos for a range of n, to be run by
+// the workers of any instance.
+func (a apiImpl) queueJob(w http.ResponseWriter, r *http.Request) {
+	var req QueueRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, fmt.Errorf("invalid job: %v", err))
+		return
+	}
+	if req.From < 0 || req.To < req.From || req.MaxAttempts < 0 {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest,
+			fmt.Errorf("invalid job: range [%d, %d], %d attempts", req.From, req.To, req.MaxAttempts))
+		return
+	}
+	if req.MaxAttempts == 0 {
+		req.MaxAttempts = defaultMaxAttempts
+	}
+
+	// A range over the limits is rejected like a request.
+	id, err := a.service.Enqueue(r.Context(), a.queue,
+		service.QueueSpec{From: req.From, To: req.To}, req.MaxAttempts)
+	if err != nil {
+		a.writeComputeError(w, r, err)
+		return
+	}
+	job, _, err := a.queue.QueuedJob(r.Context(), id)
+	if err != nil {
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+		return
+	}
+	w.Header().Set("Location", queueURL+"/"+strconv.FormatInt(id, 10))
+	a.respond(w, r, http.StatusAccepted, queuedJobResponse(job))
+}
+
+// Returns a job in the queue.
+func (a apiImpl) queuedJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		a.writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, err)
+		return
+	}
+	job, ok, err := a.queue.QueuedJob(r.Context(), id)
+	if err != nil {
+		a.writeProblem(w, r, http.StatusInternalServerError, problemInternal, err)
+		return
+	}
+	if !ok {
+		a.writeProblem(w, r, http.StatusNotFound, problemNotFound, fmt.Errorf("no queued job %d", id))
+		return
+	}
+	a.respond(w, r, http.StatusOK, queuedJobResponse(job))
+}
+
+// Converts a queued job to the response.
+func queuedJobResponse(job store.QueuedJob) QueuedJobResponse {
+	var spec service.QueueSpec
+	json.Unmarshal(job.Payload, &spec)
+	return QueuedJobResponse{
+		ID:          job.ID,
+		State:       job.State,
+		From:        spec.From,
+		To:          spec.To,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		RunAt:       job.RunAt,
+		LeaseOwner:  job.LeaseOwner,
+		LeaseUntil:  job.LeaseUntil,
+		LastError:   job.LastError,
+		Created:     job.CreatedAt,
+		Updated:     job.UpdatedAt,
+	}
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/store"
+	"go.uber.org/zap"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second, holding up
+// to Burst tokens.  A zero Rate means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig sets the limits for each client, which is identified by
+// its authenticated name if any, or else by its IP address.
+type RateLimitConfig struct {
+	// Cached limits requests that are answered from the memos, such as
+	// browsing them or fib(n) for a memoized n.
+	Cached Limit
+
+	// Cold limits requests that may have to compute and store memos.
+	Cold Limit
+
+	// DailyQuota, if not zero, is the number of requests a client may
+	// make per (UTC) day.
+	DailyQuota int
+
+	// Quotas persists the daily usage, so it survives restarts and is
+	// shared by all instances.  If nil, it is kept in memory.
+	Quotas store.Quotas
+}
+
+// The request classes, with their own buckets.
+type costClass int
+
+const (
+	costCached costClass = iota
+	costCold
+)
+
+// RateLimiter enforces the rate limits and quotas.
+type RateLimiter struct {
+	cfg     RateLimitConfig
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+	swept   time.Time
+
+	// The daily usage by client, if there is no store of the quotas.
+	usage map[string]dayUsage
+}
+
+type dayUsage struct {
+	day  string
+	used int
+}
+
+type bucketKey struct {
+	client string
+	class  costClass
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// How often idle buckets are dropped.
+const sweepInterval = time.Minute
+
+// NewRateLimiter returns a limiter for the configuration.
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{cfg: cfg, now: time.Now, buckets: make(map[bucketKey]*bucket),
+		usage: make(map[string]dayUsage)}
+}
+
+// RateLimitResponse is the problem returned when a request is rejected
+// for exceeding a limit.
+type RateLimitResponse struct {
+	Problem
+	RetryAfter int `json:"retry_after"`
+}
+
+// Problem codes of RateLimitResponse.
+const (
+	limitErrRateLimited   = "rate_limited"
+	limitErrQuotaExceeded = "quota_exceeded"
+)
+
+// The outcome of taking a token.
+type take struct {
+	ok        bool
+	remaining int
+	retry     time.Duration // until a token is available
+	reset     time.Duration // until the bucket is full
+}
+
+// Takes a token from the client's bucket for the class.
+func (rl *RateLimiter) take(client string, class costClass) take {
+	lim := rl.cfg.Cached
+	if class == costCold {
+		lim = rl.cfg.Cold
+	}
+	now := rl.now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if now.Sub(rl.swept) >= sweepInterval {
+		rl.sweep(now)
+	}
+	key := bucketKey{client, class}
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(lim.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(lim.Burst), b.tokens+now.Sub(b.last).Seconds()*lim.Rate)
+	b.last = now
+
+	var t take
+	if b.tokens >= 1 {
+		b.tokens--
+		t.ok = true
+	} else {
+		t.retry = seconds((1 - b.tokens) / lim.Rate)
+	}
+	t.remaining = int(b.tokens)
+	t.reset = seconds((float64(lim.Burst) - b.tokens) / lim.Rate)
+	return t
+}
+
+// Drops the buckets that have refilled, as they are the same as new ones,
+// and the usage of past days.
+func (rl *RateLimiter) sweep(now time.Time) {
+	today := now.UTC().Format("2006-01-02")
+	for client, u := range rl.usage {
+		if u.day != today {
+			delete(rl.usage, client)
+		}
+	}
+	for k, b := range rl.buckets {
+		lim := rl.cfg.Cached
+		if k.class == costCold {
+			lim = rl.cfg.Cold
+		}
+		if b.tokens+now.Sub(b.last).Seconds()*lim.Rate >= float64(lim.Burst) {
+			delete(rl.buckets, k)
+		}
+	}
+	rl.swept = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Identifies the client of a request.
+func clientKey(r *http.Request) string {
+	if p, ok := CallerPrincipal(r.Context()); ok && p.Name != "" {
+		return p.Method + ":" + p.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Wraps a handler with the rate limit and quota checks.  The classify
+// function tells which bucket a request is charged to.  If rate limiting is
+// not configured, the handler is returned as is.
+func (a apiImpl) limit(classify func(*http.Request) costClass, h http.HandlerFunc) http.HandlerFunc {
+	rl := a.limiter
+	if rl == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientKey(r)
+		class := classify(r)
+		lim := rl.cfg.Cached
+		if class == costCold {
+			lim = rl.cfg.Cold
+		}
+		if lim.Rate > 0 {
+			t := rl.take(client, class)
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(lim.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(t.remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(t.reset)))
+			if !t.ok {
+				a.rejectLimited(w, r, client, "rate limit exceeded", limitErrRateLimited, t.retry)
+				return
+			}
+		}
+		if rl.cfg.DailyQuota > 0 {
+			if ok, retry := rl.checkQuota(r.Context(), client, a.log); !ok {
+				a.rejectLimited(w, r, client, "daily quota exceeded", limitErrQuotaExceeded, retry)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// Counts the request against the client's daily quota.  If the usage
+// cannot be recorded, the request is allowed rather than failing.
+func (rl *RateLimiter) checkQuota(ctx context.Context, client string, log *zap.SugaredLogger) (bool, time.Duration) {
+	now := rl.now().UTC()
+	used, err := rl.addUsage(ctx, client, now)
+	if err != nil {
+		log.Errorw("recording quota usage", "client", client, "error", err)
+		return true, 0
+	}
+	if used <= rl.cfg.DailyQuota {
+		return true, 0
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return false, midnight.Sub(now)
+}
+
+// Adds a request to the client's usage for the day, in the store of the
+// quotas if there is one, and returns the usage.
+func (rl *RateLimiter) addUsage(ctx context.Context, client string, now time.Time) (int, error) {
+	if rl.cfg.Quotas != nil {
+		return rl.cfg.Quotas.AddUsage(ctx, client, now, 1)
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	day := now.Format("2006-01-02")
+	u := rl.usage[client]
+	if u.day != day {
+		u = dayUsage{day: day}
+	}
+	u.used++
+	rl.usage[client] = u
+	return u.used, nil
+}
+
+func (a apiImpl) rejectLimited(w http.ResponseWriter, r *http.Request, client, detail, code string, retry time.Duration) {
+	a.log.Infow("Request limited", "client", client, "error", code)
+	secs := ceilSeconds(retry)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeProblemJSON(w, http.StatusTooManyRequests, RateLimitResponse{
+		Problem:    newProblem(r, http.StatusTooManyRequests, code, errors.New(detail)),
+		RetryAfter: secs})
+}
+
+// Rounds up to whole seconds, as used by the headers.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// The classifications of the routes.
+
+func cached(*http.Request) costClass { return costCached }
+
+func cold(*http.Request) costClass { return costCold }
+
+// fib(n) is cheap if it needs no new memos.  This is estimated from the
+// count of the memos, a single query, rather than by looking fib(n) up,
+// which would compute it if it is not memoized.
+func (a apiImpl) fibCost(r *http.Request) costClass {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n < 0 {
+		return costCached
+	}
+	if c, err := a.service.EstimateFib(r.Context(), n); err == nil && c.Writes == 0 {
+		return costCached
+	}
+	return costCold
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Returns a router with rate limiting, on a clock controlled by the test.
+func newLimitedRouter(t *testing.T, cfg RateLimitConfig, now *time.Time) *mux.Router {
+	rl := NewRateLimiter(cfg)
+	rl.now = func() time.Time { return *now }
+	return newTestRouter(t, Config{RateLimiter: rl}, testSetup{})
+}
+
+func get(r http.Handler, url, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// Tests the cold and cached buckets are separate and per client, and
+// that they refill.
+func TestRateLimit(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	r := newLimitedRouter(t, RateLimitConfig{
+		Cached: Limit{Rate: 10, Burst: 5},
+		Cold:   Limit{Rate: 1, Burst: 2},
+	}, &now)
+	const alice, bob = "10.0.0.1:1234", "10.0.0.2:1234"
+
+	for i, v := range []struct {
+		url, client string
+		code        int
+		remaining   string
+	}{
+		{"/v1/fib?n=20", alice, http.StatusOK, "1"},
+		{"/v1/fib?n=30", alice, http.StatusOK, "0"},
+		{"/v1/fib?n=40", alice, http.StatusTooManyRequests, "0"},
+		{"/v1/fib?n=40", bob, http.StatusOK, "1"},
+		{"/v1/fib?n=20", alice, http.StatusOK, "4"}, // memoized, so cached
+		{"/v1/memos/10", alice, http.StatusOK, "3"},
+	} {
+		w := get(r, v.url, v.client)
+		if w.Code != v.code {
+			t.Fatalf("%d: expected status %d, got %d", i, v.code, w.Code)
+		}
+		if rem := w.Header().Get("RateLimit-Remaining"); rem != v.remaining {
+			t.Fatalf("%d: expected %s remaining, got %s", i, v.remaining, rem)
+		}
+		if v.code != http.StatusTooManyRequests {
+			continue
+		}
+		if ra := w.Header().Get("Retry-After"); ra != "1" {
+			t.Fatalf("%d: expected Retry-After 1, got %q", i, ra)
+		}
+		var resp RateLimitResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Code != limitErrRateLimited || resp.RetryAfter != 1 {
+			t.Fatalf("%d: expected error %q, got %+v", i, limitErrRateLimited, resp)
+		}
+	}
+
+	now = now.Add(time.Second)
+	if w := get(r, "/v1/fib?n=50", alice); w.Code != http.StatusOK {
+		t.Fatalf("expected the bucket to refill, got %d", w.Code)
+	}
+}
+
+// Tests the daily quota, which is reset the next day.
+func TestDailyQuota(t *testing.T) {
+	now := time.Date(2021, 6, 1, 23, 0, 0, 0, time.UTC)
+	r := newLimitedRouter(t, RateLimitConfig{DailyQuota: 2}, &now)
+	const client = "10.0.0.1:1234"
+
+	for i := 0; i < 2; i++ {
+		if w := get(r, "/v1/fib?n=10", client); w.Code != http.StatusOK {
+			t.Fatalf("%d: expected status 200, got %d", i, w.Code)
+		}
+	}
+	w := get(r, "/v1/fib?n=10", client)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the quota to be exceeded, got %d", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "3600" {
+		t.Fatalf("expected Retry-After until midnight, got %q", ra)
+	}
+
+	now = now.Add(time.Hour)
+	if w := get(r, "/v1/fib?n=10", client); w.Code != http.StatusOK {
+		t.Fatalf("expected the quota to be reset, got %d", w.Code)
+	}
+}
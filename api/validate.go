@@ -0,0 +1,374 @@
+package api
+
+// Requests are validated against the OpenAPI document before they reach
+// the handlers, so the document can't promise less than the server
+// accepts.  In development the responses are validated as well, and any
+// mismatch is logged, so the document can't promise more than the server
+// returns either.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// The validator of requests and responses.
+type validator struct {
+	doc       *openAPI
+	log       *zap.SugaredLogger
+	responses bool
+}
+
+// Matches the variables of a route path template, to strip their patterns.
+var routeVar = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Converts a route path template to the path of the document, for
+// example /v1/memos/{n:[0-9]+} to /v1/memos/{n}.
+func specPath(tpl string) string {
+	return routeVar.ReplaceAllString(tpl, "{$1}")
+}
+
+// Returns the operation of the route of the request, if it is described.
+func (v validator) operation(r *http.Request) (*operation, string) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil, ""
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return nil, ""
+	}
+	path := specPath(tpl)
+	return v.doc.Paths[path][strings.ToLower(r.Method)], path
+}
+
+// Wraps a handler to reject the requests not matching the document.
+func (v validator) requests(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if op, _ := v.operation(r); op != nil {
+			if status, code, err := v.request(op, r); err != nil {
+				v.log.Infow("Invalid request", "error", err, "code", status)
+				writeProblemJSON(w, status, newProblem(r, status, code, err))
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// The middleware validating the responses, if enabled.
+func (v validator) middleware(next http.Handler) http.Handler {
+	if !v.responses {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, path := v.operation(r)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &captureWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		if err := v.response(op, cw); err != nil {
+			v.log.Errorw("response does not match the OpenAPI document", "error", err,
+				"method", r.Method, "path", path, "code", cw.code)
+		}
+	})
+}
+
+// Validates the parameters and body of the request, returning the status
+// and problem code if it is invalid.  The body is read and replaced, and
+// is rejected if it is too large for any handler.
+func (v validator) request(op *operation, r *http.Request) (int, string, error) {
+	vars := mux.Vars(r)
+	q := r.URL.Query()
+	for _, p := range op.Parameters {
+		var val string
+		var ok bool
+		switch p.In {
+		case "path":
+			val, ok = vars[p.Name]
+		case "query":
+			if vals, found := q[p.Name]; found {
+				val, ok = vals[0], true
+			}
+		case "header":
+			val = r.Header.Get(p.Name)
+			ok = val != ""
+		}
+		if !ok {
+			if p.Required {
+				return http.StatusBadRequest, problemInvalidRequest, fmt.Errorf("%s parameter %q is required", p.In, p.Name)
+			}
+			continue
+		}
+		if err := v.param(p.Schema, val); err != nil {
+			return http.StatusBadRequest, problemInvalidRequest, fmt.Errorf("%s parameter %q: %v", p.In, p.Name, err)
+		}
+	}
+
+	if op.RequestBody == nil {
+		return 0, "", nil
+	}
+	mt, ok := op.RequestBody.Content["application/json"]
+	if !ok {
+		return 0, "", nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBatchBytes+1))
+	r.Body.Close()
+	if err != nil {
+		return http.StatusBadRequest, problemInvalidRequest, err
+	}
+	if len(b) > maxBatchBytes {
+		return http.StatusRequestEntityTooLarge, problemBodyTooLarge, fmt.Errorf("the body exceeds %d bytes", maxBatchBytes)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if len(bytes.TrimSpace(b)) == 0 {
+		if op.RequestBody.Required {
+			return http.StatusBadRequest, problemInvalidRequest, fmt.Errorf("a body is required")
+		}
+		return 0, "", nil
+	}
+	body, err := decodeJSON(b)
+	if err != nil {
+		return http.StatusBadRequest, problemInvalidRequest, fmt.Errorf("body: %v", err)
+	}
+	if err := v.value(mt.Schema, body, "body"); err != nil {
+		return http.StatusBadRequest, problemInvalidRequest, err
+	}
+	return 0, "", nil
+}
+
+// Validates a path or query parameter, converting it to the type of its
+// schema.
+func (v validator) param(s *schema, val string) error {
+	s = v.resolve(s)
+	var x interface{} = val
+	switch s.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(val, 64); err != nil {
+			return fmt.Errorf("%q is not a number", val)
+		}
+		x = json.Number(val)
+	case "boolean":
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", val)
+		}
+		x = b
+	}
+	return v.value(s, x, "value")
+}
+
+// Validates the response of the operation.
+func (v validator) response(op *operation, cw *captureWriter) error {
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+	res, ok := op.Responses[strconv.Itoa(cw.code)]
+	if !ok {
+		if res, ok = op.Responses["default"]; !ok {
+			return fmt.Errorf("status %d is not documented", cw.code)
+		}
+	}
+	if cw.body.Len() == 0 {
+		if len(res.Content) > 0 {
+			return fmt.Errorf("status %d has no body", cw.code)
+		}
+		return nil
+	}
+	ct, _, err := mime.ParseMediaType(cw.Header().Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("content type %q: %v", cw.Header().Get("Content-Type"), err)
+	}
+	mt, ok := res.Content[ct]
+	if !ok {
+		return fmt.Errorf("content type %q is not documented for status %d", ct, cw.code)
+	}
+	if ct != "application/json" && !strings.HasSuffix(ct, "+json") {
+		return nil
+	}
+	body, err := decodeJSON(cw.body.Bytes())
+	if err != nil {
+		return fmt.Errorf("body: %v", err)
+	}
+	return v.value(mt.Schema, body, "body")
+}
+
+// Decodes a JSON value, keeping the numbers exact.
+func decodeJSON(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var x interface{}
+	if err := dec.Decode(&x); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the value")
+	}
+	return x, nil
+}
+
+// Returns the schema a reference refers to.
+func (v validator) resolve(s *schema) *schema {
+	for s.Ref != "" {
+		s = v.doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// Validates a decoded JSON value against the schema.  The location of the
+// value is used in the errors.
+func (v validator) value(s *schema, x interface{}, at string) error {
+	s = v.resolve(s)
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, alt := range s.OneOf {
+			if v.value(alt, x, at) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: matches %d of the alternatives, instead of one", at, matched)
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		var err error
+		for _, alt := range s.AnyOf {
+			if err = v.value(alt, x, at); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "object":
+		obj, ok := x.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object", at)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: %q is required", at, name)
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ps, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unknown field %q", at, name)
+				}
+				continue
+			}
+			if err := v.value(ps, obj[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := x.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array", at)
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				if err := v.value(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "integer", "number":
+		num, ok := x.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected a number", at)
+		}
+		if s.Type == "integer" && strings.ContainsAny(string(num), ".eE") {
+			return fmt.Errorf("%s: %s is not an integer", at, num)
+		}
+		f, err := num.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: %v", at, err)
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf("%s: %s is less than %v", at, num, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fmt.Errorf("%s: %s is greater than %v", at, num, *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := x.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", at)
+		}
+	case "string":
+		str, ok := x.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string", at)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return fmt.Errorf("%s: %q is not one of %s", at, str, strings.Join(s.Enum, ", "))
+		}
+		if s.re != nil && !s.re.MatchString(str) {
+			return fmt.Errorf("%s: %q does not match %s", at, str, s.Pattern)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, str)
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unknown type %q in the schema", at, s.Type)
+	}
+	return nil
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// Passes the response through, keeping a copy of the body and the code.
+type captureWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if cw.code == 0 {
+		cw.code = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,134 @@
+// Package apitypes defines the request and response bodies of the REST
+// api.  They are shared by the api package, which serves them, and the
+// client, which only needs them and not the dependencies of the server,
+// so this package has none beyond the standard library.
+package apitypes
+
+import "time"
+
+// ResultResponse is the JSON returned for status notifications.
+type ResultResponse struct {
+	Result uint64 `json:"result"`
+}
+
+// Memo is a memoized fibonacci number with its metadata.
+type Memo struct {
+	Num   int    `json:"n"`
+	Value uint64 `json:"value"`
+
+	// CreatedAt is when the memo was first written.
+	CreatedAt time.Time `json:"created_at"`
+
+	// LastAccessedAt is when the memo was last read, nil if never.
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+
+	// HitCount is the number of times the memo was read.
+	HitCount int64 `json:"hit_count"`
+
+	// WriterID identifies the server instance that wrote the memo.
+	WriterID string `json:"writer_id"`
+}
+
+// ClearResponse is the JSON returned for a (possibly dry run) removal of
+// memos.  For a dry run, Confirm holds the token that must be passed back
+// to actually remove the memos in the same range.
+type ClearResponse struct {
+	Count   int    `json:"count"`
+	DryRun  bool   `json:"dry_run"`
+	Confirm string `json:"confirm,omitempty"`
+}
+
+// ListResponse is the JSON returned for a page of memos.  Next is the
+// cursor to pass as "after" to get the following page, and is omitted
+// on the last page.
+type ListResponse struct {
+	Memos []Memo `json:"memos"`
+	Next  string `json:"next,omitempty"`
+}
+
+// Problem is the body of every error response, an RFC 7807 problem
+// detail.  Code is a stable identifier of the kind of problem, which
+// clients can rely on, while Detail describes this occurrence of it.
+// Some problems add members of their own, such as the limit exceeded.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// BatchRequest is the body of a batch.  A plain JSON array of n values is
+// also accepted.
+type BatchRequest struct {
+	N       []int    `json:"n"`
+	Targets []uint64 `json:"targets,omitempty"`
+}
+
+// BatchResponse has the results in the order of the request.
+type BatchResponse struct {
+	Results []BatchResult     `json:"results"`
+	Less    []BatchLessResult `json:"less,omitempty"`
+}
+
+// BatchResult is the result for one n: either the value or the error.
+type BatchResult struct {
+	N     int     `json:"n"`
+	Value *uint64 `json:"value,omitempty"`
+	Error string  `json:"error,omitempty"`
+	Limit string  `json:"limit,omitempty"`
+}
+
+// BatchLessResult is the result for one FibLess target.
+type BatchLessResult struct {
+	Target uint64 `json:"target"`
+	Count  *int   `json:"count,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Limit  string `json:"limit,omitempty"`
+}
+
+// JobResponse is the JSON for a job.  The result is set once the job has
+// succeeded, and the error once it has failed or been cancelled.
+type JobResponse struct {
+	ID       string         `json:"id"`
+	State    string         `json:"state"`
+	Progress JobProgress    `json:"progress"`
+	Created  time.Time      `json:"created"`
+	Started  *time.Time     `json:"started,omitempty"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Limit    string         `json:"limit,omitempty"`
+	Result   *BatchResponse `json:"result,omitempty"`
+}
+
+// JobProgress is the number of steps of the sequence computed, out of the
+// total, which is zero until the job starts computing.
+type JobProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// QueueRequest is the body to queue a job precomputing a range of n.
+type QueueRequest struct {
+	From        int `json:"from"`
+	To          int `json:"to"`
+	MaxAttempts int `json:"max_attempts,omitempty"`
+}
+
+// QueuedJobResponse is the JSON for a job in the durable queue.
+type QueuedJobResponse struct {
+	ID          int64      `json:"id"`
+	State       string     `json:"state"`
+	From        int        `json:"from"`
+	To          int        `json:"to"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	RunAt       time.Time  `json:"run_at"`
+	LeaseOwner  string     `json:"lease_owner,omitempty"`
+	LeaseUntil  *time.Time `json:"lease_until,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+}
@@ -0,0 +1,346 @@
+// Package client is the Go client of the fibonacci service's REST api.
+// It builds the requests, decodes the responses into the types of the
+// apitypes package, retries the requests that may be retried, and returns the
+// problems of the server as typed errors.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/apitypes"
+)
+
+// Defaults of the retries.
+const (
+	defaultRetries    = 3
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+)
+
+// Client calls the service.  It is safe for concurrent use.
+type Client struct {
+	base       *url.URL
+	http       *http.Client
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	header     http.Header
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client, for its transport, TLS settings or
+// timeout.  The default is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithRetries sets how many times a failed request is retried, 3 by
+// default.  Zero disables the retries.
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+// WithBackoff sets the wait before the first retry, which doubles with
+// each retry up to max.  The waits are jittered, so that clients failing
+// together don't retry together.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff, c.maxBackoff = min, max
+	}
+}
+
+// WithAPIKey authenticates the requests with an API key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.header.Set("X-API-Key", key)
+	}
+}
+
+// WithBearerToken authenticates the requests with a JWT.
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// New returns a client of the service at the base URL, such as
+// "http://localhost:8080".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %v", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("invalid base URL: %q", baseURL)
+	}
+	c := &Client{
+		base:       base,
+		http:       http.DefaultClient,
+		retries:    defaultRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		header:     make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.retries < 0 || c.minBackoff <= 0 || c.maxBackoff < c.minBackoff {
+		return nil, errors.New("invalid retry settings")
+	}
+	return c, nil
+}
+
+// Fib returns fib(n).
+func (c *Client) Fib(ctx context.Context, n int) (uint64, error) {
+	var res apitypes.ResultResponse
+	err := c.do(ctx, call{method: http.MethodGet, path: "/v1/fib",
+		query: url.Values{"n": {strconv.Itoa(n)}}, idempotent: true}, &res)
+	return res.Result, err
+}
+
+// FibLess returns the number of memos less than the target.
+func (c *Client) FibLess(ctx context.Context, target uint64) (int, error) {
+	var res apitypes.ResultResponse
+	err := c.do(ctx, call{method: http.MethodGet, path: "/v1/fibless",
+		query: url.Values{"target": {strconv.FormatUint(target, 10)}}, idempotent: true}, &res)
+	return int(res.Result), err
+}
+
+// Batch computes fib(n) for many n, and FibLess for many targets, at once.
+// The items that can't be computed have their own errors.
+func (c *Client) Batch(ctx context.Context, ns []int, targets []uint64) (*apitypes.BatchResponse, error) {
+	var res apitypes.BatchResponse
+	err := c.do(ctx, call{method: http.MethodPost, path: "/v1/fib/batch",
+		body: apitypes.BatchRequest{N: ns, Targets: targets}, idempotent: true}, &res)
+	return &res, err
+}
+
+// Memos returns a page of at most limit memos, after the cursor of the
+// previous page, which is empty for the first.  A limit of zero is the
+// server's default.
+func (c *Client) Memos(ctx context.Context, after string, limit int) (*apitypes.ListResponse, error) {
+	q := url.Values{}
+	if after != "" {
+		q.Set("after", after)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var res apitypes.ListResponse
+	err := c.do(ctx, call{method: http.MethodGet, path: "/v1/memos", query: q, idempotent: true}, &res)
+	return &res, err
+}
+
+// Memo returns the memo for n and its metadata.
+func (c *Client) Memo(ctx context.Context, n int) (*apitypes.Memo, error) {
+	var res apitypes.Memo
+	err := c.do(ctx, call{method: http.MethodGet, path: "/v1/memos/" + strconv.Itoa(n), idempotent: true}, &res)
+	return &res, err
+}
+
+// Clear removes every memo, returning how many were removed.
+func (c *Client) Clear(ctx context.Context) (int, error) {
+	return c.clear(ctx, url.Values{})
+}
+
+// ClearRange removes the memos for n in [from, to], returning how many
+// were removed.
+func (c *Client) ClearRange(ctx context.Context, from, to int) (int, error) {
+	return c.clear(ctx, url.Values{"from": {strconv.Itoa(from)}, "to": {strconv.Itoa(to)}})
+}
+
+// CountRange returns how many memos ClearRange would remove, without
+// removing them.
+func (c *Client) CountRange(ctx context.Context, from, to int) (int, error) {
+	res, err := c.deleteMemos(ctx, url.Values{"from": {strconv.Itoa(from)}, "to": {strconv.Itoa(to)},
+		"dry_run": {"true"}})
+	if err != nil {
+		return 0, err
+	}
+	return res.Count, nil
+}
+
+// Removing memos is a dry run, for the confirmation token, and then the
+// removal with the token.
+func (c *Client) clear(ctx context.Context, q url.Values) (int, error) {
+	q.Set("dry_run", "true")
+	res, err := c.deleteMemos(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	q.Del("dry_run")
+	q.Set("confirm", res.Confirm)
+	if res, err = c.deleteMemos(ctx, q); err != nil {
+		return 0, err
+	}
+	return res.Count, nil
+}
+
+func (c *Client) deleteMemos(ctx context.Context, q url.Values) (*apitypes.ClearResponse, error) {
+	var res apitypes.ClearResponse
+	err := c.do(ctx, call{method: http.MethodDelete, path: "/v1/memos", query: q, idempotent: true}, &res)
+	return &res, err
+}
+
+// SubmitJob submits a job computing a batch in the background.  The job
+// is polled with Job for its result.
+func (c *Client) SubmitJob(ctx context.Context, ns []int, targets []uint64) (*apitypes.JobResponse, error) {
+	var res apitypes.JobResponse
+	err := c.do(ctx, call{method: http.MethodPost, path: "/v1/jobs",
+		body: apitypes.BatchRequest{N: ns, Targets: targets}}, &res)
+	return &res, err
+}
+
+// Job returns the state of a job, and its result once it has succeeded.
+func (c *Client) Job(ctx context.Context, id string) (*apitypes.JobResponse, error) {
+	var res apitypes.JobResponse
+	err := c.do(ctx, call{method: http.MethodGet, path: "/v1/jobs/" + url.PathEscape(id), idempotent: true}, &res)
+	return &res, err
+}
+
+// CancelJob cancels a job that is queued or running.
+func (c *Client) CancelJob(ctx context.Context, id string) (*apitypes.JobResponse, error) {
+	var res apitypes.JobResponse
+	err := c.do(ctx, call{method: http.MethodDelete, path: "/v1/jobs/" + url.PathEscape(id), idempotent: true}, &res)
+	return &res, err
+}
+
+// QueueJob adds a job precomputing a range of n to the durable queue.
+func (c *Client) QueueJob(ctx context.Context, req apitypes.QueueRequest) (*apitypes.QueuedJobResponse, error) {
+	var res apitypes.QueuedJobResponse
+	err := c.do(ctx, call{method: http.MethodPost, path: "/v1/queue", body: req}, &res)
+	return &res, err
+}
+
+// QueuedJob returns a job of the durable queue.
+func (c *Client) QueuedJob(ctx context.Context, id int64) (*apitypes.QueuedJobResponse, error) {
+	var res apitypes.QueuedJobResponse
+	err := c.do(ctx, call{method: http.MethodGet, path: "/v1/queue/" + strconv.FormatInt(id, 10),
+		idempotent: true}, &res)
+	return &res, err
+}
+
+// A call of the api.  Idempotent calls are retried even when the server
+// may have handled them.
+type call struct {
+	method     string
+	path       string
+	query      url.Values
+	body       interface{}
+	idempotent bool
+}
+
+// Makes the call, retrying it as needed, and decodes the response into res.
+func (c *Client) do(ctx context.Context, cl call, res interface{}) error {
+	u := *c.base
+	u.Path += cl.path
+	u.RawQuery = cl.query.Encode()
+	var body []byte
+	if cl.body != nil {
+		var err error
+		if body, err = json.Marshal(cl.body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, cl.method, u.String(), body, res)
+		if err == nil || attempt >= c.retries || !retryable(err, cl.idempotent) {
+			return err
+		}
+		wait := c.backoff(attempt)
+		var e *Error
+		if errors.As(err, &e) && e.RetryAfter > wait {
+			wait = e.RetryAfter
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// Makes one request.
+func (c *Client) attempt(ctx context.Context, method, u string, body []byte, res interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return newError(resp, b)
+	}
+	if err := json.Unmarshal(b, res); err != nil {
+		return fmt.Errorf("invalid response from %s %s: %v", method, u, err)
+	}
+	return nil
+}
+
+// Reports whether a failed call may be retried.  The server didn't handle
+// the call if it was rate limited or unavailable, while after a network
+// error or a gateway's error it might have.
+func retryable(err error, idempotent bool) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		return idempotent
+	}
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// Returns the wait before a retry: exponential, with jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.maxBackoff
+	if attempt < 30 && c.minBackoff<<uint(attempt) < c.maxBackoff {
+		d = c.minBackoff << uint(attempt)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/api"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Returns a client of a server running the api.
+func newServer(t *testing.T) *Client {
+	st := store.NewMap()
+	svc, err := service.NewFib(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := service.NewJobs(svc, service.JobConfig{Workers: 1})
+	t.Cleanup(func() { jobs.Shutdown(context.Background()) })
+	r := mux.NewRouter()
+	if err := api.Init(context.Background(), r, svc, zap.NewNop().Sugar(),
+		api.Config{Jobs: jobs, Queue: st.(store.Queue)}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, WithBackoff(time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Tests the calls of each endpoint against the api.
+func TestClient(t *testing.T) {
+	c := newServer(t)
+	ctx := context.Background()
+
+	for _, v := range []struct {
+		n   int
+		res uint64
+	}{{0, 0}, {1, 1}, {10, 55}, {20, 6765}} {
+		res, err := c.Fib(ctx, v.n)
+		if err != nil || res != v.res {
+			t.Fatalf("fib(%d): expected %d, got %d %v", v.n, v.res, res, err)
+		}
+	}
+	if cnt, err := c.FibLess(ctx, 11); err != nil || cnt != 7 {
+		t.Fatalf("expected 7 memos less than 11, got %d %v", cnt, err)
+	}
+	br, err := c.Batch(ctx, []int{5, 94}, []uint64{100})
+	if err != nil || len(br.Results) != 2 || *br.Results[0].Value != 5 || br.Results[1].Error == "" ||
+		len(br.Less) != 1 {
+		t.Fatalf("unexpected batch: %+v %v", br, err)
+	}
+
+	// The pages of memos.
+	page, err := c.Memos(ctx, "", 15)
+	if err != nil || len(page.Memos) != 15 || page.Next == "" {
+		t.Fatalf("unexpected first page: %+v %v", page, err)
+	}
+	if page, err = c.Memos(ctx, page.Next, 15); err != nil || len(page.Memos) != 6 || page.Next != "" {
+		t.Fatalf("unexpected last page: %+v %v", page, err)
+	}
+	m, err := c.Memo(ctx, 10)
+	if err != nil || m.Num != 10 || m.Value != 55 {
+		t.Fatalf("unexpected memo: %+v %v", m, err)
+	}
+
+	// Removing memos.
+	if cnt, err := c.CountRange(ctx, 0, 9); err != nil || cnt != 10 {
+		t.Fatalf("expected 10 memos in range, got %d %v", cnt, err)
+	}
+	if cnt, err := c.ClearRange(ctx, 0, 9); err != nil || cnt != 10 {
+		t.Fatalf("expected 10 memos removed, got %d %v", cnt, err)
+	}
+	if cnt, err := c.Clear(ctx); err != nil || cnt != 11 {
+		t.Fatalf("expected 11 memos removed, got %d %v", cnt, err)
+	}
+
+	// Jobs.
+	job, err := c.SubmitJob(ctx, []int{30}, nil)
+	if err != nil || job.ID == "" {
+		t.Fatalf("unexpected job: %+v %v", job, err)
+	}
+	for job.State != "succeeded" {
+		time.Sleep(time.Millisecond)
+		if job, err = c.Job(ctx, job.ID); err != nil || job.State == "failed" {
+			t.Fatalf("unexpected job: %+v %v", job, err)
+		}
+	}
+	if *job.Result.Results[0].Value != 832040 {
+		t.Fatalf("unexpected job result: %+v", job.Result)
+	}
+	if _, err := c.CancelJob(ctx, job.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conflict cancelling a finished job, got %v", err)
+	}
+	qj, err := c.QueueJob(ctx, api.QueueRequest{From: 1, To: 5})
+	if err != nil || qj.State != "pending" {
+		t.Fatalf("unexpected queued job: %+v %v", qj, err)
+	}
+	if qj, err = c.QueuedJob(ctx, qj.ID); err != nil || qj.To != 5 {
+		t.Fatalf("unexpected queued job: %+v %v", qj, err)
+	}
+
+	// Errors.
+	_, err = c.Memo(ctx, 45)
+	var e *Error
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &e) || e.Problem.Code != "not_found" ||
+		e.Problem.RequestID == "" {
+		t.Fatalf("expected not found, got %#v", err)
+	}
+	_, err = c.Fib(ctx, 99999999)
+	if !errors.Is(err, ErrLimitExceeded) || !errors.As(err, &e) || e.Limit == "" {
+		t.Fatalf("expected a limit, got %#v", err)
+	}
+	if _, err := c.Memos(ctx, "bad", 0); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected an invalid request, got %v", err)
+	}
+}
+
+// Tests the failed calls are retried, and only when that is safe.
+func TestRetries(t *testing.T) {
+	var calls int32
+	var fail []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&calls, 1)) - 1
+		if i < len(fail) {
+			if fail[i] == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			w.WriteHeader(fail[i])
+			return
+		}
+		if r.URL.Path == "/v1/jobs" {
+			w.Write([]byte(`{"id": "00ff"}`))
+			return
+		}
+		w.Write([]byte(`{"result": 55}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	for i, v := range []struct {
+		retries int
+		fail    []int
+		job     bool // not idempotent
+		calls   int
+		err     error
+	}{
+		{3, nil, false, 1, nil},
+		{3, []int{503, 502, 504}, false, 4, nil},
+		{2, []int{503, 503, 503}, false, 3, ErrUnavailable},
+		{0, []int{503}, false, 1, ErrServer},
+		{3, []int{400}, false, 1, ErrInvalid},
+		{3, []int{500}, false, 1, ErrServer},
+		{3, []int{503}, true, 2, nil},
+		{3, []int{502}, true, 1, ErrServer},
+		{0, []int{429}, false, 1, ErrRateLimited},
+	} {
+		c, err := New(srv.URL, WithRetries(v.retries), WithBackoff(time.Millisecond, 2*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&calls, 0)
+		fail = v.fail
+		if v.job {
+			_, err = c.SubmitJob(ctx, []int{10}, nil)
+		} else {
+			_, err = c.Fib(ctx, 10)
+		}
+		if !errors.Is(err, v.err) || (v.err == nil) != (err == nil) {
+			t.Fatalf("%d: expected %v, got %v", i, v.err, err)
+		}
+		if n := int(atomic.LoadInt32(&calls)); n != v.calls {
+			t.Fatalf("%d: expected %d calls, got %d", i, v.calls, n)
+		}
+		var e *Error
+		if errors.Is(err, ErrRateLimited) && (!errors.As(err, &e) || e.RetryAfter != time.Second) {
+			t.Fatalf("%d: expected to retry after a second, got %v", i, e)
+		}
+	}
+
+	// The wait for a retry ends with the context.
+	c, err := New(srv.URL, WithBackoff(time.Hour, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&calls, 0)
+	fail = []int{503}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Fib(ctx, 10); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected the last error once cancelled, got %v", err)
+	}
+}
+
+// Tests the requests carry the credentials.
+func TestCredentials(t *testing.T) {
+	var key, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, auth = r.Header.Get("X-API-Key"), r.Header.Get("Authorization")
+		w.Write([]byte(`{"result": 1}`))
+	}))
+	defer srv.Close()
+	c, err := New(srv.URL+"/", WithAPIKey("secret"), WithBearerToken("jwt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Fib(context.Background(), 1); err != nil || key != "secret" || auth != "Bearer jwt" {
+		t.Fatalf("unexpected credentials %q %q: %v", key, auth, err)
+	}
+
+	for _, u := range []string{"", "localhost:8080", "ftp://host", "http://"} {
+		if _, err := New(u); err == nil {
+			t.Fatalf("expected %q to be invalid", u)
+		}
+	}
+	if _, err := New("http://host", WithRetries(-1)); err == nil {
+		t.Fatal("expected negative retries to be invalid")
+	}
+}
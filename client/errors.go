@@ -0,0 +1,97 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/apitypes"
+)
+
+// The kinds of errors returned by the server, to be checked with
+// errors.Is.
+var (
+	ErrInvalid       = errors.New("invalid request")
+	ErrUnauthorized  = errors.New("not authenticated")
+	ErrForbidden     = errors.New("not authorized")
+	ErrNotFound      = errors.New("not found")
+	ErrConflict      = errors.New("conflict")
+	ErrConfirmation  = errors.New("confirmation required or invalid")
+	ErrLimitExceeded = errors.New("limit exceeded")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrUnavailable   = errors.New("service unavailable")
+	ErrServer        = errors.New("server error")
+)
+
+// Error is returned for the requests the server rejected, with the
+// problem it returned.
+type Error struct {
+	StatusCode int
+	Problem    apitypes.Problem
+
+	// Limit is the computation limit exceeded, if any.
+	Limit string
+
+	// RetryAfter is how long the server asked to wait before retrying.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := e.Problem.Detail
+	if msg == "" {
+		msg = e.Problem.Title
+	}
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Problem.Code != "" {
+		return fmt.Sprintf("fibsrv: %d %s: %s", e.StatusCode, e.Problem.Code, msg)
+	}
+	return fmt.Sprintf("fibsrv: %d: %s", e.StatusCode, msg)
+}
+
+// Is reports whether the error is of the kind of the target, from its
+// status code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrInvalid
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return target == ErrConfirmation
+	case http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return target == ErrLimitExceeded
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusServiceUnavailable:
+		return target == ErrUnavailable || target == ErrServer
+	}
+	return e.StatusCode >= http.StatusInternalServerError && target == ErrServer
+}
+
+// Returns the error of a rejected request.  The body is a problem, unless
+// the request was rejected before reaching the service, as by a proxy.
+func newError(resp *http.Response, body []byte) *Error {
+	e := &Error{StatusCode: resp.StatusCode}
+	var p struct {
+		apitypes.Problem
+		Limit string `json:"limit"`
+	}
+	if json.Unmarshal(body, &p) == nil {
+		e.Problem, e.Limit = p.Problem, p.Limit
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gdotgordon/fibsrv/apitypes"
+	"github.com/gdotgordon/fibsrv/client"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+)
+
+// The calls of the commands, made on a server or in process.
+type backend interface {
+	Fib(ctx context.Context, n int) (uint64, error)
+	FibLess(ctx context.Context, target uint64) (int, error)
+	Memos(ctx context.Context, after string, limit int) (*apitypes.ListResponse, error)
+	Memo(ctx context.Context, n int) (*apitypes.Memo, error)
+	CountRange(ctx context.Context, from, to int) (int, error)
+	ClearRange(ctx context.Context, from, to int) (int, error)
+}
+
+// Returns the client of the server, or the service in process.
+func newBackend(offline bool, s settings) (backend, error) {
+	if offline {
+		svc, err := service.NewFib(store.NewMap())
+		if err != nil {
+			return nil, err
+		}
+		return offlineBackend{svc}, nil
+	}
+	opts := []client.Option{client.WithRetries(s.Retries)}
+	if s.APIKey != "" {
+		opts = append(opts, client.WithAPIKey(s.APIKey))
+	}
+	if s.Token != "" {
+		opts = append(opts, client.WithBearerToken(s.Token))
+	}
+	return client.New(s.Server, opts...)
+}
+
+// The service run in process, on an in-memory store, so the memos only
+// last for the command.
+type offlineBackend struct {
+	svc *service.FibService
+}
+
+func (ob offlineBackend) Fib(ctx context.Context, n int) (uint64, error) {
+	return ob.svc.Fib(ctx, n)
+}
+
+func (ob offlineBackend) FibLess(ctx context.Context, target uint64) (int, error) {
+	return ob.svc.FibLess(ctx, target)
+}
+
+// The cursors are the last n of the previous page.
+func (ob offlineBackend) Memos(ctx context.Context, after string, limit int) (*apitypes.ListResponse, error) {
+	from := -1
+	if after != "" {
+		var err error
+		if from, err = strconv.Atoi(after); err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+	memos, err := ob.svc.List(ctx, from, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	res := &apitypes.ListResponse{Memos: make([]apitypes.Memo, 0, len(memos))}
+	for _, m := range memos {
+		res.Memos = append(res.Memos, apitypes.Memo(m))
+	}
+	if len(memos) > limit {
+		res.Memos = res.Memos[:limit]
+		res.Next = strconv.Itoa(memos[limit-1].Num)
+	}
+	return res, nil
+}
+
+func (ob offlineBackend) Memo(ctx context.Context, n int) (*apitypes.Memo, error) {
+	m, ok, err := ob.svc.MemoDetail(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no memo for %d", n)
+	}
+	res := apitypes.Memo(m)
+	return &res, nil
+}
+
+func (ob offlineBackend) CountRange(ctx context.Context, from, to int) (int, error) {
+	return ob.svc.ClearRange(ctx, from, to, true)
+}
+
+func (ob offlineBackend) ClearRange(ctx context.Context, from, to int) (int, error) {
+	return ob.svc.ClearRange(ctx, from, to, false)
+}
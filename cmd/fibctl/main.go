@@ -0,0 +1,296 @@
+// Command fibctl calls the fibonacci service from the command line.  It
+// connects to a server with the settings of a profile, or runs the service
+// in process with -offline.
+//
+// Usage:
+//
+//	fibctl [flags] <command> [args]
+//
+// The commands are:
+//
+//	fib N          computes fib(N)
+//	less TARGET    counts the memos less than TARGET
+//	clear          counts the memos in a range, and removes them with -confirm
+//	memos list     lists a page of memos
+//	memos get N    shows the memo for N
+//
+// Flags may be given before or after the command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.LookupEnv, os.Stdout, os.Stderr)
+	var ue usageError
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+	case errors.As(err, &ue):
+		fmt.Fprintln(os.Stderr, "fibctl:", err)
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "fibctl:", err)
+		os.Exit(1)
+	}
+}
+
+// An error in the command line.
+type usageError string
+
+func (ue usageError) Error() string {
+	return string(ue)
+}
+
+// The flags of every command.
+type options struct {
+	configFile string
+	profile    string
+	server     string
+	apiKey     string
+	token      string
+	output     string
+	offline    bool
+	timeout    time.Duration
+	retries    int
+
+	set map[string]bool // the flags given on the command line
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", "", "profiles file (default $FIBCTL_CONFIG, or fibctl/config.yaml in the user config directory)")
+	fs.StringVar(&o.profile, "profile", "", "connection profile (default $FIBCTL_PROFILE, or the file's default)")
+	fs.StringVar(&o.server, "server", "", "server base URL (default "+defaultServer+")")
+	fs.StringVar(&o.apiKey, "api-key", "", "API key")
+	fs.StringVar(&o.token, "token", "", "JWT bearer token")
+	fs.StringVar(&o.output, "o", "", "output format: 'table', 'json' (default table)")
+	fs.BoolVar(&o.offline, "offline", false, "run the service in process, on an in-memory store, without a server")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "time allowed for the command")
+	fs.IntVar(&o.retries, "retries", 3, "retries of a failed request")
+}
+
+// A command: its arguments and summary for the usage, and the setup of
+// its own flags, returning the function running it.
+type command struct {
+	args, summary string
+	setup         func(fs *flag.FlagSet) runner
+}
+
+type runner func(ctx context.Context, b backend, p *printer, args []string) error
+
+var commands = map[string]command{
+	"fib":   {"N", "computes fib(N)", fibCmd},
+	"less":  {"TARGET", "counts the memos less than TARGET", lessCmd},
+	"clear": {"", "counts the memos in a range, and removes them with -confirm", clearCmd},
+	"memos": {"list|get N", "lists a page of memos, or shows the memo for N", memosCmd},
+}
+
+// Runs the command line.
+func run(ctx context.Context, args []string, lookupEnv func(string) (string, bool), stdout, stderr io.Writer) error {
+	// The flags before the command are those of every command.
+	root := flag.NewFlagSet("fibctl", flag.ContinueOnError)
+	root.SetOutput(stderr)
+	root.Usage = func() { usage(root) }
+	var o options
+	o.register(root)
+	if err := root.Parse(args); err != nil {
+		return err
+	}
+	if root.NArg() == 0 {
+		root.Usage()
+		return usageError("no command given")
+	}
+	name := root.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown command %q", name))
+	}
+
+	// The command is parsed again with its own flags, which may be given
+	// anywhere.
+	fs := flag.NewFlagSet("fibctl "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	o = options{set: make(map[string]bool)}
+	o.register(fs)
+	runCmd := cmd.setup(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: fibctl %s [flags] %s\n\n%s\n\nFlags:\n", name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	pre := args[:len(args)-root.NArg()]
+	pos, err := parseInterspersed(fs, append(append([]string{}, pre...), root.Args()[1:]...))
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) { o.set[f.Name] = true })
+
+	prof, err := loadProfile(o, lookupEnv)
+	if err != nil {
+		return err
+	}
+	p, err := newPrinter(prof.Output, stdout, stderr)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(ctx, prof.Timeout)
+	defer cancel()
+	b, err := newBackend(o.offline, prof)
+	if err != nil {
+		return err
+	}
+	return runCmd(ctx, b, p, pos)
+}
+
+// Parses the flags wherever they are among the arguments, returning the
+// arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return pos, nil
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintln(w, "Usage: fibctl [flags] <command> [args]\n\nCommands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-20s %s\n", name+" "+commands[name].args, commands[name].summary)
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	fs.PrintDefaults()
+}
+
+// Returns the only argument, a non-negative integer.
+func intArg(args []string, name string) (int, error) {
+	if len(args) != 1 {
+		return 0, usageError(fmt.Sprintf("expected %s", name))
+	}
+	v, err := strconv.Atoi(args[0])
+	if err != nil || v < 0 {
+		return 0, usageError(fmt.Sprintf("invalid %s: %q", name, args[0]))
+	}
+	return v, nil
+}
+
+func fibCmd(fs *flag.FlagSet) runner {
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		n, err := intArg(args, "N")
+		if err != nil {
+			return err
+		}
+		v, err := b.Fib(ctx, n)
+		if err != nil {
+			return err
+		}
+		return p.print(struct {
+			N     int    `json:"n"`
+			Value uint64 `json:"value"`
+		}{n, v}, []string{"N", "VALUE"}, [][]string{{strconv.Itoa(n), strconv.FormatUint(v, 10)}})
+	}
+}
+
+func lessCmd(fs *flag.FlagSet) runner {
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		target, err := intArg(args, "TARGET")
+		if err != nil {
+			return err
+		}
+		cnt, err := b.FibLess(ctx, uint64(target))
+		if err != nil {
+			return err
+		}
+		return p.print(struct {
+			Target int `json:"target"`
+			Count  int `json:"count"`
+		}{target, cnt}, []string{"TARGET", "COUNT"}, [][]string{{strconv.Itoa(target), strconv.Itoa(cnt)}})
+	}
+}
+
+// Without -confirm, clear is a dry run, so memos are never removed by
+// mistake.
+func clearCmd(fs *flag.FlagSet) runner {
+	confirm := fs.Bool("confirm", false, "remove the memos, rather than only counting them")
+	from := fs.Int("from", 0, "first n of the range")
+	to := fs.Int("to", math.MaxInt32, "last n of the range")
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		if len(args) != 0 {
+			return usageError("clear takes no arguments")
+		}
+		if *from < 0 || *to < *from {
+			return usageError(fmt.Sprintf("invalid range [%d, %d]", *from, *to))
+		}
+		var cnt int
+		var err error
+		if *confirm {
+			cnt, err = b.ClearRange(ctx, *from, *to)
+		} else {
+			cnt, err = b.CountRange(ctx, *from, *to)
+		}
+		if err != nil {
+			return err
+		}
+		if !*confirm {
+			p.note("dry run: rerun with -confirm to remove the memos")
+		}
+		return p.print(struct {
+			Count   int  `json:"count"`
+			Removed bool `json:"removed"`
+		}{cnt, *confirm}, []string{"COUNT", "REMOVED"}, [][]string{{strconv.Itoa(cnt), strconv.FormatBool(*confirm)}})
+	}
+}
+
+func memosCmd(fs *flag.FlagSet) runner {
+	after := fs.String("after", "", "cursor of the page, from the previous one")
+	limit := fs.Int("limit", 0, "page size (default the server's)")
+	return func(ctx context.Context, b backend, p *printer, args []string) error {
+		if len(args) == 0 {
+			return usageError("expected list or get")
+		}
+		switch args[0] {
+		case "list":
+			if len(args) != 1 {
+				return usageError("memos list takes no arguments")
+			}
+			page, err := b.Memos(ctx, *after, *limit)
+			if err != nil {
+				return err
+			}
+			if page.Next != "" {
+				p.note("next page: -after " + page.Next)
+			}
+			return p.print(page, memoHeader, memoRows(page.Memos...))
+		case "get":
+			n, err := intArg(args[1:], "N")
+			if err != nil {
+				return err
+			}
+			m, err := b.Memo(ctx, n)
+			if err != nil {
+				return err
+			}
+			return p.print(m, memoHeader, memoRows(*m))
+		}
+		return usageError(fmt.Sprintf("unknown memos command %q", args[0]))
+	}
+}
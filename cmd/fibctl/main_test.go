@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gdotgordon/fibsrv/api"
+	"github.com/gdotgordon/fibsrv/client"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Runs the command line with the environment, returning stdout and
+// stderr.
+func runCmd(env map[string]string, args ...string) (string, string, error) {
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+	var out, errs bytes.Buffer
+	err := run(context.Background(), args, lookup, &out, &errs)
+	return out.String(), errs.String(), err
+}
+
+// Tests the commands run in process.
+func TestOffline(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "profiles.yaml")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	noFile := map[string]string{"FIBCTL_CONFIG": file}
+	for i, v := range []struct {
+		args []string
+		out  string
+	}{
+		{[]string{"-offline", "fib", "42"}, "N   VALUE\n42  267914296\n"},
+		{[]string{"fib", "10", "-offline", "-o", "json"}, "{\n  \"n\": 10,\n  \"value\": 55\n}\n"},
+		{[]string{"-offline", "less", "1000"}, "TARGET  COUNT\n1000    17\n"},
+		{[]string{"clear", "--confirm", "--offline", "-o=json"}, "{\n  \"count\": 0,\n  \"removed\": true\n}\n"},
+	} {
+		out, _, err := runCmd(noFile, v.args...)
+		if err != nil || out != v.out {
+			t.Fatalf("%d: expected %q, got %q: %v", i, v.out, out, err)
+		}
+	}
+
+	var ue usageError
+	for i, args := range [][]string{
+		{},
+		{"nothing"},
+		{"-offline", "fib"},
+		{"-offline", "fib", "x"},
+		{"-offline", "fib", "1", "2"},
+		{"-offline", "-o", "xml", "fib", "1"},
+		{"-offline", "memos", "delete"},
+		{"-offline", "clear", "-from", "5", "-to", "1"},
+	} {
+		if _, _, err := runCmd(noFile, args...); !errors.As(err, &ue) {
+			t.Fatalf("%d: expected a usage error for %q, got %v", i, args, err)
+		}
+	}
+	if _, _, err := runCmd(noFile, "-offline", "fib", "-bogus", "1"); err == nil {
+		t.Fatal("expected an unknown flag to fail")
+	}
+}
+
+// Tests the commands against a server, with the settings of a profile.
+func TestServer(t *testing.T) {
+	svc, err := service.NewFib(store.NewMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	if err := api.Init(context.Background(), r, svc, zap.NewNop().Sugar(), api.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "profiles.yaml")
+	profs := "default: down\nprofiles:\n  down:\n    server: http://127.0.0.1:1\n    retries: 0\n" +
+		"  test:\n    server: " + srv.URL + "\n    output: json\n    timeout: 5s\n"
+	if err := ioutil.WriteFile(file, []byte(profs), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"FIBCTL_CONFIG": file, "FIBCTL_PROFILE": "test"}
+
+	out, _, err := runCmd(env, "fib", "20")
+	var res struct{ N, Value int }
+	if err != nil || json.Unmarshal([]byte(out), &res) != nil || res.N != 20 || res.Value != 6765 {
+		t.Fatalf("unexpected fib: %q %v", out, err)
+	}
+	out, notes, err := runCmd(env, "memos", "list", "-limit", "5", "-o", "table")
+	if err != nil || strings.Count(out, "\n") != 6 || !strings.HasPrefix(out, "N ") ||
+		!strings.Contains(notes, "next page: -after ") {
+		t.Fatalf("unexpected memos: %q %q %v", out, notes, err)
+	}
+	out, _, err = runCmd(env, "memos", "get", "10")
+	var m store.FibPair
+	if err != nil || json.Unmarshal([]byte(out), &m) != nil || m.Value != 55 {
+		t.Fatalf("unexpected memo: %q %v", out, err)
+	}
+	if out, notes, err = runCmd(env, "clear", "-from", "0", "-to", "9", "-o", "table"); err != nil ||
+		!strings.Contains(out, "10     false") || !strings.Contains(notes, "dry run") {
+		t.Fatalf("unexpected dry run: %q %q %v", out, notes, err)
+	}
+	if out, _, err = runCmd(env, "clear", "--confirm"); err != nil || !strings.Contains(out, `"count": 21`) {
+		t.Fatalf("unexpected clear: %q %v", out, err)
+	}
+	if _, _, err = runCmd(env, "memos", "get", "10"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	// The default profile, and the flags overriding it.
+	if _, _, err = runCmd(map[string]string{"FIBCTL_CONFIG": file}, "fib", "1"); err == nil {
+		t.Fatal("expected the default profile to be used")
+	}
+	if _, _, err := runCmd(map[string]string{"FIBCTL_CONFIG": file}, "-server", srv.URL, "fib", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := runCmd(env, "-profile", "other", "fib", "1"); err == nil {
+		t.Fatal("expected an unknown profile to fail")
+	}
+	if _, _, err := runCmd(nil, "-config", file+".missing", "fib", "1"); err == nil {
+		t.Fatal("expected a missing profiles file to fail")
+	}
+}
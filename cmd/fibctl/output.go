@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/apitypes"
+)
+
+// Prints the results as a table or as JSON.  Notes for the user go to
+// stderr, so that the output can be piped.
+type printer struct {
+	format string
+	out    io.Writer
+	notes  io.Writer
+}
+
+func newPrinter(format string, out, notes io.Writer) (*printer, error) {
+	if format != "table" && format != "json" {
+		return nil, fmt.Errorf("invalid output format %q", format)
+	}
+	return &printer{format: format, out: out, notes: notes}, nil
+}
+
+// Prints the result: v as JSON, or the rows as a table.
+func (p *printer) print(v interface{}, header []string, rows [][]string) error {
+	if p.format == "json" {
+		enc := json.NewEncoder(p.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
+
+// Prints a note, only with tables, as JSON is meant for programs.
+func (p *printer) note(msg string) {
+	if p.format == "table" {
+		fmt.Fprintln(p.notes, msg)
+	}
+}
+
+var memoHeader = []string{"N", "VALUE", "CREATED", "LAST ACCESSED", "HITS", "WRITER"}
+
+func memoRows(memos ...apitypes.Memo) [][]string {
+	var rows [][]string
+	for _, m := range memos {
+		accessed := "-"
+		if m.LastAccessedAt != nil {
+			accessed = m.LastAccessedAt.Format(time.RFC3339)
+		}
+		writer := m.WriterID
+		if writer == "" {
+			writer = "-"
+		}
+		rows = append(rows, []string{strconv.Itoa(m.Num), strconv.FormatUint(m.Value, 10),
+			m.CreatedAt.Format(time.RFC3339), accessed, strconv.FormatInt(m.HitCount, 10), writer})
+	}
+	return rows
+}
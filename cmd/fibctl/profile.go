@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/config"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultServer = "http://localhost:8080"
+
+// Profiles is the file of connection profiles, such as:
+//
+//	default: local
+//	profiles:
+//	  local:
+//	    server: http://localhost:8080
+//	  prod:
+//	    server: https://fib.example.com
+//	    api_key: ...
+//	    output: json
+//	    timeout: 10s
+type Profiles struct {
+	Default  string             `yaml:"default"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile is the settings of a server.  The flags override them.
+type Profile struct {
+	Server  string          `yaml:"server"`
+	APIKey  string          `yaml:"api_key"`
+	Token   string          `yaml:"token"`
+	Output  string          `yaml:"output"`
+	Timeout config.Duration `yaml:"timeout"`
+	Retries *int            `yaml:"retries"`
+}
+
+// The settings of a command, once the profile and the flags are merged.
+type settings struct {
+	Server  string
+	APIKey  string
+	Token   string
+	Output  string
+	Timeout time.Duration
+	Retries int
+}
+
+// Returns the settings of the selected profile, overridden by the flags.
+// The profiles file is optional, unless it is named.
+func loadProfile(o options, lookupEnv func(string) (string, bool)) (settings, error) {
+	path, named := o.configFile, o.configFile != ""
+	if !named {
+		if p, ok := lookupEnv("FIBCTL_CONFIG"); ok && p != "" {
+			path, named = p, true
+		} else if dir, err := os.UserConfigDir(); err == nil {
+			path = filepath.Join(dir, "fibctl", "config.yaml")
+		}
+	}
+	var profs Profiles
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := yaml.UnmarshalStrict(b, &profs); err != nil {
+				return settings{}, fmt.Errorf("profiles file %s: %v", path, err)
+			}
+		case named || !os.IsNotExist(err):
+			return settings{}, err
+		}
+	}
+
+	name := o.profile
+	if name == "" {
+		name, _ = lookupEnv("FIBCTL_PROFILE")
+	}
+	if name == "" {
+		name = profs.Default
+	}
+	var prof Profile
+	if name != "" {
+		var ok bool
+		if prof, ok = profs.Profiles[name]; !ok {
+			return settings{}, usageError(fmt.Sprintf("no profile %q", name))
+		}
+	}
+
+	s := settings{
+		Server:  prof.Server,
+		APIKey:  prof.APIKey,
+		Token:   prof.Token,
+		Output:  prof.Output,
+		Timeout: time.Duration(prof.Timeout),
+		Retries: o.retries,
+	}
+	if prof.Retries != nil && !o.set["retries"] {
+		s.Retries = *prof.Retries
+	}
+	for flag, v := range map[string]struct {
+		dst *string
+		val string
+	}{
+		"server":  {&s.Server, o.server},
+		"api-key": {&s.APIKey, o.apiKey},
+		"token":   {&s.Token, o.token},
+		"o":       {&s.Output, o.output},
+	} {
+		if o.set[flag] {
+			*v.dst = v.val
+		}
+	}
+	if o.set["timeout"] || s.Timeout == 0 {
+		s.Timeout = o.timeout
+	}
+	if s.Server == "" {
+		s.Server = defaultServer
+	}
+	if s.Output == "" {
+		s.Output = "table"
+	}
+	return s, nil
+}
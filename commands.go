@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/gdotgordon/fibsrv/config"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"go.uber.org/zap"
+)
+
+// version is set at build time, with -ldflags "-X main.version=...".
+var version = "dev"
+
+// The exit codes of the commands.
+const (
+	exitOK       = 0
+	exitFailure  = 1 // the command failed
+	exitUsage    = 2 // invalid flags, arguments or configuration
+	exitMismatch = 3 // verify found wrong memos
+)
+
+// A subcommand of fibsrv.  Each parses its own flags, along with those
+// of the configuration, and returns its exit code.
+type command struct {
+	summary string
+	run     func(ctx context.Context, args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":   {"runs the HTTP and gRPC servers (the default)", serve},
+		"worker":  {"runs the workers of the durable job queue", worker},
+		"migrate": {"upgrades the database schema, and reports its version", migrateCmd},
+		"warm":    {"precomputes the memos up to -upto", warmCmd},
+		"export":  {"writes the memos as JSON lines", exportCmd},
+		"import":  {"memoizes the pairs of an export, after checking them", importCmd},
+		"verify":  {"checks every memo against the sequence, fixing them with -fix", verifyCmd},
+		"version": {"prints the version of the binary and of the schema", versionCmd},
+	}
+}
+
+// Runs the subcommand named by the first argument.  Without one, the
+// server is run, so the flags may be given alone as before.
+func run(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return exitOK
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		return exitUsage
+	}
+	return cmd.run(context.Background(), args)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: fibsrv [command] [flags]\n\nCommands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w, "\nRun 'fibsrv <command> -h' for the flags of a command.")
+}
+
+// Returns the flag set of a command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("fibsrv "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: fibsrv %s [flags]\n\n%s\n\nFlags:\n", name, commands[name].summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parses the flags of a command, along with those of the configuration,
+// and loads the configuration.  The configuration is layered from the
+// defaults, an optional file, the environment and the flags.
+func loadConfig(fs *flag.FlagSet, args []string) (*config.Config, int) {
+	l := config.NewLoader(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK
+		}
+		return nil, exitUsage
+	}
+	if fs.NArg() > 0 {
+		return nil, fail(exitUsage, "unexpected arguments", fmt.Errorf("%q", fs.Args()))
+	}
+	cfg, err := l.Load(os.LookupEnv)
+	if err != nil {
+		return nil, fail(exitUsage, "error loading configuration", err)
+	}
+	return cfg, exitOK
+}
+
+// Reports the error, returning the exit code.
+func fail(code int, msg string, err error) int {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", msg, err)
+	return code
+}
+
+// Returns a context cancelled on SIGINT or SIGTERM.
+func interruptible(ctx context.Context, log *zap.SugaredLogger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	interruptChan := make(chan os.Signal, 1)
+	signal.Notify(interruptChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-interruptChan:
+			log.Debugw("Termination signal received", "signal", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(interruptChan)
+	}()
+	return ctx, cancel
+}
+
+// The store and service of a maintenance command.  The limits of the
+// requests don't apply to maintenance.
+type maintenance struct {
+	log *zap.SugaredLogger
+	pg  *store.PostgresStore
+	svc *service.FibService
+}
+
+// Opens the store, upgrading its schema, for a maintenance command.  The
+// memory store has nothing to maintain, as it only lives in the server.
+func openMaintenance(ctx context.Context, cfg *config.Config) (*maintenance, int) {
+	if cfg.Store.Memory() {
+		return nil, fail(exitUsage, "invalid store", errors.New("maintenance requires the postgres store"))
+	}
+	log, err := initLogging(cfg.LogLevel)
+	if err != nil {
+		return nil, fail(exitFailure, "error creating logger", err)
+	}
+	pg, err := openPostgres(ctx, cfg, log)
+	if err != nil {
+		return nil, fail(exitFailure, "error opening store", err)
+	}
+	svc, err := service.NewFib(pg)
+	if err != nil {
+		pg.Shutdown()
+		return nil, fail(exitFailure, "error creating service", err)
+	}
+	return &maintenance{log: log, pg: pg, svc: svc}, exitOK
+}
+
+func (m *maintenance) close() {
+	if err := m.pg.Shutdown(); err != nil {
+		m.log.Infow("Shutdown error", "error", err)
+	}
+}
+
+// Opening the store applies the migrations.
+func migrateCmd(ctx context.Context, args []string) int {
+	cfg, code := loadConfig(newFlagSet("migrate"), args)
+	if code != exitOK || cfg == nil {
+		return code
+	}
+	m, code := openMaintenance(ctx, cfg)
+	if code != exitOK {
+		return code
+	}
+	defer m.close()
+	fmt.Printf("schema version %d\n", m.pg.SchemaVersion())
+	return exitOK
+}
+
+func warmCmd(ctx context.Context, args []string) int {
+	fs := newFlagSet("warm")
+	upto := fs.Int("upto", -1, "largest n to memoize (default the maximum n)")
+	cfg, code := loadConfig(fs, args)
+	if code != exitOK || cfg == nil {
+		return code
+	}
+
+	// The database can't store fib(MaxFibN), so without a maximum n, the
+	// one before it is the largest.
+	maxN := cfg.Limits.MaxN
+	if maxN <= 0 || maxN >= service.MaxFibN {
+		maxN = service.MaxFibN - 1
+	}
+	if *upto < 0 {
+		*upto = maxN
+	}
+	if *upto > maxN {
+		return fail(exitUsage, "invalid -upto", fmt.Errorf("must be between 0 and the maximum n of %d", maxN))
+	}
+	m, code := openMaintenance(ctx, cfg)
+	if code != exitOK {
+		return code
+	}
+	defer m.close()
+	ctx, cancel := interruptible(ctx, m.log)
+	defer cancel()
+
+	before, err := m.pg.CountRange(ctx, 0, *upto)
+	if err != nil {
+		return fail(exitFailure, "error counting memos", err)
+	}
+	if _, err := m.svc.Fib(ctx, *upto); err != nil {
+		return fail(exitFailure, "error computing memos", err)
+	}
+	fmt.Printf("memoized 0 to %d: %d new, %d present\n", *upto, *upto+1-before, before)
+	return exitOK
+}
+
+// Exports are JSON lines of memos, with their metadata.
+func exportCmd(ctx context.Context, args []string) int {
+	fs := newFlagSet("export")
+	out := fs.String("o", "", "output file (default stdout)")
+	cfg, code := loadConfig(fs, args)
+	if code != exitOK || cfg == nil {
+		return code
+	}
+	m, code := openMaintenance(ctx, cfg)
+	if code != exitOK {
+		return code
+	}
+	defer m.close()
+	ctx, cancel := interruptible(ctx, m.log)
+	defer cancel()
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail(exitFailure, "error creating export", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	cnt := 0
+	err := m.svc.Walk(ctx, func(p store.FibPair) error {
+		cnt++
+		return enc.Encode(p)
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && w != os.Stdout {
+		err = w.Close()
+	}
+	if err != nil {
+		return fail(exitFailure, "error exporting memos", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d memos\n", cnt)
+	return exitOK
+}
+
+// Only the values are imported, as the metadata belongs to the memos of
+// the store they were exported from.
+func importCmd(ctx context.Context, args []string) int {
+	fs := newFlagSet("import")
+	in := fs.String("i", "", "input file, from export (default stdin)")
+	cfg, code := loadConfig(fs, args)
+	if code != exitOK || cfg == nil {
+		return code
+	}
+	var pairs []store.FibPair
+	var err error
+	if *in != "" {
+		pairs, err = readPairsFile(*in)
+	} else {
+		pairs, err = readPairs(os.Stdin)
+	}
+	if err != nil {
+		return fail(exitFailure, "error reading import", err)
+	}
+
+	m, code := openMaintenance(ctx, cfg)
+	if code != exitOK {
+		return code
+	}
+	defer m.close()
+	ctx, cancel := interruptible(ctx, m.log)
+	defer cancel()
+	if err := m.svc.Import(ctx, pairs); err != nil {
+		return fail(exitFailure, "error importing memos", err)
+	}
+	fmt.Printf("imported %d memos\n", len(pairs))
+	return exitOK
+}
+
+// Reads the JSON lines of an export.
+func readPairs(r io.Reader) ([]store.FibPair, error) {
+	var pairs []store.FibPair
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var p store.FibPair
+		err := dec.Decode(&p)
+		if err == io.EOF {
+			return pairs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, p)
+	}
+}
+
+func readPairsFile(name string) ([]store.FibPair, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readPairs(f)
+}
+
+func verifyCmd(ctx context.Context, args []string) int {
+	fs := newFlagSet("verify")
+	fix := fs.Bool("fix", false, "replace the wrong memos, and remove those beyond the largest n")
+	cfg, code := loadConfig(fs, args)
+	if code != exitOK || cfg == nil {
+		return code
+	}
+	m, code := openMaintenance(ctx, cfg)
+	if code != exitOK {
+		return code
+	}
+	defer m.close()
+	ctx, cancel := interruptible(ctx, m.log)
+	defer cancel()
+
+	cnt, bad, err := m.svc.Verify(ctx, *fix)
+	for _, mm := range bad {
+		if mm.N > service.MaxFibN {
+			fmt.Printf("n=%d: memo %d, but fib(%d) can't be represented\n", mm.N, mm.Got, mm.N)
+		} else {
+			fmt.Printf("n=%d: memo %d, expected %d\n", mm.N, mm.Got, mm.Want)
+		}
+	}
+	if err != nil {
+		return fail(exitFailure, "error verifying memos", err)
+	}
+	switch {
+	case len(bad) == 0:
+		fmt.Printf("checked %d memos, all correct\n", cnt)
+	case *fix:
+		fmt.Printf("checked %d memos, fixed %d\n", cnt, len(bad))
+	default:
+		fmt.Printf("checked %d memos, %d wrong\n", cnt, len(bad))
+		return exitMismatch
+	}
+	return exitOK
+}
+
+// The version needs no configuration or database.
+func versionCmd(ctx context.Context, args []string) int {
+	fs := newFlagSet("version")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		return fail(exitUsage, "unexpected arguments", fmt.Errorf("%q", fs.Args()))
+	}
+	fmt.Printf("fibsrv %s (%s), schema version %d\n", version, runtime.Version(), store.LatestSchemaVersion())
+	return exitOK
+}
@@ -0,0 +1,425 @@
+// Package config defines the configuration of the fibonacci server, and
+// loads it from layered sources.  In increasing order of precedence,
+// these are:
+//
+//  1. the built-in defaults
+//  2. a YAML or JSON file, named by the -config flag or FIBSRV_CONFIG
+//  3. environment variables, named FIBSRV_ followed by the upper case
+//     flag name with dashes replaced by underscores, e.g. FIBSRV_POSTGRES_HOST
+//  4. command line flags
+//
+// Secrets may also be read from files, by setting the variant of the
+// flag or environment variable with a _FILE (or -file) suffix, such as
+// POSTGRES_PASSWORD_FILE.  This is the convention for Docker secrets.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/tlsconf"
+)
+
+// Config is the complete server configuration.
+type Config struct {
+	// LogLevel is 'production' or 'development'.
+	LogLevel string `yaml:"log_level" json:"log_level"`
+
+	// InstanceID identifies this server instance, the host name by default.
+	InstanceID string `yaml:"instance_id" json:"instance_id"`
+
+	Server   Server   `yaml:"server" json:"server"`
+	Store    Store    `yaml:"store" json:"store"`
+	Postgres Postgres `yaml:"postgres" json:"postgres"`
+	Tracing  Tracing  `yaml:"tracing" json:"tracing"`
+	Auth     Auth     `yaml:"auth" json:"auth"`
+	Limits   Limits   `yaml:"limits" json:"limits"`
+	Jobs     Jobs     `yaml:"jobs" json:"jobs"`
+	Queue    Queue    `yaml:"queue" json:"queue"`
+}
+
+// Server is the HTTP server configuration.
+type Server struct {
+	Port            int      `yaml:"port" json:"port"`
+	ReadTimeout     Duration `yaml:"read_timeout" json:"read_timeout"`
+	WriteTimeout    Duration `yaml:"write_timeout" json:"write_timeout"`
+	IdleTimeout     Duration `yaml:"idle_timeout" json:"idle_timeout"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+
+	// Drain is how long readiness fails before the server stops at shutdown.
+	Drain Duration `yaml:"drain" json:"drain"`
+
+	// LegacyClear enables the deprecated GET /v1/clear.
+	LegacyClear bool `yaml:"legacy_clear" json:"legacy_clear"`
+
+	// GRPCPort is the port of the gRPC server, which is off if zero.
+	GRPCPort int `yaml:"grpc_port" json:"grpc_port"`
+
+	TLS TLS `yaml:"tls" json:"tls"`
+
+	RateLimit RateLimit `yaml:"rate_limit" json:"rate_limit"`
+}
+
+// RateLimit is the per client rate limiting.  The rates are requests per
+// second, and a zero rate or quota means no limit.
+type RateLimit struct {
+	CachedRate  float64 `yaml:"cached_rate" json:"cached_rate"`
+	CachedBurst int     `yaml:"cached_burst" json:"cached_burst"`
+	ColdRate    float64 `yaml:"cold_rate" json:"cold_rate"`
+	ColdBurst   int     `yaml:"cold_burst" json:"cold_burst"`
+	DailyQuota  int     `yaml:"daily_quota" json:"daily_quota"`
+}
+
+// Enabled tells whether any limit is set.
+func (rl RateLimit) Enabled() bool {
+	return rl.CachedRate > 0 || rl.ColdRate > 0 || rl.DailyQuota > 0
+}
+
+// TLS is the server's TLS configuration.  TLS is enabled if the
+// certificate file is set.
+type TLS struct {
+	CertFile     string `yaml:"cert_file" json:"cert_file"`
+	KeyFile      string `yaml:"key_file" json:"key_file"`
+	MinVersion   string `yaml:"min_version" json:"min_version"`
+	CipherPolicy string `yaml:"cipher_policy" json:"cipher_policy"`
+
+	// ClientCAFile and ClientAuth configure mutual TLS.
+	ClientCAFile string `yaml:"client_ca_file" json:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth" json:"client_auth"`
+
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval Duration `yaml:"reload_interval" json:"reload_interval"`
+}
+
+// Enabled tells whether the server uses TLS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Settings returns the settings for the tlsconf package.
+func (t TLS) Settings() tlsconf.Config {
+	return tlsconf.Config{
+		CertFile:       t.CertFile,
+		KeyFile:        t.KeyFile,
+		MinVersion:     t.MinVersion,
+		CipherPolicy:   t.CipherPolicy,
+		ClientCAFile:   t.ClientCAFile,
+		ClientAuth:     t.ClientAuth,
+		ReloadInterval: time.Duration(t.ReloadInterval),
+	}
+}
+
+// The kinds of store.
+const (
+	StorePostgres = "postgres"
+	StoreMemory   = "memory"
+)
+
+// Store selects where the memos, quotas and queued jobs are kept.  The
+// memory store needs no database.  Without a snapshot file, it is lost
+// when the server stops, so it is meant for development and tests.
+type Store struct {
+	Kind string `yaml:"kind" json:"kind"`
+
+	// Seed is an export file whose memos are loaded into the memory store.
+	Seed string `yaml:"seed" json:"seed"`
+
+	// Snapshot is the file the memory store is loaded from and persisted
+	// to, every SnapshotInterval (or only at shutdown if 0).
+	Snapshot         string   `yaml:"snapshot" json:"snapshot"`
+	SnapshotInterval Duration `yaml:"snapshot_interval" json:"snapshot_interval"`
+}
+
+// Memory tells whether the memory store is used.
+func (s Store) Memory() bool {
+	return s.Kind == StoreMemory
+}
+
+// Postgres is the database configuration.
+type Postgres struct {
+	Host     string `yaml:"host" json:"host"`
+	Port     int    `yaml:"port" json:"port"`
+	User     string `yaml:"user" json:"user"`
+	Password string `yaml:"password" json:"password"`
+	DBName   string `yaml:"dbname" json:"dbname"`
+
+	// HitFlushInterval is how often the memo hit counts are written.
+	HitFlushInterval Duration `yaml:"hit_flush_interval" json:"hit_flush_interval"`
+}
+
+// The trace exporters, those of the tracing package.
+const (
+	ExporterNone     = "none"
+	ExporterStdout   = "stdout"
+	ExporterOTLPFile = "otlp-file"
+)
+
+// Tracing is the OpenTelemetry configuration.
+type Tracing struct {
+	Exporter string `yaml:"exporter" json:"exporter"`
+	File     string `yaml:"file" json:"file"`
+}
+
+// MaxFibN is the largest n whose fibonacci number fits in 64 bits.  It is
+// the same as service.MaxFibN, which the tests check, as this package
+// doesn't depend on the service.
+const MaxFibN = 93
+
+// Limits bound the work of a single computation.  Zero values mean no
+// limit.
+type Limits struct {
+	MaxN      int `yaml:"max_n" json:"max_n"`
+	MaxWrites int `yaml:"max_writes" json:"max_writes"`
+
+	// MaxCPUTime is the wall-clock time a request may spend computing,
+	// including waiting on the store, despite its name.
+	MaxCPUTime Duration `yaml:"max_cpu_time" json:"max_cpu_time"`
+
+	// OpCost is the expected time of a store operation, to estimate
+	// whether a request fits in MaxCPUTime.
+	OpCost Duration `yaml:"op_cost" json:"op_cost"`
+}
+
+// Jobs is the configuration of the background jobs, which are off if
+// there are no workers.
+type Jobs struct {
+	Workers     int      `yaml:"workers" json:"workers"`
+	QueueSize   int      `yaml:"queue_size" json:"queue_size"`
+	Retention   Duration `yaml:"retention" json:"retention"`
+	MaxRetained int      `yaml:"max_retained" json:"max_retained"`
+
+	// Timeout is the time a job may spend computing, no limit if 0.
+	Timeout Duration `yaml:"timeout" json:"timeout"`
+}
+
+// Enabled tells whether jobs are run.
+func (j Jobs) Enabled() bool {
+	return j.Workers > 0
+}
+
+// Queue is the configuration of the workers of the durable job queue,
+// which run in worker mode.  The jobs have the timeout of Jobs.
+type Queue struct {
+	Workers      int      `yaml:"workers" json:"workers"`
+	Lease        Duration `yaml:"lease" json:"lease"`
+	PollInterval Duration `yaml:"poll_interval" json:"poll_interval"`
+	Backoff      Duration `yaml:"backoff" json:"backoff"`
+}
+
+// Auth is the authentication configuration.  Authentication is enabled
+// if any API keys, a JWT secret or client certificate roles are set.
+type Auth struct {
+	// APIKeys are only configured in the file, as a list.
+	APIKeys []APIKey `yaml:"api_keys" json:"api_keys"`
+
+	JWTSecret   string `yaml:"jwt_secret" json:"jwt_secret"`
+	JWTIssuer   string `yaml:"jwt_issuer" json:"jwt_issuer"`
+	JWTAudience string `yaml:"jwt_audience" json:"jwt_audience"`
+
+	// ClientCertRoles maps client certificate common names to roles.
+	ClientCertRoles map[string][]string `yaml:"client_cert_roles" json:"client_cert_roles"`
+}
+
+// APIKey is a static API key, given by the hex SHA-256 hash of the key.
+type APIKey struct {
+	Name  string   `yaml:"name" json:"name"`
+	Hash  string   `yaml:"hash" json:"hash"`
+	Roles []string `yaml:"roles" json:"roles"`
+}
+
+// Enabled tells whether requests must be authenticated.
+func (a Auth) Enabled() bool {
+	return len(a.APIKeys) != 0 || a.JWTSecret != "" || len(a.ClientCertRoles) != 0
+}
+
+// Default returns the configuration before any sources are applied.
+func Default() Config {
+	return Config{
+		LogLevel: "production",
+		Server: Server{
+			Port:            8080,
+			ReadTimeout:     Duration(30 * time.Second),
+			WriteTimeout:    Duration(30 * time.Second),
+			IdleTimeout:     Duration(2 * time.Minute),
+			ShutdownTimeout: Duration(10 * time.Second),
+			Drain:           Duration(5 * time.Second),
+			TLS: TLS{
+				MinVersion:     "1.2",
+				CipherPolicy:   tlsconf.PolicyIntermediate,
+				ClientAuth:     tlsconf.ClientAuthNone,
+				ReloadInterval: Duration(tlsconf.DefaultReloadInterval),
+			},
+			RateLimit: RateLimit{
+				CachedBurst: 100,
+				ColdBurst:   10,
+			},
+		},
+		Store: Store{
+			Kind:             StorePostgres,
+			SnapshotInterval: Duration(time.Minute),
+		},
+		Postgres: Postgres{
+			Host:             "localhost",
+			Port:             5432,
+			HitFlushInterval: Duration(5 * time.Second),
+		},
+		// The memo values are stored as signed 64-bit integers in
+		// Postgres, where fib(93) does not fit.
+		Limits: Limits{
+			MaxN:   MaxFibN - 1,
+			OpCost: Duration(time.Millisecond),
+		},
+		Jobs: Jobs{
+			Workers:     4,
+			QueueSize:   100,
+			Retention:   Duration(time.Hour),
+			MaxRetained: 1000,
+			Timeout:     Duration(10 * time.Minute),
+		},
+		Queue: Queue{
+			Workers:      4,
+			Lease:        Duration(time.Minute),
+			PollInterval: Duration(time.Second),
+			Backoff:      Duration(5 * time.Second),
+		},
+		Tracing: Tracing{
+			Exporter: ExporterNone,
+			File:     "traces.jsonl",
+		},
+	}
+}
+
+// Validate checks the configuration is complete and consistent.  All the
+// problems found are reported together.  The API keys and roles are
+// checked when the authenticator is created.
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.LogLevel == "production" || c.LogLevel == "development",
+		"log level must be 'production' or 'development', not %q", c.LogLevel)
+	check(c.Server.Port > 0 && c.Server.Port < 65536,
+		"invalid server port: %d", c.Server.Port)
+	check(c.Server.GRPCPort >= 0 && c.Server.GRPCPort < 65536,
+		"invalid gRPC port: %d", c.Server.GRPCPort)
+	check(c.Server.GRPCPort == 0 || c.Server.GRPCPort != c.Server.Port,
+		"the gRPC and HTTP ports must differ")
+	check(c.Server.ReadTimeout > 0, "server read timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server write timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server idle timeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server shutdown timeout must be positive")
+	check(c.Server.Drain >= 0, "server drain time must not be negative")
+	if c.Server.TLS.Enabled() || c.Server.TLS.KeyFile != "" {
+		if err := c.Server.TLS.Settings().Validate(); err != nil {
+			check(false, "%v", err)
+		}
+	}
+
+	rl := c.Server.RateLimit
+	check(rl.CachedRate >= 0 && rl.ColdRate >= 0, "rate limits must not be negative")
+	check(rl.CachedRate == 0 || rl.CachedBurst > 0, "the cached rate limit requires a positive burst")
+	check(rl.ColdRate == 0 || rl.ColdBurst > 0, "the cold rate limit requires a positive burst")
+	check(rl.DailyQuota >= 0, "the daily quota must not be negative")
+
+	check(c.Limits.MaxN >= 0 && c.Limits.MaxN <= MaxFibN,
+		"the maximum n must be between 0 and %d", MaxFibN)
+	check(c.Limits.MaxWrites >= 0, "the maximum writes must not be negative")
+	check(c.Limits.MaxCPUTime >= 0 && c.Limits.OpCost >= 0, "the time limits must not be negative")
+
+	if c.Jobs.Enabled() {
+		check(c.Jobs.QueueSize > 0, "the job queue size must be positive")
+		check(c.Jobs.Retention > 0 && c.Jobs.MaxRetained > 0, "the job retention limits must be positive")
+		check(c.Jobs.Timeout >= 0, "the job timeout must not be negative")
+	}
+	check(c.Jobs.Workers >= 0, "the job workers must not be negative")
+	check(c.Queue.Workers > 0, "the queue workers must be positive")
+	check(c.Queue.Lease > 0 && c.Queue.PollInterval > 0 && c.Queue.Backoff > 0,
+		"the queue lease, poll interval and backoff must be positive")
+
+	// The database settings are only needed if it is used.
+	switch c.Store.Kind {
+	case StorePostgres:
+		check(c.Store.Seed == "" && c.Store.Snapshot == "",
+			"only the memory store may be seeded or have snapshots")
+		check(c.Postgres.Host != "", "postgres host must be set")
+		check(c.Postgres.Port > 0 && c.Postgres.Port < 65536,
+			"invalid postgres port: %d", c.Postgres.Port)
+		check(c.Postgres.User != "", "postgres user must be set")
+		check(c.Postgres.Password != "", "postgres password must be set")
+		check(c.Postgres.DBName != "", "postgres database name must be set")
+		check(c.Postgres.HitFlushInterval > 0, "postgres hit flush interval must be positive")
+	case StoreMemory:
+		check(c.Store.SnapshotInterval >= 0, "the snapshot interval must not be negative")
+	default:
+		check(false, "store must be '%s' or '%s', not %q", StorePostgres, StoreMemory, c.Store.Kind)
+	}
+
+	switch c.Tracing.Exporter {
+	case ExporterNone, ExporterStdout:
+	case ExporterOTLPFile:
+		check(c.Tracing.File != "", "the %s trace exporter requires a file", c.Tracing.Exporter)
+	default:
+		check(false, "unknown trace exporter: %q", c.Tracing.Exporter)
+	}
+
+	if len(errs) != 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Duration is a time.Duration written as a string such as "30s" in
+// configuration files.  A plain integer is taken as seconds, as the
+// server's flags used to be.
+type Duration time.Duration
+
+// String implements fmt.Stringer.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	return d.parse(s)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) parse(s string) error {
+	if secs, err := strconv.Atoi(s); err == nil {
+		*d = Duration(time.Duration(secs) * time.Second)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/service"
+)
+
+// Returns a lookup function over a fixed environment.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Tests each source overrides the ones before it.
+func TestPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "fibsrv.yaml", `
+log_level: development
+server:
+  port: 9000
+  read_timeout: 5s
+postgres:
+  host: filehost
+  user: fileuser
+  password: filepass
+  dbname: filedb
+`)
+	cfg, err := Load([]string{"-config", yamlFile, "-postgres-host", "flaghost"},
+		env(map[string]string{
+			"POSTGRES_USER":        "aliasuser",
+			"POSTGRES_DB":          "aliasdb",
+			"FIBSRV_POSTGRES_HOST": "envhost",
+			"FIBSRV_POSTGRES_DB":   "envdb",
+			"FIBSRV_PORT":          "9001",
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []struct {
+		got, expected interface{}
+	}{
+		{cfg.LogLevel, "development"},                              // file
+		{cfg.Server.Port, 9001},                                    // env over file
+		{time.Duration(cfg.Server.ReadTimeout), 5 * time.Second},   // file
+		{time.Duration(cfg.Server.WriteTimeout), 30 * time.Second}, // default
+		{cfg.Postgres.Host, "flaghost"},                            // flag over env
+		{cfg.Postgres.User, "aliasuser"},                           // alias over file
+		{cfg.Postgres.DBName, "envdb"},                             // prefixed over alias
+		{cfg.Postgres.Password, "filepass"},                        // file
+		{cfg.Postgres.Port, 5432},                                  // default
+	} {
+		if v.got != v.expected {
+			t.Errorf("%d: expected %v, got %v", i, v.expected, v.got)
+		}
+	}
+}
+
+// Tests a JSON file and a secret read from a file.
+func TestJSONAndSecretFile(t *testing.T) {
+	jsonFile := writeFile(t, "fibsrv.json", `{
+		"server": {"drain": "1s", "legacy_clear": true},
+		"postgres": {"user": "u", "dbname": "d"}
+	}`)
+	secret := writeFile(t, "password", "s3cret\n")
+	cfg, err := Load([]string{"-timeout", "12"}, env(map[string]string{
+		"FIBSRV_CONFIG":          jsonFile,
+		"POSTGRES_PASSWORD_FILE": secret,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Postgres.Password != "s3cret" {
+		t.Fatalf("expected password from file, got %q", cfg.Postgres.Password)
+	}
+	if !cfg.Server.LegacyClear || time.Duration(cfg.Server.Drain) != time.Second {
+		t.Fatalf("unexpected server config: %+v", cfg.Server)
+	}
+	if time.Duration(cfg.Server.ReadTimeout) != 12*time.Second ||
+		time.Duration(cfg.Server.WriteTimeout) != 12*time.Second {
+		t.Fatalf("expected -timeout to set both timeouts: %+v", cfg.Server)
+	}
+}
+
+// Tests the configuration errors are reported.
+func TestInvalid(t *testing.T) {
+	for i, v := range []struct {
+		args []string
+		env  map[string]string
+		err  string
+	}{
+		{
+			env: map[string]string{},
+			err: "postgres user must be set",
+		},
+		{
+			args: []string{"-port", "0", "-trace-exporter", "jaeger"},
+			env:  map[string]string{"POSTGRES_USER": "u", "POSTGRES_PASSWORD": "p", "POSTGRES_DB": "d"},
+			err:  "invalid server port: 0; unknown trace exporter",
+		},
+		{
+			env: map[string]string{"POSTGRES_PASSWORD": "p", "POSTGRES_PASSWORD_FILE": "/p"},
+			err: "both POSTGRES_PASSWORD and POSTGRES_PASSWORD_FILE are set",
+		},
+		{
+			args: []string{"-config", writeFile(t, "bad.yaml", "serverr:\n  port: 1\n")},
+			env:  map[string]string{},
+			err:  "field serverr not found",
+		},
+		{
+			args: []string{"-rate-limit-cold", "0.5", "-rate-limit-cold-burst", "0"},
+			env:  map[string]string{"POSTGRES_USER": "u", "POSTGRES_PASSWORD": "p", "POSTGRES_DB": "d"},
+			err:  "the cold rate limit requires a positive burst",
+		},
+		{
+			args: []string{"-store", "disk"},
+			env:  map[string]string{},
+			err:  "store must be 'postgres' or 'memory', not \"disk\"",
+		},
+		{
+			args: []string{"-store-seed", "memos.jsonl"},
+			env:  map[string]string{"POSTGRES_USER": "u", "POSTGRES_PASSWORD": "p", "POSTGRES_DB": "d"},
+			err:  "only the memory store may be seeded or have snapshots",
+		},
+		{
+			args: []string{"-store", "memory", "-store-snapshot-interval", "-1s"},
+			env:  map[string]string{},
+			err:  "the snapshot interval must not be negative",
+		},
+		{
+			args: []string{"-job-queue-size", "0"},
+			env:  map[string]string{"POSTGRES_USER": "u", "POSTGRES_PASSWORD": "p", "POSTGRES_DB": "d"},
+			err:  "the job queue size must be positive",
+		},
+	} {
+		_, err := Load(v.args, env(v.env))
+		if err == nil || !strings.Contains(err.Error(), v.err) {
+			t.Fatalf("%d: expected error containing %q, got %v", i, v.err, err)
+		}
+	}
+}
+
+// The memory store needs none of the database settings.
+func TestMemoryStore(t *testing.T) {
+	cfg, err := Load([]string{"-store", "memory", "-store-seed", "memos.jsonl"}, env(map[string]string{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Store.Memory() || cfg.Store.Seed != "memos.jsonl" {
+		t.Fatalf("unexpected store: %+v", cfg.Store)
+	}
+	cfg, err = Load(nil, env(map[string]string{"FIBSRV_STORE": "memory", "FIBSRV_STORE_SNAPSHOT": "memos.snap"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Store.Snapshot != "memos.snap" || cfg.Store.SnapshotInterval != Duration(time.Minute) {
+		t.Fatalf("unexpected snapshots: %+v", cfg.Store)
+	}
+}
+
+// The config package doesn't depend on the service, so it has a copy of
+// the maximum n, which must not drift from the service's.
+func TestMaxFibN(t *testing.T) {
+	if MaxFibN != service.MaxFibN {
+		t.Fatalf("MaxFibN is %d, but the service's is %d", MaxFibN, service.MaxFibN)
+	}
+}
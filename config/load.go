@@ -0,0 +1,400 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables for the settings.
+const EnvPrefix = "FIBSRV_"
+
+// A setting that may come from the environment or a flag.  The file layer
+// is handled by decoding into the Config struct.
+type setting struct {
+	flag  string // flag name
+	env   string // environment variable, without the prefix; none if empty
+	usage string
+
+	// aliases are unprefixed environment variables, taking precedence
+	// under the prefixed one, such as those shared with the postgres image.
+	aliases []string
+
+	// secret settings may also be read from a file.
+	secret bool
+
+	// field returns a pointer to the setting's field(s) in the config.
+	field func(c *Config) []interface{}
+}
+
+var settings = []setting{
+	{flag: "log", env: "LOG_LEVEL", usage: "log level: 'production', 'development'",
+		field: func(c *Config) []interface{} { return fields(&c.LogLevel) }},
+	{flag: "instance-id", env: "INSTANCE_ID", usage: "instance ID recorded on memos (default host name)",
+		field: func(c *Config) []interface{} { return fields(&c.InstanceID) }},
+
+	{flag: "port", env: "PORT", usage: "HTTP port number",
+		field: func(c *Config) []interface{} { return fields(&c.Server.Port) }},
+	{flag: "grpc-port", env: "GRPC_PORT", usage: "gRPC port number, no gRPC server if 0",
+		field: func(c *Config) []interface{} { return fields(&c.Server.GRPCPort) }},
+	{flag: "timeout", usage: "server read and write timeout (deprecated)",
+		field: func(c *Config) []interface{} {
+			return fields(&c.Server.ReadTimeout, &c.Server.WriteTimeout)
+		}},
+	{flag: "read-timeout", env: "READ_TIMEOUT", usage: "server read timeout",
+		field: func(c *Config) []interface{} { return fields(&c.Server.ReadTimeout) }},
+	{flag: "write-timeout", env: "WRITE_TIMEOUT", usage: "server write timeout",
+		field: func(c *Config) []interface{} { return fields(&c.Server.WriteTimeout) }},
+	{flag: "idle-timeout", env: "IDLE_TIMEOUT", usage: "server keep-alive idle timeout",
+		field: func(c *Config) []interface{} { return fields(&c.Server.IdleTimeout) }},
+	{flag: "shutdown-timeout", env: "SHUTDOWN_TIMEOUT", usage: "time allowed for requests to complete at shutdown",
+		field: func(c *Config) []interface{} { return fields(&c.Server.ShutdownTimeout) }},
+	{flag: "drain", env: "DRAIN", usage: "time between failing readiness and stopping the server at shutdown",
+		field: func(c *Config) []interface{} { return fields(&c.Server.Drain) }},
+	{flag: "legacy-clear", env: "LEGACY_CLEAR", usage: "enable the deprecated GET /v1/clear endpoint",
+		field: func(c *Config) []interface{} { return fields(&c.Server.LegacyClear) }},
+
+	{flag: "tls-cert", env: "TLS_CERT", usage: "TLS certificate file, enables TLS",
+		field: func(c *Config) []interface{} { return fields(&c.Server.TLS.CertFile) }},
+	{flag: "tls-key", env: "TLS_KEY", usage: "TLS private key file",
+		field: func(c *Config) []interface{} { return fields(&c.Server.TLS.KeyFile) }},
+	{flag: "tls-min-version", env: "TLS_MIN_VERSION", usage: "minimum TLS version: '1.2', '1.3'",
+		field: func(c *Config) []interface{} { return fields(&c.Server.TLS.MinVersion) }},
+	{flag: "tls-cipher-policy", env: "TLS_CIPHER_POLICY", usage: "TLS cipher policy: 'intermediate', 'modern', 'go'",
+		field: func(c *Config) []interface{} { return fields(&c.Server.TLS.CipherPolicy) }},
+	{flag: "tls-client-ca", env: "TLS_CLIENT_CA", usage: "CA bundle to verify client certificates",
+		field: func(c *Config) []interface{} { return fields(&c.Server.TLS.ClientCAFile) }},
+	{flag: "tls-client-auth", env: "TLS_CLIENT_AUTH",
+		usage: "client certificates: 'none', 'request', 'verify-if-given', 'require'",
+		field: func(c *Config) []interface{} { return fields(&c.Server.TLS.ClientAuth) }},
+	{flag: "tls-reload-interval", env: "TLS_RELOAD_INTERVAL", usage: "how often the TLS files are checked for changes",
+		field: func(c *Config) []interface{} { return fields(&c.Server.TLS.ReloadInterval) }},
+
+	{flag: "rate-limit-cached", env: "RATE_LIMIT_CACHED", usage: "requests per second per client answered from memos",
+		field: func(c *Config) []interface{} { return fields(&c.Server.RateLimit.CachedRate) }},
+	{flag: "rate-limit-cached-burst", env: "RATE_LIMIT_CACHED_BURST", usage: "burst of requests answered from memos",
+		field: func(c *Config) []interface{} { return fields(&c.Server.RateLimit.CachedBurst) }},
+	{flag: "rate-limit-cold", env: "RATE_LIMIT_COLD", usage: "requests per second per client that compute",
+		field: func(c *Config) []interface{} { return fields(&c.Server.RateLimit.ColdRate) }},
+	{flag: "rate-limit-cold-burst", env: "RATE_LIMIT_COLD_BURST", usage: "burst of requests that compute",
+		field: func(c *Config) []interface{} { return fields(&c.Server.RateLimit.ColdBurst) }},
+	{flag: "daily-quota", env: "DAILY_QUOTA", usage: "requests per client per day",
+		field: func(c *Config) []interface{} { return fields(&c.Server.RateLimit.DailyQuota) }},
+
+	{flag: "max-n", env: "MAX_N", usage: "largest n that may be computed",
+		field: func(c *Config) []interface{} { return fields(&c.Limits.MaxN) }},
+	{flag: "max-writes", env: "MAX_WRITES", usage: "memos a request may store, no limit if 0",
+		field: func(c *Config) []interface{} { return fields(&c.Limits.MaxWrites) }},
+	{flag: "max-cpu-time", env: "MAX_CPU_TIME", usage: "wall-clock time a request may spend computing, no limit if 0",
+		field: func(c *Config) []interface{} { return fields(&c.Limits.MaxCPUTime) }},
+	{flag: "op-cost", env: "OP_COST", usage: "expected time of a store operation, to estimate request times",
+		field: func(c *Config) []interface{} { return fields(&c.Limits.OpCost) }},
+
+	{flag: "job-workers", env: "JOB_WORKERS", usage: "jobs run at once, no jobs api if 0",
+		field: func(c *Config) []interface{} { return fields(&c.Jobs.Workers) }},
+	{flag: "job-queue-size", env: "JOB_QUEUE_SIZE", usage: "jobs that may wait for a worker",
+		field: func(c *Config) []interface{} { return fields(&c.Jobs.QueueSize) }},
+	{flag: "job-retention", env: "JOB_RETENTION", usage: "how long finished jobs are kept",
+		field: func(c *Config) []interface{} { return fields(&c.Jobs.Retention) }},
+	{flag: "job-max-retained", env: "JOB_MAX_RETAINED", usage: "finished jobs kept",
+		field: func(c *Config) []interface{} { return fields(&c.Jobs.MaxRetained) }},
+	{flag: "job-timeout", env: "JOB_TIMEOUT", usage: "time a job may spend computing, no limit if 0",
+		field: func(c *Config) []interface{} { return fields(&c.Jobs.Timeout) }},
+
+	{flag: "queue-workers", env: "QUEUE_WORKERS", usage: "queued jobs run at once in worker mode",
+		field: func(c *Config) []interface{} { return fields(&c.Queue.Workers) }},
+	{flag: "queue-lease", env: "QUEUE_LEASE", usage: "how long a worker holds a queued job without renewing it",
+		field: func(c *Config) []interface{} { return fields(&c.Queue.Lease) }},
+	{flag: "queue-poll-interval", env: "QUEUE_POLL_INTERVAL", usage: "how often an idle worker looks for a job",
+		field: func(c *Config) []interface{} { return fields(&c.Queue.PollInterval) }},
+	{flag: "queue-backoff", env: "QUEUE_BACKOFF", usage: "delay before the first retry of a failed job",
+		field: func(c *Config) []interface{} { return fields(&c.Queue.Backoff) }},
+
+	{flag: "store", env: "STORE", usage: "memo store: 'postgres', 'memory'",
+		field: func(c *Config) []interface{} { return fields(&c.Store.Kind) }},
+	{flag: "store-seed", env: "STORE_SEED", usage: "export file loaded into the memory store",
+		field: func(c *Config) []interface{} { return fields(&c.Store.Seed) }},
+	{flag: "store-snapshot", env: "STORE_SNAPSHOT", usage: "file the memory store is loaded from and persisted to",
+		field: func(c *Config) []interface{} { return fields(&c.Store.Snapshot) }},
+	{flag: "store-snapshot-interval", env: "STORE_SNAPSHOT_INTERVAL",
+		usage: "how often the memory store is persisted, only at shutdown if 0",
+		field: func(c *Config) []interface{} { return fields(&c.Store.SnapshotInterval) }},
+
+	{flag: "postgres-host", env: "POSTGRES_HOST", usage: "postgres host",
+		field: func(c *Config) []interface{} { return fields(&c.Postgres.Host) }},
+	{flag: "postgres-port", env: "POSTGRES_PORT", usage: "postgres port",
+		field: func(c *Config) []interface{} { return fields(&c.Postgres.Port) }},
+	{flag: "postgres-user", env: "POSTGRES_USER", aliases: []string{"POSTGRES_USER"},
+		usage: "postgres user",
+		field: func(c *Config) []interface{} { return fields(&c.Postgres.User) }},
+	{flag: "postgres-password", env: "POSTGRES_PASSWORD", aliases: []string{"POSTGRES_PASSWORD"},
+		usage: "postgres password", secret: true,
+		field: func(c *Config) []interface{} { return fields(&c.Postgres.Password) }},
+	{flag: "postgres-db", env: "POSTGRES_DB", aliases: []string{"POSTGRES_DB"},
+		usage: "postgres database name",
+		field: func(c *Config) []interface{} { return fields(&c.Postgres.DBName) }},
+	{flag: "hit-flush-interval", env: "HIT_FLUSH_INTERVAL", usage: "how often memo hit counts are written",
+		field: func(c *Config) []interface{} { return fields(&c.Postgres.HitFlushInterval) }},
+
+	{flag: "trace-exporter", env: "TRACE_EXPORTER", usage: "trace exporter: 'none', 'stdout', 'otlp-file'",
+		field: func(c *Config) []interface{} { return fields(&c.Tracing.Exporter) }},
+	{flag: "trace-file", env: "TRACE_FILE", usage: "output file for the otlp-file trace exporter",
+		field: func(c *Config) []interface{} { return fields(&c.Tracing.File) }},
+
+	{flag: "jwt-secret", env: "JWT_SECRET", usage: "HMAC key to verify JWTs, enables authentication",
+		secret: true,
+		field:  func(c *Config) []interface{} { return fields(&c.Auth.JWTSecret) }},
+	{flag: "jwt-issuer", env: "JWT_ISSUER", usage: "required JWT issuer",
+		field: func(c *Config) []interface{} { return fields(&c.Auth.JWTIssuer) }},
+	{flag: "jwt-audience", env: "JWT_AUDIENCE", usage: "required JWT audience",
+		field: func(c *Config) []interface{} { return fields(&c.Auth.JWTAudience) }},
+}
+
+func fields(f ...interface{}) []interface{} {
+	return f
+}
+
+// Loader binds the configuration flags to a flag set, so they can be
+// parsed along with any other flags, and then loads the configuration.
+type Loader struct {
+	configPath string
+	flags      []*flagValue
+}
+
+// A flag's value is kept as a string until it is applied over the
+// other sources.
+type flagValue struct {
+	s       *setting
+	isFile  bool
+	val     string
+	present bool
+}
+
+func (fv *flagValue) String() string {
+	return fv.val
+}
+
+func (fv *flagValue) Set(v string) error {
+	fv.val, fv.present = v, true
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given without a value.
+func (fv *flagValue) IsBoolFlag() bool {
+	var c Config
+	_, ok := fv.s.field(&c)[0].(*bool)
+	return ok && !fv.isFile
+}
+
+// NewLoader registers the configuration flags with the flag set.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{}
+	def := Default()
+	fs.StringVar(&l.configPath, "config", "", "YAML or JSON configuration file")
+	for i := range settings {
+		s := &settings[i]
+		fv := &flagValue{s: s}
+		fs.Var(fv, s.flag, s.usage+defaultUsage(s, &def))
+		l.flags = append(l.flags, fv)
+		if s.secret {
+			fv := &flagValue{s: s, isFile: true}
+			fs.Var(fv, s.flag+"-file", "file containing the "+s.usage)
+			l.flags = append(l.flags, fv)
+		}
+	}
+	return l
+}
+
+func defaultUsage(s *setting, def *Config) string {
+	v := fmt.Sprint(reflectValue(s.field(def)[0]))
+	if v == "" || v == "0" || v == "false" || s.secret {
+		return ""
+	}
+	return fmt.Sprintf(" (default %s)", v)
+}
+
+// Load loads the configuration from the defaults, the file, the
+// environment and the parsed flags, and validates it.
+func (l *Loader) Load(lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	path := l.configPath
+	if path == "" {
+		path, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range settings {
+		s := &settings[i]
+		for _, name := range s.aliases {
+			if err := applyEnv(&cfg, s, name, lookupEnv); err != nil {
+				return nil, err
+			}
+		}
+		if s.env != "" {
+			if err := applyEnv(&cfg, s, EnvPrefix+s.env, lookupEnv); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, fv := range l.flags {
+		if !fv.present {
+			continue
+		}
+		val := fv.val
+		if fv.isFile {
+			var err error
+			if val, err = readSecret(val); err != nil {
+				return nil, err
+			}
+		}
+		if err := apply(&cfg, fv.s, val); err != nil {
+			return nil, fmt.Errorf("flag -%s: %v", fv.s.flag, err)
+		}
+	}
+
+	if cfg.InstanceID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		cfg.InstanceID = host
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Load is a convenience function to parse the configuration flags from
+// the arguments and load the configuration.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	fs := flag.NewFlagSet("fibsrv", flag.ContinueOnError)
+	l := NewLoader(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return l.Load(lookupEnv)
+}
+
+// Decodes the file according to its extension, rejecting unknown keys.
+func loadFile(path string, cfg *Config) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, cfg)
+	default:
+		return fmt.Errorf("config file %s: unknown format, must be .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Applies a variable, or for a secret its _FILE variant, if set.
+func applyEnv(cfg *Config, s *setting, name string, lookupEnv func(string) (string, bool)) error {
+	val, ok := lookupEnv(name)
+	if s.secret {
+		file, fok := lookupEnv(name + "_FILE")
+		if ok && fok {
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+		if fok {
+			var err error
+			if val, err = readSecret(file); err != nil {
+				return err
+			}
+			ok = true
+		}
+	}
+	if !ok {
+		return nil
+	}
+	if err := apply(cfg, s, val); err != nil {
+		return fmt.Errorf("environment variable %s: %v", name, err)
+	}
+	return nil
+}
+
+// Reads a secret from a file, ignoring any trailing newline.
+func readSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Parses a value into the setting's field(s).
+func apply(cfg *Config, s *setting, val string) error {
+	for _, f := range s.field(cfg) {
+		switch p := f.(type) {
+		case *string:
+			*p = val
+		case *int:
+			v, err := strconv.Atoi(val)
+			if err != nil {
+				return err
+			}
+			*p = v
+		case *float64:
+			v, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return err
+			}
+			*p = v
+		case *bool:
+			v, err := strconv.ParseBool(val)
+			if err != nil {
+				return err
+			}
+			*p = v
+		case *Duration:
+			if err := p.parse(val); err != nil {
+				return err
+			}
+		default:
+			panic(fmt.Sprintf("unsupported setting type %T", f))
+		}
+	}
+	return nil
+}
+
+// Dereferences a setting's field for printing.
+func reflectValue(f interface{}) interface{} {
+	switch p := f.(type) {
+	case *string:
+		return *p
+	case *int:
+		return *p
+	case *float64:
+		return *p
+	case *bool:
+		return *p
+	case *Duration:
+		return time.Duration(*p)
+	}
+	return nil
+}
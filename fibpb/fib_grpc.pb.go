@@ -0,0 +1,247 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package fibpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// FibClient is the client API for Fib service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FibClient interface {
+	// Fib returns fib(n).
+	Fib(ctx context.Context, in *FibRequest, opts ...grpc.CallOption) (*FibResponse, error)
+	// FibLess returns the number of memos whose value is less than the
+	// target.
+	FibLess(ctx context.Context, in *FibLessRequest, opts ...grpc.CallOption) (*FibLessResponse, error)
+	// Clear removes the memos in a range.
+	Clear(ctx context.Context, in *ClearRequest, opts ...grpc.CallOption) (*ClearResponse, error)
+	// FibRange streams fib(n) for each n in the inclusive range.
+	FibRange(ctx context.Context, in *FibRangeRequest, opts ...grpc.CallOption) (Fib_FibRangeClient, error)
+}
+
+type fibClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFibClient(cc grpc.ClientConnInterface) FibClient {
+	return &fibClient{cc}
+}
+
+func (c *fibClient) Fib(ctx context.Context, in *FibRequest, opts ...grpc.CallOption) (*FibResponse, error) {
+	out := new(FibResponse)
+	err := c.cc.Invoke(ctx, "/fibsrv.v1.Fib/Fib", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fibClient) FibLess(ctx context.Context, in *FibLessRequest, opts ...grpc.CallOption) (*FibLessResponse, error) {
+	out := new(FibLessResponse)
+	err := c.cc.Invoke(ctx, "/fibsrv.v1.Fib/FibLess", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fibClient) Clear(ctx context.Context, in *ClearRequest, opts ...grpc.CallOption) (*ClearResponse, error) {
+	out := new(ClearResponse)
+	err := c.cc.Invoke(ctx, "/fibsrv.v1.Fib/Clear", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fibClient) FibRange(ctx context.Context, in *FibRangeRequest, opts ...grpc.CallOption) (Fib_FibRangeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Fib_ServiceDesc.Streams[0], "/fibsrv.v1.Fib/FibRange", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fibFibRangeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Fib_FibRangeClient interface {
+	Recv() (*FibResponse, error)
+	grpc.ClientStream
+}
+
+type fibFibRangeClient struct {
+	grpc.ClientStream
+}
+
+func (x *fibFibRangeClient) Recv() (*FibResponse, error) {
+	m := new(FibResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FibServer is the server API for Fib service.
+// All implementations must embed UnimplementedFibServer
+// for forward compatibility
+type FibServer interface {
+	// Fib returns fib(n).
+	Fib(context.Context, *FibRequest) (*FibResponse, error)
+	// FibLess returns the number of memos whose value is less than the
+	// target.
+	FibLess(context.Context, *FibLessRequest) (*FibLessResponse, error)
+	// Clear removes the memos in a range.
+	Clear(context.Context, *ClearRequest) (*ClearResponse, error)
+	// FibRange streams fib(n) for each n in the inclusive range.
+	FibRange(*FibRangeRequest, Fib_FibRangeServer) error
+	mustEmbedUnimplementedFibServer()
+}
+
+// UnimplementedFibServer must be embedded to have forward compatible implementations.
+type UnimplementedFibServer struct {
+}
+
+func (UnimplementedFibServer) Fib(context.Context, *FibRequest) (*FibResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Fib not implemented")
+}
+func (UnimplementedFibServer) FibLess(context.Context, *FibLessRequest) (*FibLessResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FibLess not implemented")
+}
+func (UnimplementedFibServer) Clear(context.Context, *ClearRequest) (*ClearResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Clear not implemented")
+}
+func (UnimplementedFibServer) FibRange(*FibRangeRequest, Fib_FibRangeServer) error {
+	return status.Errorf(codes.Unimplemented, "method FibRange not implemented")
+}
+func (UnimplementedFibServer) mustEmbedUnimplementedFibServer() {}
+
+// UnsafeFibServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FibServer will
+// result in compilation errors.
+type UnsafeFibServer interface {
+	mustEmbedUnimplementedFibServer()
+}
+
+func RegisterFibServer(s grpc.ServiceRegistrar, srv FibServer) {
+	s.RegisterService(&Fib_ServiceDesc, srv)
+}
+
+func _Fib_Fib_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FibRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FibServer).Fib(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fibsrv.v1.Fib/Fib",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FibServer).Fib(ctx, req.(*FibRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fib_FibLess_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FibLessRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FibServer).FibLess(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fibsrv.v1.Fib/FibLess",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FibServer).FibLess(ctx, req.(*FibLessRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fib_Clear_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FibServer).Clear(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fibsrv.v1.Fib/Clear",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FibServer).Clear(ctx, req.(*ClearRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fib_FibRange_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FibRangeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FibServer).FibRange(m, &fibFibRangeServer{stream})
+}
+
+type Fib_FibRangeServer interface {
+	Send(*FibResponse) error
+	grpc.ServerStream
+}
+
+type fibFibRangeServer struct {
+	grpc.ServerStream
+}
+
+func (x *fibFibRangeServer) Send(m *FibResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Fib_ServiceDesc is the grpc.ServiceDesc for Fib service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Fib_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fibsrv.v1.Fib",
+	HandlerType: (*FibServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Fib",
+			Handler:    _Fib_Fib_Handler,
+		},
+		{
+			MethodName: "FibLess",
+			Handler:    _Fib_FibLess_Handler,
+		},
+		{
+			MethodName: "Clear",
+			Handler:    _Fib_Clear_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "FibRange",
+			Handler:       _Fib_FibRange_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "fib.proto",
+}
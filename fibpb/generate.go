@@ -0,0 +1,5 @@
+// Package fibpb is the protobuf and gRPC code for the fibonacci service,
+// generated from fib.proto.
+package fibpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fib.proto
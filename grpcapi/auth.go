@@ -0,0 +1,89 @@
+package grpcapi
+
+import (
+	"context"
+
+	"github.com/gdotgordon/fibsrv/api"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// The roles required by the Fib methods, as for the HTTP api.  The health
+// and reflection services are not authenticated.
+var methodRoles = map[string]string{
+	"/" + ServiceName + "/Fib":      api.RoleReader,
+	"/" + ServiceName + "/FibLess":  api.RoleReader,
+	"/" + ServiceName + "/FibRange": api.RoleReader,
+	"/" + ServiceName + "/Clear":    api.RoleAdmin,
+}
+
+// Checks the credentials and roles of the callers.
+type authorizer struct {
+	auth *api.Authenticator
+	log  *zap.SugaredLogger
+}
+
+func (a authorizer) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a authorizer) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	if err := a.authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// Returns an Unauthenticated status for missing or invalid credentials, and
+// PermissionDenied for a lack of the role.
+func (a authorizer) authorize(ctx context.Context, method string) error {
+	role, ok := methodRoles[method]
+	if !ok {
+		return nil
+	}
+	p, err := a.authenticate(ctx)
+	if err == api.ErrNoCredentials {
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !p.Has(role) {
+		a.log.Infow("Forbidden", "principal", p.Name, "method", method, "role", role)
+		return status.Errorf(codes.PermissionDenied, "%s lacks the %s role", p.Name, role)
+	}
+	return nil
+}
+
+func (a authorizer) authenticate(ctx context.Context) (api.Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	p, err := a.auth.Authenticate(first("x-api-key"), first("authorization"))
+	if err != api.ErrNoCredentials {
+		return p, err
+	}
+	if pr, ok := peer.FromContext(ctx); ok {
+		if ti, ok := pr.AuthInfo.(credentials.TLSInfo); ok && len(ti.State.VerifiedChains) > 0 &&
+			len(ti.State.VerifiedChains[0]) > 0 {
+			if p, ok := a.auth.ClientCertPrincipal(ti.State.VerifiedChains[0][0].Subject.CommonName); ok {
+				return p, nil
+			}
+		}
+	}
+	return api.Principal{}, api.ErrNoCredentials
+}
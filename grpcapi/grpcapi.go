@@ -0,0 +1,179 @@
+// Package grpcapi serves the fibonacci service over gRPC, backed by the
+// same FibService as the HTTP api.  Along with the Fib service, the
+// server supports the standard gRPC health checking and reflection
+// services.
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math"
+	"net"
+
+	"github.com/gdotgordon/fibsrv/api"
+	"github.com/gdotgordon/fibsrv/fibpb"
+	"github.com/gdotgordon/fibsrv/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the full name of the Fib service, as used for its health.
+const ServiceName = "fibsrv.v1.Fib"
+
+// Config holds the optional settings of the server.
+type Config struct {
+	// Auth, if set, requires callers to authenticate with the same
+	// credentials as the HTTP api, passed as "x-api-key" or
+	// "authorization" metadata, or a client certificate.
+	Auth *api.Authenticator
+
+	// TLS, if set, serves over TLS.
+	TLS *tls.Config
+}
+
+// Server is the gRPC server.
+type Server struct {
+	fibpb.UnimplementedFibServer
+	service *service.FibService
+	log     *zap.SugaredLogger
+	grpc    *grpc.Server
+	health  *health.Server
+}
+
+// New creates the server and registers its services.
+func New(svc *service.FibService, log *zap.SugaredLogger, cfg Config) *Server {
+	var opts []grpc.ServerOption
+	if cfg.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg.TLS)))
+	}
+	if cfg.Auth != nil {
+		a := authorizer{auth: cfg.Auth, log: log}
+		opts = append(opts,
+			grpc.UnaryInterceptor(a.unary),
+			grpc.StreamInterceptor(a.stream))
+	}
+	s := &Server{
+		service: svc,
+		log:     log,
+		grpc:    grpc.NewServer(opts...),
+		health:  health.NewServer(),
+	}
+	fibpb.RegisterFibServer(s.grpc, s)
+	healthpb.RegisterHealthServer(s.grpc, s.health)
+	reflection.Register(s.grpc)
+	s.health.SetServingStatus(ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return s
+}
+
+// Serve accepts connections on the listener until the server is stopped.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+// SetNotServing fails the health checks, so clients stop sending requests
+// before the server shuts down.
+func (s *Server) SetNotServing() {
+	s.health.Shutdown()
+}
+
+// Shutdown waits for the pending calls to complete, or stops the server
+// immediately once the context is done.
+func (s *Server) Shutdown(ctx context.Context) {
+	s.health.Shutdown()
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.grpc.Stop()
+	}
+}
+
+// Fib returns fib(n).
+func (s *Server) Fib(ctx context.Context, req *fibpb.FibRequest) (*fibpb.FibResponse, error) {
+	res, err := s.service.Fib(ctx, int(req.N))
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &fibpb.FibResponse{N: req.N, Value: res}, nil
+}
+
+// FibLess counts the memos less than the target.
+func (s *Server) FibLess(ctx context.Context, req *fibpb.FibLessRequest) (*fibpb.FibLessResponse, error) {
+	cnt, err := s.service.FibLess(ctx, req.Target)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &fibpb.FibLessResponse{Count: int64(cnt)}, nil
+}
+
+// Clear removes the memos in the range.  Without a range, the memos are
+// only counted, as removing all of them must be asked for explicitly.
+func (s *Server) Clear(ctx context.Context, req *fibpb.ClearRequest) (*fibpb.ClearResponse, error) {
+	from, to := 0, math.MaxInt32
+	if req.Range == nil && !req.DryRun {
+		return nil, status.Error(codes.InvalidArgument, "a range is required to clear memos")
+	}
+	if r := req.Range; r != nil {
+		if r.To < r.From {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"invalid range: from (%d) exceeds to (%d)", r.From, r.To)
+		}
+		from, to = int(r.From), int(r.To)
+	}
+	cnt, err := s.service.ClearRange(ctx, from, to, req.DryRun)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &fibpb.ClearResponse{Count: int64(cnt), DryRun: req.DryRun}, nil
+}
+
+// FibRange streams fib(n) for each n in the range.  The first value
+// computes and memoizes the ones below it, so the rest are cached.
+func (s *Server) FibRange(req *fibpb.FibRangeRequest, stream fibpb.Fib_FibRangeServer) error {
+	r := req.Range
+	if r == nil || r.To < r.From {
+		return status.Error(codes.InvalidArgument, "a range with from not exceeding to is required")
+	}
+	if r.To > service.MaxFibN {
+		return status.Errorf(codes.OutOfRange, "fib(n) overflows 64 bits for n > %d", service.MaxFibN)
+	}
+	ctx := stream.Context()
+	for n := r.From; n <= r.To; n++ {
+		res, err := s.service.Fib(ctx, int(n))
+		if err != nil {
+			return s.toStatus(err)
+		}
+		if err := stream.Send(&fibpb.FibResponse{N: n, Value: res}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Maps the service errors to gRPC status codes.
+func (s *Server) toStatus(err error) error {
+	var le *service.LimitError
+	switch {
+	case errors.As(err, &le) && le.OutOfRange():
+		return status.Error(codes.OutOfRange, err.Error())
+	case le != nil:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	s.log.Errorw("gRPC invoke error", "error", err)
+	return status.Error(codes.Internal, err.Error())
+}
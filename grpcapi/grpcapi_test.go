@@ -0,0 +1,234 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/gdotgordon/fibsrv/api"
+	"github.com/gdotgordon/fibsrv/fibpb"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Starts a server over an in-memory connection, returning a client
+// connection to it.
+func startServer(t *testing.T, cfg Config) (*Server, *grpc.ClientConn) {
+	svc, err := service.NewFib(store.NewMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(svc, zap.NewNop().Sugar(), cfg)
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, conn
+}
+
+func TestFib(t *testing.T) {
+	_, conn := startServer(t, Config{})
+	client := fibpb.NewFibClient(conn)
+	ctx := context.Background()
+
+	res, err := client.Fib(ctx, &fibpb.FibRequest{N: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Value != 6765 {
+		t.Fatalf("expected fib(20) = 6765, got %d", res.Value)
+	}
+
+	less, err := client.FibLess(ctx, &fibpb.FibLessRequest{Target: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if less.Count != 12 {
+		t.Fatalf("expected 12 memos less than 120, got %d", less.Count)
+	}
+
+	_, err = client.Fib(ctx, &fibpb.FibRequest{N: 94})
+	if status.Code(err) != codes.OutOfRange {
+		t.Fatalf("expected out of range for fib(94), got %v", err)
+	}
+
+	clr, err := client.Clear(ctx, &fibpb.ClearRequest{Range: &fibpb.Range{From: 10, To: 19}, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clr.Count != 10 || !clr.DryRun {
+		t.Fatalf("expected a dry run of 10 memos, got %+v", clr)
+	}
+	if _, err = client.Clear(ctx, &fibpb.ClearRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a range to be required, got %v", err)
+	}
+	if clr, err = client.Clear(ctx, &fibpb.ClearRequest{DryRun: true}); err != nil || clr.Count != 21 {
+		t.Fatalf("expected a dry run of 21 memos, got %+v %v", clr, err)
+	}
+	clr, err = client.Clear(ctx, &fibpb.ClearRequest{Range: &fibpb.Range{To: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clr.Count != 21 {
+		t.Fatalf("expected 21 memos cleared, got %d", clr.Count)
+	}
+}
+
+func TestFibRange(t *testing.T) {
+	_, conn := startServer(t, Config{})
+	client := fibpb.NewFibClient(conn)
+
+	stream, err := client.FibRange(context.Background(),
+		&fibpb.FibRangeRequest{Range: &fibpb.Range{From: 5, To: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint64{5, 8, 13, 21, 34, 55}
+	for i := 0; ; i++ {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			if i != len(expected) {
+				t.Fatalf("expected %d values, got %d", len(expected), i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.N != uint32(5+i) || res.Value != expected[i] {
+			t.Fatalf("%d: expected fib(%d) = %d, got fib(%d) = %d", i, 5+i, expected[i], res.N, res.Value)
+		}
+	}
+
+	stream, err = client.FibRange(context.Background(),
+		&fibpb.FibRangeRequest{Range: &fibpb.Range{From: 10, To: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument, got %v", err)
+	}
+}
+
+// Tests the health follows the server, and the services are listed by
+// reflection.
+func TestHealthAndReflection(t *testing.T) {
+	srv, conn := startServer(t, Config{})
+	ctx := context.Background()
+
+	hc := healthpb.NewHealthClient(conn)
+	res, err := hc.Check(ctx, &healthpb.HealthCheckRequest{Service: ServiceName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected serving, got %v", res.Status)
+	}
+
+	rc, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{}}); err != nil {
+		t.Fatal(err)
+	}
+	rres, err := rc.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, s := range rres.GetListServicesResponse().GetService() {
+		found = found || s.Name == ServiceName
+	}
+	if !found {
+		t.Fatalf("expected %s to be listed, got %v", ServiceName, rres)
+	}
+	rc.CloseSend()
+
+	srv.SetNotServing()
+	res, err = hc.Check(ctx, &healthpb.HealthCheckRequest{Service: ServiceName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected not serving, got %v", res.Status)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	hash := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	auth, err := api.NewAuthenticator(api.AuthConfig{APIKeys: []api.APIKey{
+		{Name: "reader", Hash: hash("read-key"), Roles: []string{api.RoleReader}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, conn := startServer(t, Config{Auth: auth})
+	client := fibpb.NewFibClient(conn)
+
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+	}
+	for i, v := range []struct {
+		ctx  context.Context
+		call func(context.Context) error
+		code codes.Code
+	}{
+		{context.Background(), func(ctx context.Context) error {
+			_, err := client.Fib(ctx, &fibpb.FibRequest{N: 5})
+			return err
+		}, codes.Unauthenticated},
+		{withKey("bad-key"), func(ctx context.Context) error {
+			_, err := client.Fib(ctx, &fibpb.FibRequest{N: 5})
+			return err
+		}, codes.Unauthenticated},
+		{withKey("read-key"), func(ctx context.Context) error {
+			_, err := client.Fib(ctx, &fibpb.FibRequest{N: 5})
+			return err
+		}, codes.OK},
+		{withKey("read-key"), func(ctx context.Context) error {
+			_, err := client.Clear(ctx, &fibpb.ClearRequest{})
+			return err
+		}, codes.PermissionDenied},
+		{withKey("read-key"), func(ctx context.Context) error {
+			stream, err := client.FibRange(ctx, &fibpb.FibRangeRequest{Range: &fibpb.Range{To: 3}})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.OK},
+		{context.Background(), func(ctx context.Context) error {
+			_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+			return err
+		}, codes.OK},
+	} {
+		if code := status.Code(v.call(v.ctx)); code != v.code {
+			t.Fatalf("%d: expected %v, got %v", i, v.code, code)
+		}
+	}
+}
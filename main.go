@@ -1,93 +1,79 @@
 // Package main is the starting point for the HTTP server for the
 // fibonacci service  It creates the api, store and service artifacts
 // and then launches the server.  It supports signal handlers for a
-// clean shutdown.
+// clean shutdown.  The other subcommands run the queue workers and
+// maintain the memos with the same configuration.
 package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gdotgordon/fibsrv/api"
+	"github.com/gdotgordon/fibsrv/config"
+	"github.com/gdotgordon/fibsrv/grpcapi"
+	"github.com/gdotgordon/fibsrv/metrics"
 	"github.com/gdotgordon/fibsrv/service"
 	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gdotgordon/fibsrv/tlsconf"
+	"github.com/gdotgordon/fibsrv/tracing"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
 
-const (
-	// PostgresPort is the default postgres port
-	PostgresPort = 5432
-
-	// PostgresHost is the host from docker-compose
-	PostgresHost = "db"
-)
-
 type cleanupTask func() error
 
-var (
-	portNum  int    // listen port
-	logLevel string // zap log level
-	timeout  int    // server timeout in seconds
-)
-
-func init() {
-	flag.IntVar(&portNum, "port", 8080, "HTTP port number")
-	flag.StringVar(&logLevel, "log", "production",
-		"log level: 'production', 'development'")
-	flag.IntVar(&timeout, "timeout", 30, "server timeout (seconds)")
-}
-
 func main() {
-	flag.Parse()
+	os.Exit(run(os.Args[1:]))
+}
 
-	// We'll propagate the context with cancel thorughout the program,
-	// to be used by various entities, such as http clients, server
-	// methods we implement, and other loops using channels.
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// Runs the HTTP and gRPC servers until a signal is received.
+func serve(ctx context.Context, args []string) int {
+	fs := newFlagSet("serve")
+	cfg, code := loadConfig(fs, args)
+	if code != exitOK || cfg == nil {
+		return code
+	}
 
 	// Set up logging.
-	log, err := initLogging()
+	log, err := initLogging(cfg.LogLevel)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating logger: %v", err)
-		os.Exit(1)
+		return fail(exitFailure, "error creating logger", err)
 	}
 
-	postgresUser := os.Getenv("POSTGRES_USER")
-	postgresPassword := os.Getenv("POSTGRES_PASSWORD")
-	postgresDB := os.Getenv("POSTGRES_DB")
-	if postgresUser == "" || postgresPassword == "" || postgresDB == "" {
-		fmt.Fprintf(os.Stderr,
-			"environment vars POSTGRES_USER, POSTGRES_PASSWORD and POSTGRES_DB must be set")
-		os.Exit(1)
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter: cfg.Tracing.Exporter,
+		File:     cfg.Tracing.File,
+	})
+	if err != nil {
+		return fail(exitFailure, "error setting up tracing", err)
 	}
-	dataStore, err := store.NewPostgres(ctx,
-		store.PostgresConfig{
-			Host:     PostgresHost,
-			Port:     PostgresPort,
-			User:     postgresUser,
-			Password: postgresPassword,
-			DBName:   postgresDB,
-		},
-		log,
-	)
+
+	st, closeStore, err := openStore(ctx, cfg, log)
 	if err != nil {
-		fmt.Println("error opening store:", err)
-		os.Exit(1)
+		return fail(exitFailure, "error opening store", err)
 	}
 
-	svc, err := service.NewFib(dataStore)
+	// The store is wrapped to gather metrics and traces on it.
+	mets := metrics.New()
+	if pg, ok := st.(*store.PostgresStore); ok {
+		if err := mets.RegisterDBStats(pg.Stats); err != nil {
+			return fail(exitFailure, "error registering DB metrics", err)
+		}
+	}
+	dataStore := tracing.NewStore(mets.NewStore(st))
+
+	svc, err := service.NewFib(dataStore,
+		service.WithCacheObserver(mets.ObserveCache),
+		service.WithLimits(limitSettings(cfg.Limits)))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "error creating service:", err)
-		os.Exit(1)
+		return fail(exitFailure, "error creating service", err)
 	}
 
 	// Create the server to handle the Fibonacci service.  The API module will
@@ -95,41 +81,220 @@ func main() {
 	// main program.
 	muxer := mux.NewRouter()
 
+	// The health is checked on the underlying store, as it is
+	// the one that knows how to ping the database and report its pool,
+	// so the pings have no metrics or spans.
+	health := api.NewHealth(st)
+
+	var auth *api.Authenticator
+	if cfg.Auth.Enabled() {
+		if auth, err = api.NewAuthenticator(authSettings(cfg.Auth)); err != nil {
+			return fail(exitFailure, "error setting up authentication", err)
+		}
+	}
+
+	// The daily quotas are kept in the store, to survive restarts of the
+	// server with a database.
+	var limiter *api.RateLimiter
+	if cfg.Server.RateLimit.Enabled() {
+		rlCfg := rateLimitSettings(cfg.Server.RateLimit)
+		rlCfg.Quotas = dataStore
+		limiter = api.NewRateLimiter(rlCfg)
+	}
+
+	// The jobs run in the background, with their own time limit.
+	var jobs *service.Jobs
+	if cfg.Jobs.Enabled() {
+		jobs = service.NewJobs(svc, jobSettings(cfg.Jobs))
+	}
+
 	// Initialize the API layer.
-	if err := api.Init(ctx, muxer, svc, log); err != nil {
-		log.Errorf("Error initializing API layer", "error", err)
-		os.Exit(1)
+	if err := api.Init(ctx, muxer, svc, log, api.Config{
+		LegacyClear: cfg.Server.LegacyClear,
+		Metrics:     mets,
+		Tracing:     cfg.Tracing.Exporter != tracing.ExporterNone,
+		Health:      health,
+		Auth:        auth,
+		RateLimiter: limiter,
+		Jobs:        jobs,
+		Queue:       dataStore,
+
+		// Mismatches with the OpenAPI document are logged in development.
+		ValidateResponses: cfg.LogLevel == "development",
+	}); err != nil {
+		log.Errorw("Error initializing API layer", "error", err)
+		return exitFailure
 	}
 
 	srv := &http.Server{
 		Handler:      muxer,
-		Addr:         fmt.Sprintf(":%d", portNum),
-		ReadTimeout:  time.Duration(timeout) * time.Second,
-		WriteTimeout: time.Duration(timeout) * time.Second,
+		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout),
+	}
+
+	// The certificates are reloaded when they change on disk.
+	if cfg.Server.TLS.Enabled() {
+		tlsMgr, err := tlsconf.New(cfg.Server.TLS.Settings(), log)
+		if err != nil {
+			return fail(exitFailure, "error setting up TLS", err)
+		}
+		srv.TLSConfig = tlsMgr.TLSConfig()
+		go tlsMgr.Run(ctx)
+	}
+
+	// The gRPC server shares the service, credentials and certificates,
+	// and its health follows the HTTP readiness.
+	tasks := []cleanupTask{}
+	if cfg.Server.GRPCPort != 0 {
+		grpcSrv := grpcapi.New(svc, log, grpcapi.Config{Auth: auth, TLS: srv.TLSConfig})
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Server.GRPCPort))
+		if err != nil {
+			return fail(exitFailure, "error listening for gRPC", err)
+		}
+		health.OnNotReady(grpcSrv.SetNotServing)
+		go func() {
+			log.Infow("Listening for gRPC connections", "port", cfg.Server.GRPCPort)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Infow("gRPC server completed", "err", err)
+			}
+		}()
+		tasks = append(tasks, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+			defer cancel()
+			grpcSrv.Shutdown(ctx)
+			return nil
+		})
 	}
 
 	// Start server
 	go func() {
-		log.Infow("Listening for connections", "port", portNum)
-		if err := srv.ListenAndServe(); err != nil {
+		log.Infow("Listening for connections", "port", cfg.Server.Port,
+			"tls", cfg.Server.TLS.Enabled())
+		var err error
+		if cfg.Server.TLS.Enabled() {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil {
 			log.Infow("Server completed", "err", err)
 		}
 	}()
 
+	// The running jobs are cancelled before the store is closed.
+	if jobs != nil {
+		tasks = append(tasks, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+			defer cancel()
+			return jobs.Shutdown(ctx)
+		})
+	}
+
+	// Without a database, there are no separate workers to run the queued
+	// jobs, so they run in the server.
+	if cfg.Store.Memory() {
+		owner := fmt.Sprintf("%s-%d", cfg.InstanceID, os.Getpid())
+		workers := service.NewQueueWorkers(svc, dataStore, log, queueSettings(cfg.Queue, owner, cfg.Jobs.Timeout))
+		wctx, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			workers.Run(wctx)
+			close(done)
+		}()
+		tasks = append(tasks, func() error {
+			stop()
+			<-done
+			return nil
+		})
+	}
+
 	// Block until we shutdown.
-	srvShut := dataStore.(*store.PostgresStore).Shutdown
-	waitForShutdown(ctx, srv, log, srvShut)
+	tasks = append(tasks, closeStore, func() error {
+		return shutdownTracing(context.Background())
+	})
+	waitForShutdown(ctx, cfg.Server, srv, health, log, tasks...)
+	return exitOK
 }
 
-// Set up the logger, condsidering any env vars.
-func initLogging() (*zap.SugaredLogger, error) {
-	var lg *zap.Logger
-	var err error
+// The store of the server, which also keeps the quotas and queued jobs.
+type serverStore interface {
+	store.Store
+	store.Quotas
+	store.Queue
+}
+
+// Opens the configured store, returning the task that closes it.  The
+// memory store is loaded from its snapshot, and then seeded from an
+// export, if they are configured.
+func openStore(ctx context.Context, cfg *config.Config, log *zap.SugaredLogger) (serverStore, cleanupTask, error) {
+	if !cfg.Store.Memory() {
+		pg, err := openPostgres(ctx, cfg, log)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pg, pg.Shutdown, nil
+	}
+
+	ms := store.NewMap().(*store.MapStore)
+	if cfg.Store.Snapshot != "" {
+		var err error
+		if ms, err = store.NewSnapshotMap(snapshotSettings(cfg.Store), log); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		log.Infow("Using the memory store without snapshots, the memos are lost at shutdown")
+	}
+	if cfg.Store.Seed != "" {
+		if err := seed(ctx, ms, cfg.Store.Seed); err != nil {
+			ms.Shutdown()
+			return nil, nil, err
+		}
+	}
+	return ms, ms.Shutdown, nil
+}
 
-	pdl := strings.ToLower(os.Getenv("IPVERIFY_LOG_LEVEL"))
-	if strings.HasPrefix(pdl, "d") {
-		logLevel = "development"
+// Memoizes the pairs of an export in the store, keeping those present.
+func seed(ctx context.Context, ms *store.MapStore, file string) error {
+	pairs, err := readPairsFile(file)
+	if err != nil {
+		return err
+	}
+	svc, err := service.NewFib(ms)
+	if err != nil {
+		return err
 	}
+	if err := svc.Import(ctx, pairs); err != nil {
+		return fmt.Errorf("seeding %s: %v", file, err)
+	}
+	return nil
+}
+
+// Opens the Postgres store, upgrading its schema.
+func openPostgres(ctx context.Context, cfg *config.Config, log *zap.SugaredLogger) (*store.PostgresStore, error) {
+	pgStore, err := store.NewPostgres(ctx,
+		store.PostgresConfig{
+			Host:             cfg.Postgres.Host,
+			Port:             cfg.Postgres.Port,
+			User:             cfg.Postgres.User,
+			Password:         cfg.Postgres.Password,
+			DBName:           cfg.Postgres.DBName,
+			InstanceID:       cfg.InstanceID,
+			HitFlushInterval: time.Duration(cfg.Postgres.HitFlushInterval),
+		},
+		log,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgStore.(*store.PostgresStore), nil
+}
+
+// Set up the logger for the configured level.
+func initLogging(logLevel string) (*zap.SugaredLogger, error) {
+	var lg *zap.Logger
+	var err error
 
 	var cfg zap.Config
 	if logLevel == "development" {
@@ -145,25 +310,30 @@ func initLogging() (*zap.SugaredLogger, error) {
 	return lg.Sugar(), nil
 }
 
-// Setup for clean shutdown with signal handlers/cancel.
-func waitForShutdown(ctx context.Context, srv *http.Server,
-	log *zap.SugaredLogger, tasks ...cleanupTask) {
+// Setup for clean shutdown with signal handlers/cancel.  On a signal, the
+// server first reports itself as not ready, and waits for load balancers
+// to notice and drain traffic before stopping the server.  The cleanup
+// tasks are run once the in-flight requests have completed.
+func waitForShutdown(ctx context.Context, cfg config.Server, srv *http.Server,
+	health *api.Health, log *zap.SugaredLogger, tasks ...cleanupTask) {
 	interruptChan := make(chan os.Signal, 1)
 	signal.Notify(interruptChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// Block until we receive our signal.
 	sig := <-interruptChan
 	log.Debugw("Termination signal received", "signal", sig)
-	for _, t := range tasks {
-		if err := t(); err != nil {
-			log.Infof("Shutdown error", "error", err.Error())
-		}
-	}
+	health.SetNotReady()
+	time.Sleep(time.Duration(cfg.Drain))
 
 	// Create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.ShutdownTimeout))
 	defer cancel()
 	srv.Shutdown(ctx)
 
+	for _, t := range tasks {
+		if err := t(); err != nil {
+			log.Infof("Shutdown error", "error", err.Error())
+		}
+	}
 	log.Infof("Server shutting down")
 }
@@ -0,0 +1,151 @@
+// Package metrics exposes the Prometheus metrics for the fibonacci service.
+// The HTTP metrics are gathered by a middleware installed by the api
+// package, the store metrics by a decorator around any store.Store, and
+// the cache metrics by an observer hooked into the service.  The metrics
+// are served by the handler returned from Handler, normally on /metrics.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fibsrv"
+
+// Metrics holds the collectors and the registry they are registered with.
+type Metrics struct {
+	reg *prometheus.Registry
+
+	requests       *prometheus.CounterVec
+	requestLatency *prometheus.HistogramVec
+	storeLatency   *prometheus.HistogramVec
+	cacheLookups   *prometheus.CounterVec
+	maxMemo        prometheus.Gauge
+
+	// Kept separately from the counter to compute the ratio.
+	hits, lookups uint64
+}
+
+// New creates the collectors and registers them, along with the standard
+// Go runtime and process collectors, in a new registry.
+func New() *Metrics {
+	m := &Metrics{
+		reg: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		storeLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Store operation latency by method and result.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		}, []string{"method", "result"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_lookups_total",
+			Help:      "Memo lookups made while computing fib(n), by result (hit or miss).",
+		}, []string{"result"}),
+		maxMemo: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "memo_max_n",
+			Help:      "The highest n memoized or read by this instance.",
+		}),
+	}
+	cacheRatio := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_hit_ratio",
+		Help:      "Ratio of memo hits to lookups since startup.",
+	}, m.hitRatio)
+
+	m.reg.MustRegister(
+		m.requests, m.requestLatency, m.storeLatency, m.cacheLookups,
+		m.maxMemo, cacheRatio,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler returns the HTTP handler serving the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{})
+}
+
+// Registry returns the registry, so other collectors may be added.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.reg
+}
+
+// Middleware records the count and latency of the requests.  It is meant
+// to be installed on a mux router, so the route label is the path template
+// and not the actual path, which would be unbounded.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tmpl, err := cr.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		code := strconv.Itoa(sw.code)
+		m.requests.WithLabelValues(route, r.Method, code).Inc()
+		m.requestLatency.WithLabelValues(route, r.Method, code).Observe(
+			time.Since(start).Seconds())
+	})
+}
+
+// ObserveCache records a memo lookup.  It is meant to be passed to the
+// service as its cache observer.
+func (m *Metrics) ObserveCache(hit bool) {
+	atomic.AddUint64(&m.lookups, 1)
+	if hit {
+		atomic.AddUint64(&m.hits, 1)
+		m.cacheLookups.WithLabelValues("hit").Inc()
+	} else {
+		m.cacheLookups.WithLabelValues("miss").Inc()
+	}
+}
+
+func (m *Metrics) hitRatio() float64 {
+	lookups := atomic.LoadUint64(&m.lookups)
+	if lookups == 0 {
+		return 0
+	}
+	return float64(atomic.LoadUint64(&m.hits)) / float64(lookups)
+}
+
+// RegisterDBStats adds the connection pool statistics reported by the
+// stats function, such as PostgresStore.Stats.
+func (m *Metrics) RegisterDBStats(stats func() sql.DBStats) error {
+	return m.reg.Register(newDBStatsCollector(stats))
+}
+
+// Captures the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.code = code
+	sw.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Tests the store decorator records the operations and the highest memo.
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	s := m.NewStore(store.NewMap())
+	for n := 0; n <= 10; n++ {
+		if err := s.Memoize(ctx, n, uint64(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := s.Memo(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(m.maxMemo); v != 10 {
+		t.Fatalf("expected max memo 10, got %v", v)
+	}
+	if _, err := s.ClearRange(ctx, 8, 20, false); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(m.maxMemo); v != 7 {
+		t.Fatalf("expected max memo 7 after clearing range, got %v", v)
+	}
+	if n := testutil.CollectAndCount(m.storeLatency); n != 3 {
+		t.Fatalf("expected 3 store latency series, got %d", n)
+	}
+
+	// The quotas and the queue of the store are recorded too.
+	if _, err := s.AddUsage(ctx, "client", time.Now(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Enqueue(ctx, []byte("{}"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(m.storeLatency); n != 5 {
+		t.Fatalf("expected 5 store latency series, got %d", n)
+	}
+
+	m.ObserveCache(true)
+	m.ObserveCache(false)
+	m.ObserveCache(true)
+	m.ObserveCache(true)
+	if r := m.hitRatio(); r != 0.75 {
+		t.Fatalf("expected hit ratio 0.75, got %v", r)
+	}
+}
+
+// Tests the middleware labels requests by route template and status code.
+func TestMiddleware(t *testing.T) {
+	m := New()
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/memos/{n}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Use(m.Middleware)
+
+	for _, path := range []string{"/v1/memos/1", "/v1/memos/2"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	c := m.requests.WithLabelValues("/v1/memos/{n}", http.MethodGet, "404")
+	if v := testutil.ToFloat64(c); v != 2 {
+		t.Fatalf("expected 2 requests, got %v", v)
+	}
+}
@@ -0,0 +1,259 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/store"
+)
+
+// Compile time interface implementation checks.
+var (
+	_ store.Store  = (*Store)(nil)
+	_ store.Quotas = (*Store)(nil)
+	_ store.Queue  = (*Store)(nil)
+)
+
+// Store is a store.Store decorator recording the latency of each
+// operation, as well as the highest memoized n.  It forwards the quotas
+// and the queue as well, which fail if the wrapped store has none.
+type Store struct {
+	store.Store
+	m *Metrics
+
+	mu   sync.Mutex
+	maxN int
+}
+
+// NewStore wraps a store with metrics.
+func (m *Metrics) NewStore(s store.Store) *Store {
+	m.maxMemo.Set(-1)
+	return &Store{Store: s, m: m, maxN: -1}
+}
+
+// Memo implements store.Store.
+func (s *Store) Memo(ctx context.Context, n int) (uint64, bool, error) {
+	start := time.Now()
+	val, ok, err := s.Store.Memo(ctx, n)
+	s.observe("Memo", start, err)
+	if ok {
+		s.seen(n)
+	}
+	return val, ok, err
+}
+
+// Memoize implements store.Store.
+func (s *Store) Memoize(ctx context.Context, n int, val uint64) error {
+	start := time.Now()
+	err := s.Store.Memoize(ctx, n, val)
+	s.observe("Memoize", start, err)
+	if err == nil {
+		s.seen(n)
+	}
+	return err
+}
+
+// MemoDetail implements store.Store.
+func (s *Store) MemoDetail(ctx context.Context, n int) (store.FibPair, bool, error) {
+	start := time.Now()
+	p, ok, err := s.Store.MemoDetail(ctx, n)
+	s.observe("MemoDetail", start, err)
+	return p, ok, err
+}
+
+// MemoCount implements store.Store.
+func (s *Store) MemoCount(ctx context.Context, target uint64) (int, error) {
+	start := time.Now()
+	cnt, err := s.Store.MemoCount(ctx, target)
+	s.observe("MemoCount", start, err)
+	return cnt, err
+}
+
+// List implements store.Store.
+func (s *Store) List(ctx context.Context, after, limit int) ([]store.FibPair, error) {
+	start := time.Now()
+	res, err := s.Store.List(ctx, after, limit)
+	s.observe("List", start, err)
+	return res, err
+}
+
+// Clear implements store.Store.
+func (s *Store) Clear(ctx context.Context) error {
+	start := time.Now()
+	err := s.Store.Clear(ctx)
+	s.observe("Clear", start, err)
+	if err == nil {
+		s.setMax(-1)
+	}
+	return err
+}
+
+// ClearRange implements store.Store.
+func (s *Store) ClearRange(ctx context.Context, from, to int, dryRun bool) (int, error) {
+	start := time.Now()
+	cnt, err := s.Store.ClearRange(ctx, from, to, dryRun)
+	s.observe("ClearRange", start, err)
+
+	// The memos are normally contiguous, so if the range covered the
+	// highest one, the new highest is the one just below the range.
+	if err == nil && !dryRun {
+		s.mu.Lock()
+		if s.maxN >= from && s.maxN <= to {
+			s.maxN = from - 1
+			s.m.maxMemo.Set(float64(s.maxN))
+		}
+		s.mu.Unlock()
+	}
+	return cnt, err
+}
+
+// CountRange implements store.Store.
+func (s *Store) CountRange(ctx context.Context, from, to int) (int, error) {
+	start := time.Now()
+	cnt, err := s.Store.CountRange(ctx, from, to)
+	s.observe("CountRange", start, err)
+	return cnt, err
+}
+
+// AddUsage implements store.Quotas.
+func (s *Store) AddUsage(ctx context.Context, key string, day time.Time, n int) (int, error) {
+	q, ok := s.Store.(store.Quotas)
+	if !ok {
+		return 0, fmt.Errorf("%T does not keep quotas", s.Store)
+	}
+	start := time.Now()
+	used, err := q.AddUsage(ctx, key, day, n)
+	s.observe("AddUsage", start, err)
+	return used, err
+}
+
+// Returns the queue of the wrapped store.
+func (s *Store) queue() (store.Queue, error) {
+	q, ok := s.Store.(store.Queue)
+	if !ok {
+		return nil, fmt.Errorf("%T has no queue", s.Store)
+	}
+	return q, nil
+}
+
+// Enqueue implements store.Queue.
+func (s *Store) Enqueue(ctx context.Context, payload []byte, maxAttempts int) (int64, error) {
+	q, err := s.queue()
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	id, err := q.Enqueue(ctx, payload, maxAttempts)
+	s.observe("Enqueue", start, err)
+	return id, err
+}
+
+// Claim implements store.Queue.
+func (s *Store) Claim(ctx context.Context, owner string, lease time.Duration) (store.QueuedJob, bool, error) {
+	q, err := s.queue()
+	if err != nil {
+		return store.QueuedJob{}, false, err
+	}
+	start := time.Now()
+	job, ok, err := q.Claim(ctx, owner, lease)
+	s.observe("Claim", start, err)
+	return job, ok, err
+}
+
+// Extend implements store.Queue.
+func (s *Store) Extend(ctx context.Context, id int64, owner string, lease time.Duration) error {
+	q, err := s.queue()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = q.Extend(ctx, id, owner, lease)
+	s.observe("Extend", start, err)
+	return err
+}
+
+// Complete implements store.Queue.
+func (s *Store) Complete(ctx context.Context, id int64, owner string) error {
+	q, err := s.queue()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = q.Complete(ctx, id, owner)
+	s.observe("Complete", start, err)
+	return err
+}
+
+// Fail implements store.Queue.
+func (s *Store) Fail(ctx context.Context, id int64, owner, cause string, delay time.Duration) (string, error) {
+	q, err := s.queue()
+	if err != nil {
+		return "", err
+	}
+	start := time.Now()
+	state, err := q.Fail(ctx, id, owner, cause, delay)
+	s.observe("Fail", start, err)
+	return state, err
+}
+
+// DeadLetter implements store.Queue.
+func (s *Store) DeadLetter(ctx context.Context, id int64, owner, cause string) error {
+	q, err := s.queue()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = q.DeadLetter(ctx, id, owner, cause)
+	s.observe("DeadLetter", start, err)
+	return err
+}
+
+// Release implements store.Queue.
+func (s *Store) Release(ctx context.Context, id int64, owner string) error {
+	q, err := s.queue()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = q.Release(ctx, id, owner)
+	s.observe("Release", start, err)
+	return err
+}
+
+// QueuedJob implements store.Queue.
+func (s *Store) QueuedJob(ctx context.Context, id int64) (store.QueuedJob, bool, error) {
+	q, err := s.queue()
+	if err != nil {
+		return store.QueuedJob{}, false, err
+	}
+	start := time.Now()
+	job, ok, err := q.QueuedJob(ctx, id)
+	s.observe("QueuedJob", start, err)
+	return job, ok, err
+}
+
+func (s *Store) observe(method string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	s.m.storeLatency.WithLabelValues(method, result).Observe(time.Since(start).Seconds())
+}
+
+func (s *Store) seen(n int) {
+	s.mu.Lock()
+	if n > s.maxN {
+		s.maxN = n
+		s.m.maxMemo.Set(float64(n))
+	}
+	s.mu.Unlock()
+}
+
+func (s *Store) setMax(n int) {
+	s.mu.Lock()
+	s.maxN = n
+	s.m.maxMemo.Set(float64(n))
+	s.mu.Unlock()
+}
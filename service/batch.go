@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BatchItem is the outcome for one n of a batch.
+type BatchItem struct {
+	N     int
+	Value uint64
+	Err   error
+}
+
+// BatchLessItem is the outcome for one FibLess target of a batch.
+type BatchLessItem struct {
+	Target uint64
+	Count  int
+	Err    error
+}
+
+// FibBatch computes fib(n) for each of the indices, and FibLess for each of
+// the targets, in the order given.  The indices are sorted and deduplicated,
+// so a single pass over the sequence up to the largest one covers the whole
+// batch, with one memo lookup per n.  Items out of range or over the maximum
+// n get their own errors, while the returned error is for the batch as a
+// whole, such as exceeding the limits on writes or time.
+func (fs *FibService) FibBatch(ctx context.Context, ns []int, targets []uint64) ([]BatchItem, []BatchLessItem, error) {
+	ctx, span := tracer.Start(ctx, "FibService.FibBatch",
+		trace.WithAttributes(attribute.Int("fib.batch.n", len(ns)),
+			attribute.Int("fib.batch.targets", len(targets))))
+	items, less, err := fs.fibBatch(ctx, ns, targets, nil)
+	endSpan(span, err)
+	return items, less, err
+}
+
+// The progress, if set, is called with the number of steps done out of the
+// total as the batch is computed.
+func (fs *FibService) fibBatch(ctx context.Context, ns []int, targets []uint64,
+	progress func(done, total int)) ([]BatchItem, []BatchLessItem, error) {
+	items := make([]BatchItem, len(ns))
+	less := make([]BatchLessItem, len(targets))
+
+	// Find the distinct indices to cover, recording the items that can't
+	// be computed.
+	need := make(map[int]bool)
+	for i, n := range ns {
+		items[i].N = n
+		if n < 0 {
+			items[i].Err = fmt.Errorf("invalid fibonacci request: %d", n)
+		} else if items[i].Err = fs.checkN(n); items[i].Err == nil {
+			need[n] = true
+		}
+	}
+	counts := make(map[uint64]int)
+	for i, t := range targets {
+		less[i].Target = t
+		if less[i].Err = fs.checkN(fibIndex(t)); less[i].Err == nil {
+			need[fibIndex(t)] = true
+			counts[t] = 0
+		}
+	}
+	if len(need) == 0 {
+		return items, less, nil
+	}
+	sorted := make([]int, 0, len(need))
+	for n := range need {
+		sorted = append(sorted, n)
+	}
+	sort.Ints(sorted)
+	top := sorted[len(sorted)-1]
+
+	err := fs.checkCost(ctx, top, func() (Cost, error) {
+		c, err := fs.EstimateFib(ctx, top)
+		c.Lookups = top + 1 + len(counts)
+		c.Time = time.Duration(c.Lookups+c.Writes) * fs.limits.OpCost
+		return c, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var vals []uint64
+	err = fs.withTimeLimit(ctx, top, func(ctx context.Context) error {
+		var err error
+		if vals, err = fs.sequence(ctx, top, progress); err != nil {
+			return err
+		}
+		for t := range counts {
+			if counts[t], err = fs.store.MemoCount(ctx, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range items {
+		if items[i].Err == nil {
+			items[i].Value = vals[items[i].N]
+		}
+	}
+	for i := range less {
+		if less[i].Err == nil {
+			less[i].Count = counts[less[i].Target]
+		}
+	}
+	return items, less, nil
+}
+
+// Walks the sequence from 0 to top, reading each memo once, and storing
+// the missing ones from the two before them.
+func (fs *FibService) sequence(ctx context.Context, top int, progress func(done, total int)) ([]uint64, error) {
+	vals := make([]uint64, top+1)
+	for n := 0; n <= top; n++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		val, ok, err := fs.store.Memo(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		if fs.cacheObserver != nil {
+			fs.cacheObserver(ok)
+		}
+		if !ok {
+			switch n {
+			case 0, 1:
+				val = uint64(n)
+			default:
+				val = vals[n-1] + vals[n-2]
+			}
+			if err := fs.store.Memoize(ctx, n, val); err != nil {
+				return nil, err
+			}
+		}
+		vals[n] = val
+		if progress != nil {
+			progress(n+1, top+1)
+		}
+	}
+	return vals, nil
+}
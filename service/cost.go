@@ -0,0 +1,200 @@
+package service
+
+// The cost model estimates the work a request will do before starting it,
+// so requests exceeding the configured limits are rejected up front.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxFibN is the largest n whose fib(n) fits in a uint64; fib(94)
+// overflows.
+const MaxFibN = 93
+
+// The limits, as reported by LimitError.
+const (
+	LimitRange   = "range"        // fib(n) is not representable
+	LimitMaxN    = "max_n"        // n exceeds the configured maximum
+	LimitWrites  = "max_writes"   // too many memos would be stored
+	LimitCPUTime = "max_cpu_time" // the computation would take too long
+)
+
+// Limits bound the work a single request may do.  Zero values mean
+// no limit, except for MaxN, which defaults to MaxFibN.
+type Limits struct {
+	// MaxN is the largest n that may be computed.
+	MaxN int
+
+	// MaxWrites is the number of memos a request may store.
+	MaxWrites int
+
+	// MaxCPUTime is the wall-clock time a request may spend computing,
+	// including waiting on the store, rather than the CPU time.  Requests
+	// estimated to take longer are rejected, and those that still exceed
+	// it are cancelled.
+	MaxCPUTime time.Duration
+
+	// OpCost is the expected time of a store operation, used to estimate
+	// the time of a request.
+	OpCost time.Duration
+}
+
+// WithLimits sets the limits on the work of a request.
+func WithLimits(l Limits) Option {
+	return func(fs *FibService) {
+		fs.limits = l
+	}
+}
+
+// Cost is the estimated work of a request.
+type Cost struct {
+	// N is the largest n computed.
+	N int
+
+	// Writes is the number of memos missing for 0 to N, which would be
+	// stored.
+	Writes int
+
+	// Lookups is the number of memo reads.
+	Lookups int
+
+	// Time is the estimated duration, from the operations and the
+	// configured OpCost.
+	Time time.Duration
+}
+
+// LimitError is returned when a request exceeds a limit.
+type LimitError struct {
+	Limit string
+	Cost  Cost
+	msg   string
+}
+
+func (e *LimitError) Error() string {
+	return e.msg
+}
+
+// OutOfRange tells whether the request can never be computed, as opposed
+// to being too costly under the current limits and memos.
+func (e *LimitError) OutOfRange() bool {
+	return e.Limit == LimitRange
+}
+
+// EstimateFib returns the cost of computing fib(n).  The recursion looks up
+// each missing memo about twice and stores it once.
+func (fs *FibService) EstimateFib(ctx context.Context, n int) (Cost, error) {
+	if n < 0 {
+		return Cost{}, fmt.Errorf("invalid fibonacci request: %d", n)
+	}
+	c := Cost{N: n, Lookups: 1}
+	if n > MaxFibN {
+		return c, nil
+	}
+	have, err := fs.store.CountRange(ctx, 0, n)
+	if err != nil {
+		return Cost{}, err
+	}
+	if missing := n + 1 - have; missing > 0 {
+		c.Writes = missing
+		c.Lookups += 2 * missing
+	}
+	c.Time = time.Duration(c.Lookups+c.Writes) * fs.limits.OpCost
+	return c, nil
+}
+
+// CheckFib returns the error Fib would fail with for being over the
+// limits, without computing anything.
+func (fs *FibService) CheckFib(ctx context.Context, n int) error {
+	return fs.checkCost(ctx, n, func() (Cost, error) { return fs.EstimateFib(ctx, n) })
+}
+
+// EstimateFibLess returns the cost of FibLess, which computes fib(n) for
+// every n up to the first one reaching the target, then counts the memos.
+func (fs *FibService) EstimateFibLess(ctx context.Context, target uint64) (Cost, error) {
+	n := fibIndex(target)
+	c, err := fs.EstimateFib(ctx, n)
+	if err != nil {
+		return Cost{}, err
+	}
+	c.Lookups += n + 1
+	c.Time += time.Duration(n+2) * fs.limits.OpCost
+	return c, nil
+}
+
+// Returns the smallest n where fib(n) >= target, or MaxFibN if there is
+// none.  This is plain arithmetic, without the store.
+func fibIndex(target uint64) int {
+	var a, b uint64 = 0, 1
+	for n := 0; n < MaxFibN; n++ {
+		if a >= target {
+			return n
+		}
+		a, b = b, a+b
+	}
+	return MaxFibN
+}
+
+// Checks a request is within the limits before it starts.  The store is
+// only consulted for the memo coverage if there are limits that need it.
+func (fs *FibService) checkCost(ctx context.Context, n int, estimate func() (Cost, error)) error {
+	if err := fs.checkN(n); err != nil {
+		return err
+	}
+
+	estimateTime := fs.limits.MaxCPUTime > 0 && fs.limits.OpCost > 0
+	if fs.limits.MaxWrites <= 0 && !estimateTime {
+		return nil
+	}
+	c, err := estimate()
+	if err != nil {
+		return err
+	}
+	if fs.limits.MaxWrites > 0 && c.Writes > fs.limits.MaxWrites {
+		return &LimitError{Limit: LimitWrites, Cost: c,
+			msg: fmt.Sprintf("computing fib(%d) would store %d memos, more than the limit of %d",
+				n, c.Writes, fs.limits.MaxWrites)}
+	}
+	if estimateTime && c.Time > fs.limits.MaxCPUTime {
+		return &LimitError{Limit: LimitCPUTime, Cost: c,
+			msg: fmt.Sprintf("computing fib(%d) is estimated to take %v, more than the limit of %v",
+				n, c.Time, fs.limits.MaxCPUTime)}
+	}
+	return nil
+}
+
+// Checks n is in range and within the maximum, which needs no estimate.
+func (fs *FibService) checkN(n int) error {
+	c := Cost{N: n}
+	if n > MaxFibN {
+		return &LimitError{Limit: LimitRange, Cost: c,
+			msg: fmt.Sprintf("fib(%d) is out of range: fib(n) overflows 64 bits for n > %d", n, MaxFibN)}
+	}
+	maxN := fs.limits.MaxN
+	if maxN <= 0 || maxN > MaxFibN {
+		maxN = MaxFibN
+	}
+	if n > maxN {
+		return &LimitError{Limit: LimitMaxN, Cost: c,
+			msg: fmt.Sprintf("computing fib(%d) exceeds the maximum n of %d", n, maxN)}
+	}
+	return nil
+}
+
+// Runs the computation under the time limit, if any.  A computation cut
+// short by it is reported as a LimitError.
+func (fs *FibService) withTimeLimit(ctx context.Context, n int, f func(context.Context) error) error {
+	if fs.limits.MaxCPUTime <= 0 {
+		return f(ctx)
+	}
+	tctx, cancel := context.WithTimeout(ctx, fs.limits.MaxCPUTime)
+	defer cancel()
+	err := f(tctx)
+	if err != nil && errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return &LimitError{Limit: LimitCPUTime, Cost: Cost{N: n},
+			msg: fmt.Sprintf("computing fib(%d) exceeded the time limit of %v", n, fs.limits.MaxCPUTime)}
+	}
+	return err
+}
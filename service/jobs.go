@@ -0,0 +1,312 @@
+package service
+
+// Jobs run computations in the background, for those taking longer than a
+// client can wait on a request.  A fixed pool of workers takes the jobs
+// from a bounded queue, and the finished jobs are kept for a while so
+// their results can be fetched.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The errors of the jobs.
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+	ErrQueueFull   = errors.New("job queue is full")
+	ErrJobsClosed  = errors.New("jobs are shut down")
+)
+
+// JobState is the state of a job.
+type JobState string
+
+// The job states.
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Finished tells whether a job in the state is done, one way or another.
+func (s JobState) Finished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCancelled
+}
+
+// Job is a snapshot of a job, which computes a batch.
+type Job struct {
+	ID      string
+	State   JobState
+	N       []int
+	Targets []uint64
+
+	// Done is the number of steps of the sequence computed, out of Total,
+	// which is zero until the job starts computing.
+	Done, Total int
+
+	// The results of a succeeded job, and the error of a failed one.
+	Items []BatchItem
+	Less  []BatchLessItem
+	Err   error
+
+	Created, Started, Finished time.Time
+}
+
+// JobConfig are the settings of the jobs.  The zero values get the
+// defaults, except for Timeout.
+type JobConfig struct {
+	// Workers is the number of jobs run at once, 4 by default.
+	Workers int
+
+	// QueueSize is the number of jobs that may wait for a worker, 100 by
+	// default.  Jobs submitted beyond it are rejected.
+	QueueSize int
+
+	// Retention is how long the finished jobs are kept, an hour by default.
+	Retention time.Duration
+
+	// MaxRetained is the number of finished jobs kept, 1000 by default.
+	// The oldest ones are removed first.
+	MaxRetained int
+
+	// Timeout is the time a job may spend computing, in place of the
+	// MaxCPUTime limit of requests.  Jobs are not limited if it is zero.
+	Timeout time.Duration
+}
+
+// Jobs runs the submitted jobs on a pool of workers.
+type Jobs struct {
+	service *FibService
+	cfg     JobConfig
+	queue   chan *job
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	now     func() time.Time
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	finished []*job // in the order they finished
+	closed   bool
+}
+
+type job struct {
+	Job
+	cancel context.CancelFunc
+}
+
+// NewJobs starts the workers running the jobs on the service.  The jobs
+// have the limits of the service, other than the time limit.
+func NewJobs(fs *FibService, cfg JobConfig) *Jobs {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = time.Hour
+	}
+	if cfg.MaxRetained <= 0 {
+		cfg.MaxRetained = 1000
+	}
+	svc := *fs
+	svc.limits.MaxCPUTime = cfg.Timeout
+
+	ctx, cancel := context.WithCancel(context.Background())
+	js := &Jobs{
+		service: &svc,
+		cfg:     cfg,
+		queue:   make(chan *job, cfg.QueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		now:     time.Now,
+		jobs:    make(map[string]*job),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		js.wg.Add(1)
+		go func() {
+			defer js.wg.Done()
+			for j := range js.queue {
+				js.run(j)
+			}
+		}()
+	}
+	return js
+}
+
+// Submit queues a job computing the batch, returning it in the queued
+// state.
+func (js *Jobs) Submit(ns []int, targets []uint64) (Job, error) {
+	if len(ns)+len(targets) == 0 {
+		return Job{}, errors.New("a job must have something to compute")
+	}
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.closed {
+		return Job{}, ErrJobsClosed
+	}
+	js.prune()
+	j := &job{Job: Job{ID: id, State: JobQueued, N: ns, Targets: targets, Created: js.now()}}
+	select {
+	case js.queue <- j:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	js.jobs[id] = j
+	return j.Job, nil
+}
+
+// Get returns the job, or ErrJobNotFound if there is no such job, or it
+// is no longer retained.
+func (js *Jobs) Get(id string) (Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.prune()
+	j, ok := js.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return j.Job, nil
+}
+
+// Cancel stops a queued or running job, and returns it.  A job that has
+// already finished is returned with ErrJobFinished.
+func (js *Jobs) Cancel(id string) (Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.prune()
+	j, ok := js.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	if j.State.Finished() {
+		return j.Job, ErrJobFinished
+	}
+	js.stop(j)
+	return j.Job, nil
+}
+
+// Shutdown cancels the jobs, and waits for the workers to stop, or until
+// the context is done.  No jobs may be submitted afterwards.
+func (js *Jobs) Shutdown(ctx context.Context) error {
+	js.mu.Lock()
+	if !js.closed {
+		js.closed = true
+		for _, j := range js.jobs {
+			if !j.State.Finished() {
+				js.stop(j)
+			}
+		}
+		close(js.queue)
+		js.cancel()
+	}
+	js.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		js.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Runs a job on a worker, unless it was cancelled while queued.
+func (js *Jobs) run(j *job) {
+	js.mu.Lock()
+	if j.State != JobQueued {
+		js.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(js.ctx)
+	defer cancel()
+	j.cancel = cancel
+	j.State = JobRunning
+	j.Started = js.now()
+	js.mu.Unlock()
+
+	ctx, span := tracer.Start(ctx, "FibService.Job",
+		trace.WithAttributes(attribute.String("job.id", j.ID)))
+	items, less, err := js.service.fibBatch(ctx, j.N, j.Targets, func(done, total int) {
+		js.mu.Lock()
+		j.Done, j.Total = done, total
+		js.mu.Unlock()
+	})
+	endSpan(span, err)
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	// A cancelled job has already been finished.
+	if j.State != JobRunning {
+		return
+	}
+	switch {
+	case err == nil:
+		j.State, j.Items, j.Less = JobSucceeded, items, less
+	case errors.Is(err, context.Canceled):
+		j.State, j.Err = JobCancelled, err
+	default:
+		j.State, j.Err = JobFailed, err
+	}
+	js.finish(j)
+}
+
+// Cancels a job that hasn't finished.  The lock must be held.
+func (js *Jobs) stop(j *job) {
+	if j.cancel != nil {
+		j.cancel()
+	}
+	j.State, j.Err = JobCancelled, context.Canceled
+	js.finish(j)
+}
+
+// Records a job as finished.  The lock must be held.
+func (js *Jobs) finish(j *job) {
+	j.Finished = js.now()
+	js.finished = append(js.finished, j)
+	js.prune()
+}
+
+// Removes the finished jobs beyond the retention limits.  The lock must
+// be held.
+func (js *Jobs) prune() {
+	cutoff := js.now().Add(-js.cfg.Retention)
+	i := 0
+	for ; i < len(js.finished); i++ {
+		j := js.finished[i]
+		if len(js.finished)-i <= js.cfg.MaxRetained && j.Finished.After(cutoff) {
+			break
+		}
+		delete(js.jobs, j.ID)
+	}
+	js.finished = js.finished[i:]
+}
+
+// Job IDs are random, so they can't be guessed.
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
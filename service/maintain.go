@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gdotgordon/fibsrv/store"
+)
+
+// The page size for walking the memos.
+const maintainPage = 1000
+
+// FibValue returns fib(n) computed without the store, as the reference
+// the memos are checked against.
+func FibValue(n int) (uint64, error) {
+	if n < 0 || n > MaxFibN {
+		return 0, fmt.Errorf("fib(%d) can't be represented", n)
+	}
+	var a, b uint64 = 0, 1
+	for i := 0; i < n; i++ {
+		a, b = b, a+b
+	}
+	return a, nil
+}
+
+// Mismatch is a memo whose value is not fib(n).  Want is zero if fib(n)
+// can't be represented.
+type Mismatch struct {
+	N    int
+	Got  uint64
+	Want uint64
+}
+
+// Walk calls f with every memo, in order of n.
+func (fs *FibService) Walk(ctx context.Context, f func(store.FibPair) error) error {
+	after := -1
+	for {
+		memos, err := fs.store.List(ctx, after, maintainPage)
+		if err != nil {
+			return err
+		}
+		for _, m := range memos {
+			if err := f(m); err != nil {
+				return err
+			}
+		}
+		if len(memos) < maintainPage {
+			return nil
+		}
+		after = memos[len(memos)-1].Num
+	}
+}
+
+// Verify checks every memo against fib(n), returning the number checked
+// and those that are wrong.  With fix, the wrong memos are replaced, or
+// removed if fib(n) can't be represented.
+func (fs *FibService) Verify(ctx context.Context, fix bool) (int, []Mismatch, error) {
+	cnt := 0
+	var bad []Mismatch
+	err := fs.Walk(ctx, func(m store.FibPair) error {
+		cnt++
+		want, err := FibValue(m.Num)
+		if err == nil && m.Value == want {
+			return nil
+		}
+		bad = append(bad, Mismatch{N: m.Num, Got: m.Value, Want: want})
+		return nil
+	})
+	if err != nil || !fix {
+		return cnt, bad, err
+	}
+
+	// The memos are only written if missing, so the wrong ones are
+	// removed first.
+	for _, mm := range bad {
+		if _, err := fs.store.ClearRange(ctx, mm.N, mm.N, false); err != nil {
+			return cnt, bad, err
+		}
+		if mm.N <= MaxFibN {
+			if err := fs.store.Memoize(ctx, mm.N, mm.Want); err != nil {
+				return cnt, bad, err
+			}
+		}
+	}
+	return cnt, bad, nil
+}
+
+// Import memoizes the pairs, such as those exported from another store.
+// Every pair is checked before any is written, so a wrong value rejects
+// the whole import.  The memos already present are kept.
+func (fs *FibService) Import(ctx context.Context, pairs []store.FibPair) error {
+	for _, p := range pairs {
+		want, err := FibValue(p.Num)
+		if err != nil {
+			return err
+		}
+		if p.Value != want {
+			return fmt.Errorf("invalid memo: fib(%d) is %d, not %d", p.Num, want, p.Value)
+		}
+	}
+	for _, p := range pairs {
+		if err := fs.store.Memoize(ctx, p.Num, p.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package service
+
+// The queue workers run the jobs of a durable queue shared by any number of
+// instances.  Each job precomputes the memos for a range of n.  A worker
+// holds a lease on its job, which it renews while the job runs, so the job
+// is taken over by another worker if this one dies.  Failed jobs are
+// retried with a backoff, until they run out of attempts and are left in
+// the queue as dead letters.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// QueueSpec is the payload of a queued job, the range of n to precompute.
+type QueueSpec struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// QueueConfig are the settings of the queue workers.  The zero values get
+// the defaults, except for Timeout.
+type QueueConfig struct {
+	// Owner identifies the instance in the leases, and is required.
+	Owner string
+
+	// Workers is the number of jobs run at once, 4 by default.
+	Workers int
+
+	// Lease is how long a job is held without being renewed, a minute by
+	// default.  It is renewed at a third of that while the job runs.
+	Lease time.Duration
+
+	// PollInterval is how often an idle worker looks for a job, a second
+	// by default.
+	PollInterval time.Duration
+
+	// Backoff is the delay before the first retry of a failed job, five
+	// seconds by default, doubling with each further attempt up to an
+	// hour.
+	Backoff time.Duration
+
+	// Timeout is the time a job may spend computing, in place of the
+	// MaxCPUTime limit of requests.  Jobs are not limited if it is zero.
+	Timeout time.Duration
+}
+
+// The longest delay before a retry.
+const maxBackoff = time.Hour
+
+// Enqueue validates the spec against the limits of the service, as for
+// computing fib(To) now, and adds a job for it to the queue.
+func (fs *FibService) Enqueue(ctx context.Context, q store.Queue, spec QueueSpec, maxAttempts int) (int64, error) {
+	if spec.From < 0 || spec.To < spec.From {
+		return 0, fmt.Errorf("invalid range: [%d, %d]", spec.From, spec.To)
+	}
+	if maxAttempts <= 0 {
+		return 0, fmt.Errorf("invalid maximum attempts: %d", maxAttempts)
+	}
+	if err := fs.CheckFib(ctx, spec.To); err != nil {
+		return 0, err
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return 0, err
+	}
+	return q.Enqueue(ctx, b, maxAttempts)
+}
+
+// QueueWorkers take the jobs from the queue and run them.
+type QueueWorkers struct {
+	service *FibService
+	queue   store.Queue
+	log     *zap.SugaredLogger
+	cfg     QueueConfig
+}
+
+// NewQueueWorkers returns the workers running the queued jobs on the
+// service.  The jobs have the limits of the service, other than the time
+// limit.
+func NewQueueWorkers(fs *FibService, q store.Queue, log *zap.SugaredLogger, cfg QueueConfig) *QueueWorkers {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = time.Minute
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 5 * time.Second
+	}
+	svc := *fs
+	svc.limits.MaxCPUTime = cfg.Timeout
+	return &QueueWorkers{service: &svc, queue: q, log: log, cfg: cfg}
+}
+
+// Run runs the workers until the context is done.  The jobs still running
+// then are returned to the queue, for another worker to run.
+func (qw *QueueWorkers) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < qw.cfg.Workers; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			qw.work(ctx, owner)
+		}(fmt.Sprintf("%s/%d", qw.cfg.Owner, i))
+	}
+	wg.Wait()
+}
+
+// Claims and runs jobs, polling while the queue is empty.  Each worker is
+// a separate owner, so a job can only be finished by the worker holding it.
+func (qw *QueueWorkers) work(ctx context.Context, owner string) {
+	for ctx.Err() == nil {
+		job, ok, err := qw.queue.Claim(ctx, owner, qw.cfg.Lease)
+		if err != nil && ctx.Err() == nil {
+			qw.log.Errorw("claiming a job", "owner", owner, "error", err)
+		}
+		if ok {
+			qw.run(ctx, owner, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(qw.cfg.PollInterval):
+		}
+	}
+}
+
+// Runs a job under its lease, and records the outcome.
+func (qw *QueueWorkers) run(ctx context.Context, owner string, job store.QueuedJob) {
+	log := qw.log.With("job", job.ID, "attempt", job.Attempts, "owner", owner)
+	ctx, span := tracer.Start(ctx, "FibService.QueuedJob",
+		trace.WithAttributes(attribute.Int64("job.id", job.ID), attribute.Int("job.attempt", job.Attempts)))
+	err := qw.runLeased(ctx, owner, job)
+	endSpan(span, err)
+
+	// The outcome is recorded even if the workers are stopping.
+	octx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var le *LimitError
+	var spe specError
+	switch {
+	case err == nil:
+		err = qw.queue.Complete(octx, job.ID, owner)
+		log.Infow("Job done")
+	case errors.Is(err, store.ErrLeaseLost):
+		log.Warnw("Job lease lost")
+		return
+	case ctx.Err() != nil:
+		err = qw.queue.Release(octx, job.ID, owner)
+		log.Infow("Job released")
+	case errors.As(err, &le) || errors.As(err, &spe):
+		// Retrying can't help with these.
+		log.Warnw("Job dead-lettered", "error", err)
+		err = qw.queue.DeadLetter(octx, job.ID, owner, err.Error())
+	default:
+		state, ferr := qw.queue.Fail(octx, job.ID, owner, err.Error(), qw.backoff(job.Attempts))
+		log.Warnw("Job failed", "error", err, "state", state)
+		err = ferr
+	}
+	if err != nil {
+		log.Errorw("recording the job outcome", "error", err)
+	}
+}
+
+// Runs the job, renewing its lease until it is done.  The job is cancelled
+// if the lease is lost.
+func (qw *QueueWorkers) runLeased(ctx context.Context, owner string, job store.QueuedJob) error {
+	var spec QueueSpec
+	if err := json.Unmarshal(job.Payload, &spec); err != nil {
+		return specError{err}
+	}
+	if spec.From < 0 || spec.To < spec.From {
+		return specError{fmt.Errorf("invalid range: [%d, %d]", spec.From, spec.To)}
+	}
+	if err := qw.service.checkN(spec.To); err != nil {
+		return err
+	}
+
+	jctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lost := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(qw.cfg.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jctx.Done():
+				return
+			case <-ticker.C:
+				if err := qw.queue.Extend(jctx, job.ID, owner, qw.cfg.Lease); errors.Is(err, store.ErrLeaseLost) {
+					lost <- err
+					cancel()
+					return
+				} else if err != nil && jctx.Err() == nil {
+					qw.log.Errorw("renewing a job lease", "job", job.ID, "error", err)
+				}
+			}
+		}
+	}()
+
+	// The range is within the limits, so no item fails on its own.
+	ns := make([]int, 0, spec.To-spec.From+1)
+	for n := spec.From; n <= spec.To; n++ {
+		ns = append(ns, n)
+	}
+	_, _, err := qw.service.fibBatch(jctx, ns, nil, nil)
+	cancel()
+	select {
+	case err := <-lost:
+		return err
+	default:
+	}
+	return err
+}
+
+// The delay before retrying after the attempt.
+func (qw *QueueWorkers) backoff(attempt int) time.Duration {
+	d := qw.cfg.Backoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// A job whose payload is invalid.
+type specError struct {
+	err error
+}
+
+func (e specError) Error() string {
+	return "invalid job: " + e.err.Error()
+}
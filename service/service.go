@@ -11,16 +11,40 @@ import (
 	"fmt"
 
 	"github.com/gdotgordon/fibsrv/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// The tracer picks up the global provider, whenever it is installed.
+var tracer = otel.Tracer("github.com/gdotgordon/fibsrv/service")
+
 // FibService implements the fib service
 type FibService struct {
-	store store.Store
+	store         store.Store
+	cacheObserver func(hit bool)
+	limits        Limits
+}
+
+// Option is an optional setting for the service.
+type Option func(*FibService)
+
+// WithCacheObserver sets a function called with the result of every
+// memo lookup made by Fib, such as for gathering cache hit metrics.
+func WithCacheObserver(obs func(hit bool)) Option {
+	return func(fs *FibService) {
+		fs.cacheObserver = obs
+	}
 }
 
 // NewFib returns a new Fibonacci service
-func NewFib(store store.Store) (*FibService, error) {
-	return &FibService{store: store}, nil
+func NewFib(store store.Store, opts ...Option) (*FibService, error) {
+	fs := &FibService{store: store}
+	for _, opt := range opts {
+		opt(fs)
+	}
+	return fs, nil
 }
 
 // Fib gets the Fibonacci value for a number, returns an error if one occurs.
@@ -29,14 +53,38 @@ func NewFib(store store.Store) (*FibService, error) {
 // a database entry to exist for every value 0 to n.  Some may have been
 // prsnt from before, some not.
 func (fs *FibService) Fib(ctx context.Context, n int) (uint64, error) {
+	ctx, span := tracer.Start(ctx, "FibService.Fib",
+		trace.WithAttributes(attribute.Int("fib.n", n)))
+	var res uint64
+	err := fs.checkCost(ctx, n, func() (Cost, error) { return fs.EstimateFib(ctx, n) })
+	if err == nil {
+		err = fs.withTimeLimit(ctx, n, func(ctx context.Context) error {
+			var err error
+			res, err = fs.fib(ctx, n)
+			return err
+		})
+	}
+	endSpan(span, err)
+	return res, err
+}
+
+// The recursion for Fib.  There is no span for each level of recursion,
+// rather the store spans show where the time went.
+func (fs *FibService) fib(ctx context.Context, n int) (uint64, error) {
 	if n < 0 {
 		return 0, fmt.Errorf("invalid fibonacci request: %d", n)
 	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
 	val, ok, err := fs.store.Memo(ctx, n)
 	if err != nil {
 		return 0, err
 	}
+	if fs.cacheObserver != nil {
+		fs.cacheObserver(ok)
+	}
 	if ok {
 		return val, nil
 	}
@@ -55,11 +103,11 @@ func (fs *FibService) Fib(ctx context.Context, n int) (uint64, error) {
 
 	// This looks somewhat different than the standard fibonacci
 	// recursion due to having to check for errors.
-	f1, err := fs.Fib(ctx, n-1)
+	f1, err := fs.fib(ctx, n-1)
 	if err != nil {
 		return 0, err
 	}
-	f2, err := fs.Fib(ctx, n-2)
+	f2, err := fs.fib(ctx, n-2)
 	if err != nil {
 		return 0, err
 	}
@@ -84,25 +132,76 @@ func (fs *FibService) Fib(ctx context.Context, n int) (uint64, error) {
 // However, due to errors or other changes in the database, it makes the code too
 // fragile to depend on this.
 func (fs *FibService) FibLess(ctx context.Context, target uint64) (int, error) {
+	ctx, span := tracer.Start(ctx, "FibService.FibLess",
+		trace.WithAttributes(attribute.Int64("fib.target", int64(target))))
+	var res int
+	n := fibIndex(target)
+	err := fs.checkCost(ctx, n, func() (Cost, error) { return fs.EstimateFibLess(ctx, target) })
+	if err == nil {
+		err = fs.withTimeLimit(ctx, n, func(ctx context.Context) error {
+			var err error
+			res, err = fs.fibLess(ctx, target)
+			return err
+		})
+	}
+	endSpan(span, err)
+	return res, err
+}
+
+func (fs *FibService) fibLess(ctx context.Context, target uint64) (int, error) {
 
 	// Keep computing fib(n) until we have a big enough value.  If
-	// the cache is well populated, this should perform well.
-	for n := 0; ; n++ {
-		res, err := fs.Fib(ctx, n)
+	// the cache is well populated, this should perform well.  A target
+	// beyond fib(MaxFibN) is larger than every memo.
+	for n := 0; n <= MaxFibN; n++ {
+		res, err := fs.fib(ctx, n)
 		if err != nil {
 			return 0, err
 		}
 		if res >= target {
-			cnt, err := fs.store.MemoCount(ctx, target)
-			if err != nil {
-				return 0, err
-			}
-			return cnt, nil
+			break
 		}
 	}
+	return fs.store.MemoCount(ctx, target)
+}
+
+// MemoDetail returns the memo for n along with its metadata.  The bool
+// return is false if n has not been memoized.
+func (fs *FibService) MemoDetail(ctx context.Context, n int) (store.FibPair, bool, error) {
+	if n < 0 {
+		return store.FibPair{}, false, fmt.Errorf("invalid memo request: %d", n)
+	}
+	return fs.store.MemoDetail(ctx, n)
+}
+
+// List returns up to limit memos in order of n, starting after the given n.
+// Pass -1 for after to start from the beginning.
+func (fs *FibService) List(ctx context.Context, after, limit int) ([]store.FibPair, error) {
+	if after < -1 || limit <= 0 {
+		return nil, fmt.Errorf("invalid list request: after %d, limit %d", after, limit)
+	}
+	return fs.store.List(ctx, after, limit)
 }
 
 // Clear clears all rows of the store.
 func (fs *FibService) Clear(ctx context.Context) error {
 	return fs.store.Clear(ctx)
 }
+
+// ClearRange clears the memos for fibonacci numbers in the range [from, to].
+// With dryRun set, it only reports how many memos would be removed.
+func (fs *FibService) ClearRange(ctx context.Context, from, to int, dryRun bool) (int, error) {
+	if from < 0 || to < from {
+		return 0, fmt.Errorf("invalid clear range: [%d, %d]", from, to)
+	}
+	return fs.store.ClearRange(ctx, from, to, dryRun)
+}
+
+// Ends a span, recording the error if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -182,6 +182,12 @@ func (ns NeverStore) Memoize(ctx context.Context, n int, value uint64) error {
 	ns.vals[n] = value
 	return nil
 }
+func (ns NeverStore) MemoDetail(context.Context, int) (store.FibPair, bool, error) {
+	return store.FibPair{}, false, nil
+}
+func (ns NeverStore) List(context.Context, int, int) ([]store.FibPair, error) {
+	return nil, nil
+}
 func (ns NeverStore) FindLess(context.Context, uint64) (*store.FibPair, error) {
 	return nil, nil
 }
@@ -192,6 +198,12 @@ func (ns NeverStore) Clear(context.Context) error {
 	ns.vals = make(map[int]uint64)
 	return nil
 }
+func (ns NeverStore) ClearRange(context.Context, int, int, bool) (int, error) {
+	return 0, nil
+}
+func (ns NeverStore) CountRange(context.Context, int, int) (int, error) {
+	return 0, nil
+}
 
 func newDebugLogger() *zap.SugaredLogger {
 	config := zap.NewProductionConfig()
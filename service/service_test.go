@@ -2,9 +2,13 @@ package service
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gdotgordon/fibsrv/store"
+	"go.uber.org/zap"
 )
 
 // Testing computing fibonacci values using a mock hash store.
@@ -81,3 +85,471 @@ func TestFibLess(t *testing.T) {
 		}
 	}
 }
+
+// Testing clearing ranges of memos, including dry runs.
+func TestClearRange(t *testing.T) {
+	ctx := context.Background()
+	svc, err := NewFib(store.NewMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Fib(ctx, 20); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []struct {
+		from   int
+		to     int
+		dryRun bool
+		result int
+	}{
+		{from: 5, to: 9, dryRun: true, result: 5},
+		{from: 5, to: 9, result: 5},
+		{from: 5, to: 9, result: 0},
+		{from: 0, to: 100, dryRun: true, result: 16},
+		{from: 18, to: 18, result: 1},
+		{from: 0, to: 100, result: 15},
+	} {
+		res, err := svc.ClearRange(ctx, v.from, v.to, v.dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != v.result {
+			t.Fatalf("%d: clear [%d, %d], expected %d, got %d", i, v.from, v.to, v.result, res)
+		}
+	}
+	if _, err := svc.ClearRange(ctx, 10, 5, false); err == nil {
+		t.Fatal("expected error for inverted range")
+	}
+}
+
+// Testing the memo metadata, using a mock hash store.
+func TestMemoDetail(t *testing.T) {
+	ctx := context.Background()
+	svc, err := NewFib(store.NewMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := svc.MemoDetail(ctx, 5); err != nil || ok {
+		t.Fatalf("expected no memo before computing, got %v, %v", ok, err)
+	}
+	if _, err := svc.Fib(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Fib(ctx, 6); err != nil {
+		t.Fatal(err)
+	}
+	d, ok, err := svc.MemoDetail(ctx, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected memo for 6")
+	}
+	if d.Num != 6 || d.Value != 8 || d.CreatedAt.IsZero() {
+		t.Fatalf("unexpected memo detail: %+v", d)
+	}
+	if d.HitCount == 0 || d.LastAccessedAt == nil {
+		t.Fatalf("expected memo hits to be recorded: %+v", d)
+	}
+}
+
+// Testing paging through the memos in order, using a mock hash store.
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	svc, err := NewFib(store.NewMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Fib(ctx, 20); err != nil {
+		t.Fatal(err)
+	}
+	after, pages := -1, 0
+	for {
+		memos, err := svc.List(ctx, after, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(memos) == 0 {
+			break
+		}
+		for i, m := range memos {
+			if m.Num != after+i+1 {
+				t.Fatalf("page %d: expected memo %d, got %d", pages, after+i+1, m.Num)
+			}
+		}
+		after = memos[len(memos)-1].Num
+		pages++
+	}
+	if after != 20 || pages != 4 {
+		t.Fatalf("expected 4 pages ending at 20, got %d ending at %d", pages, after)
+	}
+	if _, err := svc.List(ctx, -1, 0); err == nil {
+		t.Fatal("expected error for zero limit")
+	}
+}
+
+// Tests requests beyond the limits are rejected before any memos are stored.
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMap()
+	svc, err := NewFib(st, WithLimits(Limits{
+		MaxN:       80,
+		MaxWrites:  30,
+		MaxCPUTime: 150 * time.Millisecond,
+		OpCost:     2 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []struct {
+		n     int
+		limit string
+	}{
+		{n: 94, limit: LimitRange},
+		{n: 81, limit: LimitMaxN},
+		{n: 40, limit: LimitWrites},
+		{n: 29, limit: LimitCPUTime}, // 30 writes and 61 lookups at 2ms
+		{n: 20, limit: ""},
+		{n: 29, limit: ""}, // 9 writes and 19 lookups
+
+	} {
+		before, _ := st.CountRange(ctx, 0, MaxFibN)
+		_, err := svc.Fib(ctx, v.n)
+		if v.limit == "" {
+			if err != nil {
+				t.Fatalf("%d: fib(%d): %v", i, v.n, err)
+			}
+			continue
+		}
+		var le *LimitError
+		if !errors.As(err, &le) || le.Limit != v.limit {
+			t.Fatalf("%d: fib(%d): expected %s limit error, got %v", i, v.n, v.limit, err)
+		}
+		if le.OutOfRange() != (v.limit == LimitRange) {
+			t.Fatalf("%d: unexpected out of range for %s", i, v.limit)
+		}
+		if after, _ := st.CountRange(ctx, 0, MaxFibN); after != before {
+			t.Fatalf("%d: expected no memos stored, %d before and %d after", i, before, after)
+		}
+	}
+
+	// The target for FibLess is limited by the n it needs.
+	var le *LimitError
+	if _, err := svc.FibLess(ctx, 1<<62); !errors.As(err, &le) || le.Limit != LimitMaxN {
+		t.Fatalf("expected max n limit error, got %v", err)
+	}
+}
+
+// Tests the cost estimates follow the memo coverage.
+func TestEstimate(t *testing.T) {
+	ctx := context.Background()
+	svc, err := NewFib(store.NewMap(), WithLimits(Limits{OpCost: time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := svc.EstimateFib(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Writes != 11 || c.Lookups != 23 || c.Time != 34*time.Millisecond {
+		t.Fatalf("unexpected cold cost: %+v", c)
+	}
+	if _, err := svc.Fib(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = svc.EstimateFib(ctx, 12); c.Writes != 2 {
+		t.Fatalf("expected 2 writes, got %+v", c)
+	}
+	if c, _ = svc.EstimateFibLess(ctx, 55); c.N != 10 || c.Writes != 0 {
+		t.Fatalf("unexpected fibless cost: %+v", c)
+	}
+}
+
+// Tests a batch matches the individual requests, with per item errors.
+func TestFibBatch(t *testing.T) {
+	ctx := context.Background()
+	lookups := 0
+	svc, err := NewFib(store.NewMap(), WithLimits(Limits{MaxN: 90}),
+		WithCacheObserver(func(bool) { lookups++ }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, less, err := svc.FibBatch(ctx, []int{20, 5, -1, 20, 91, 94, 0}, []uint64{120, 1 << 63})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []struct {
+		value uint64
+		limit string
+		err   bool
+	}{
+		{value: 6765}, {value: 5}, {err: true}, {value: 6765},
+		{limit: LimitMaxN, err: true}, {limit: LimitRange, err: true}, {value: 0},
+	} {
+		it := items[i]
+		if (it.Err != nil) != v.err || it.Value != v.value {
+			t.Fatalf("%d: unexpected result for fib(%d): %d, %v", i, it.N, it.Value, it.Err)
+		}
+		var le *LimitError
+		if v.limit != "" && (!errors.As(it.Err, &le) || le.Limit != v.limit) {
+			t.Fatalf("%d: expected %s limit error, got %v", i, v.limit, it.Err)
+		}
+	}
+	if less[0].Err != nil || less[0].Count != 12 {
+		t.Fatalf("expected 12 memos less than 120, got %+v", less[0])
+	}
+	if less[1].Err == nil {
+		t.Fatal("expected an error for a target beyond the maximum n")
+	}
+
+	// One pass reads each memo from 0 to 20 once.
+	if lookups != 21 {
+		t.Fatalf("expected 21 memo lookups, got %d", lookups)
+	}
+}
+
+// A store whose memo lookups wait for the gate to open.
+type gatedStore struct {
+	store.Store
+	gate chan struct{}
+}
+
+func (gs gatedStore) Memo(ctx context.Context, n int) (uint64, bool, error) {
+	select {
+	case <-gs.gate:
+		return gs.Store.Memo(ctx, n)
+	case <-ctx.Done():
+		return 0, false, ctx.Err()
+	}
+}
+
+// Waits for the job to reach the state.
+func waitJob(t *testing.T, js *Jobs, id string, state JobState) Job {
+	for i := 0; i < 1000; i++ {
+		j, err := js.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.State == state {
+			return j
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach the %s state", id, state)
+	return Job{}
+}
+
+func TestJobs(t *testing.T) {
+	gate := make(chan struct{})
+	svc, err := NewFib(gatedStore{Store: store.NewMap(), gate: gate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := NewJobs(svc, JobConfig{Workers: 1, QueueSize: 1, MaxRetained: 2})
+
+	// The first job holds the worker, and the second fills the queue.
+	first, err := js.Submit([]int{20}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, js, first.ID, JobRunning)
+	second, err := js.Submit([]int{10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.Submit([]int{5}, nil); err != ErrQueueFull {
+		t.Fatalf("expected a full queue, got %v", err)
+	}
+	if j, err := js.Cancel(first.ID); err != nil || j.State != JobCancelled {
+		t.Fatalf("expected the job to be cancelled, got %v, %v", j.State, err)
+	}
+	if _, err := js.Cancel(first.ID); err != ErrJobFinished {
+		t.Fatalf("expected the job to be finished, got %v", err)
+	}
+
+	close(gate)
+	j := waitJob(t, js, second.ID, JobSucceeded)
+	if j.Items[0].Value != 55 || j.Done != 11 || j.Total != 11 || j.Started.IsZero() || j.Finished.IsZero() {
+		t.Fatalf("unexpected job: %+v", j)
+	}
+
+	// Only the last two finished jobs are retained.
+	third, err := js.Submit([]int{30}, []uint64{120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j = waitJob(t, js, third.ID, JobSucceeded)
+	if j.Items[0].Value != 832040 || j.Less[0].Count != 12 {
+		t.Fatalf("unexpected job: %+v", j)
+	}
+	if _, err := js.Get(first.ID); err != ErrJobNotFound {
+		t.Fatalf("expected the first job to be removed, got %v", err)
+	}
+
+	if err := js.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.Submit([]int{5}, nil); err != ErrJobsClosed {
+		t.Fatalf("expected the jobs to be closed, got %v", err)
+	}
+}
+
+// A store whose memo lookups fail a number of times.
+type failingStore struct {
+	store.Store
+	mu    sync.Mutex
+	fails int
+}
+
+func (fs *failingStore) Memo(ctx context.Context, n int) (uint64, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.fails > 0 {
+		fs.fails--
+		return 0, false, errors.New("lookup failed")
+	}
+	return fs.Store.Memo(ctx, n)
+}
+
+// Tests the queued jobs are run, retried, and dead-lettered.
+func TestQueueWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	st := store.NewMap()
+	q := st.(store.Queue)
+	svc, err := NewFib(&failingStore{Store: st, fails: 1}, WithLimits(Limits{MaxN: 50}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retried, err := svc.Enqueue(ctx, q, QueueSpec{From: 10, To: 30}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Enqueue(ctx, q, QueueSpec{From: 0, To: 60}, 2); err == nil {
+		t.Fatal("expected a range over the maximum n to be rejected")
+	}
+	limited, err := NewFib(st, WithLimits(Limits{MaxWrites: 5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var le *LimitError
+	if _, err := limited.Enqueue(ctx, q, QueueSpec{From: 40, To: 45}, 2); !errors.As(err, &le) || le.Limit != LimitWrites {
+		t.Fatalf("expected a range over the maximum writes to be rejected, got %v", err)
+	}
+	overLimit, err := q.Enqueue(ctx, []byte(`{"from": 0, "to": 60}`), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid, err := q.Enqueue(ctx, []byte(`{"from": "zero"}`), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qw := NewQueueWorkers(svc, q, zap.NewNop().Sugar(), QueueConfig{Owner: "test", Workers: 1,
+		Lease: 30 * time.Millisecond, PollInterval: time.Millisecond, Backoff: time.Millisecond})
+	done := make(chan struct{})
+	go func() {
+		qw.Run(ctx)
+		close(done)
+	}()
+	for i, v := range []struct {
+		id       int64
+		state    string
+		attempts int
+	}{
+		{retried, store.QueueDone, 2},
+		{overLimit, store.QueueDead, 1},
+		{invalid, store.QueueDead, 1},
+	} {
+		var j store.QueuedJob
+		for k := 0; k < 1000; k++ {
+			if j, _, _ = q.QueuedJob(ctx, v.id); j.State == v.state {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if j.State != v.state || j.Attempts != v.attempts {
+			t.Fatalf("%d: expected the job to be %s after %d attempts, got %+v", i, v.state, v.attempts, j)
+		}
+	}
+	if val, ok, _ := st.Memo(ctx, 30); !ok || val != 832040 {
+		t.Fatalf("expected fib(30) to be memoized, got %d", val)
+	}
+	cancel()
+	<-done
+}
+
+// Tests the memos are checked against the sequence, and repaired.
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMap()
+	svc, err := NewFib(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := FibValue(MaxFibN); err != nil || v != 12200160415121876738 {
+		t.Fatalf("unexpected fib(%d): %d %v", MaxFibN, v, err)
+	}
+	if _, err := FibValue(MaxFibN + 1); err == nil {
+		t.Fatal("expected fib(94) to be out of range")
+	}
+
+	if _, err := svc.Fib(ctx, 30); err != nil {
+		t.Fatal(err)
+	}
+	cnt, bad, err := svc.Verify(ctx, false)
+	if err != nil || cnt != 31 || len(bad) != 0 {
+		t.Fatalf("expected 31 good memos, got %d %v %v", cnt, bad, err)
+	}
+
+	// Corrupt memos, as if written by a broken instance.
+	st.ClearRange(ctx, 12, 12, false)
+	st.Memoize(ctx, 12, 1)
+	st.Memoize(ctx, 100, 7)
+	if _, bad, err = svc.Verify(ctx, true); err != nil || len(bad) != 2 ||
+		bad[0] != (Mismatch{N: 12, Got: 1, Want: 144}) || bad[1].N != 100 {
+		t.Fatalf("unexpected mismatches: %v %v", bad, err)
+	}
+	if cnt, bad, err = svc.Verify(ctx, false); err != nil || cnt != 31 || len(bad) != 0 {
+		t.Fatalf("expected the memos to be fixed, got %d %v %v", cnt, bad, err)
+	}
+}
+
+// Tests memos are imported only if they are all right.
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	src, dst := store.NewMap(), store.NewMap()
+	from, err := NewFib(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := NewFib(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := from.Fib(ctx, 20); err != nil {
+		t.Fatal(err)
+	}
+	var pairs []store.FibPair
+	if err := from.Walk(ctx, func(p store.FibPair) error {
+		pairs = append(pairs, p)
+		return nil
+	}); err != nil || len(pairs) != 21 {
+		t.Fatalf("expected 21 memos, got %d %v", len(pairs), err)
+	}
+
+	bad := append([]store.FibPair{{Num: 50, Value: 1}}, pairs...)
+	if err := to.Import(ctx, bad); err == nil {
+		t.Fatal("expected a wrong memo to be rejected")
+	}
+	if cnt, _ := dst.CountRange(ctx, 0, 100); cnt != 0 {
+		t.Fatalf("expected nothing imported, got %d memos", cnt)
+	}
+	if err := to.Import(ctx, pairs); err != nil {
+		t.Fatal(err)
+	}
+	if cnt, bad, err := to.Verify(ctx, false); err != nil || cnt != 21 || len(bad) != 0 {
+		t.Fatalf("unexpected import: %d %v %v", cnt, bad, err)
+	}
+}
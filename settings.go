@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gdotgordon/fibsrv/api"
+	"github.com/gdotgordon/fibsrv/config"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+)
+
+// The conversions of the configuration to the settings of the packages,
+// which the config package doesn't depend on.
+
+// The rate limits, without the store persisting the quotas.
+func rateLimitSettings(rl config.RateLimit) api.RateLimitConfig {
+	return api.RateLimitConfig{
+		Cached:     api.Limit{Rate: rl.CachedRate, Burst: rl.CachedBurst},
+		Cold:       api.Limit{Rate: rl.ColdRate, Burst: rl.ColdBurst},
+		DailyQuota: rl.DailyQuota,
+	}
+}
+
+func authSettings(a config.Auth) api.AuthConfig {
+	cfg := api.AuthConfig{
+		JWTIssuer:       a.JWTIssuer,
+		JWTAudience:     a.JWTAudience,
+		ClientCertRoles: a.ClientCertRoles,
+	}
+	if a.JWTSecret != "" {
+		cfg.JWTSecret = []byte(a.JWTSecret)
+	}
+	for _, k := range a.APIKeys {
+		cfg.APIKeys = append(cfg.APIKeys, api.APIKey{Name: k.Name, Hash: k.Hash, Roles: k.Roles})
+	}
+	return cfg
+}
+
+func snapshotSettings(s config.Store) store.SnapshotConfig {
+	return store.SnapshotConfig{
+		Path:     s.Snapshot,
+		Interval: time.Duration(s.SnapshotInterval),
+	}
+}
+
+func limitSettings(l config.Limits) service.Limits {
+	return service.Limits{
+		MaxN:       l.MaxN,
+		MaxWrites:  l.MaxWrites,
+		MaxCPUTime: time.Duration(l.MaxCPUTime),
+		OpCost:     time.Duration(l.OpCost),
+	}
+}
+
+func jobSettings(j config.Jobs) service.JobConfig {
+	return service.JobConfig{
+		Workers:     j.Workers,
+		QueueSize:   j.QueueSize,
+		Retention:   time.Duration(j.Retention),
+		MaxRetained: j.MaxRetained,
+		Timeout:     time.Duration(j.Timeout),
+	}
+}
+
+// The queue workers, with the owner of the leases and the job timeout.
+func queueSettings(q config.Queue, owner string, timeout config.Duration) service.QueueConfig {
+	return service.QueueConfig{
+		Owner:        owner,
+		Workers:      q.Workers,
+		Lease:        time.Duration(q.Lease),
+		PollInterval: time.Duration(q.PollInterval),
+		Backoff:      time.Duration(q.Backoff),
+		Timeout:      time.Duration(timeout),
+	}
+}
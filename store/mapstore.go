@@ -2,55 +2,376 @@ package store
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
 )
 
-// Compile time interface implementation check.
-var _ Store = (*MapStore)(nil)
+// Compile time interface implementation checks.
+var (
+	_ Store  = (*MapStore)(nil)
+	_ Quotas = (*MapStore)(nil)
+	_ Queue  = (*MapStore)(nil)
+)
 
-// MapStore is a hash map implementation of the store
+// MapStore is an in-memory implementation of the store.  The memos are
+// kept ordered by n, with an index of their values, so lookups, counts
+// and range scans are logarithmic.  Reads only take the read lock.  The
+// store may be persisted to snapshots, see NewSnapshotMap.
 type MapStore struct {
-	tab map[int]uint64
-	mu  sync.Mutex
+	// gen counts the changes, so that unchanged stores aren't written
+	// again, and reads counts the reads of the memos, which only change
+	// their hit counts.  They come first to be aligned for the atomic
+	// operations.
+	gen   uint64
+	reads uint64
+
+	memos   []*memo  // ordered by n
+	values  []uint64 // the values of the memos, in order
+	usage   map[string]dayUsage
+	queue   map[int64]*QueuedJob
+	lastJob int64
+	mu      sync.RWMutex
+
+	// The snapshots, if enabled.
+	snap       SnapshotConfig
+	log        *zap.SugaredLogger
+	saved      uint64 // the gen of the last snapshot
+	savedReads uint64 // and its reads
+	saveMu     sync.Mutex
+	stop       chan struct{}
+	stopOnce   sync.Once
+	stopped    chan struct{}
+	stopErr    error // of the last snapshot
+}
+
+// A memo, whose metadata is updated atomically on reads.
+type memo struct {
+	// hits and accessed, the last read in Unix nanoseconds or zero, come
+	// first to be aligned for the atomic operations.
+	hits     int64
+	accessed int64
+	pair     FibPair
 }
 
-// NewMap returns a new hash map store
+// Returns a copy of the memo with its metadata.
+func (m *memo) detail() FibPair {
+	p := m.pair
+	p.HitCount = atomic.LoadInt64(&m.hits)
+	if a := atomic.LoadInt64(&m.accessed); a != 0 {
+		t := time.Unix(0, a)
+		p.LastAccessedAt = &t
+	}
+	return p
+}
+
+// A client's usage is only kept for the latest day.
+type dayUsage struct {
+	day  string
+	used int
+}
+
+// NewMap returns a new in-memory store
 func NewMap() Store {
-	return &MapStore{tab: make(map[int]uint64)}
+	return &MapStore{usage: make(map[string]dayUsage), queue: make(map[int64]*QueuedJob)}
+}
+
+// Records a change, for the snapshots.
+func (ms *MapStore) changed() {
+	atomic.AddUint64(&ms.gen, 1)
+}
+
+// Returns the index of the first memo with n at least the given one.  The
+// lock must be held.
+func (ms *MapStore) search(n int) int {
+	return sort.Search(len(ms.memos), func(i int) bool { return ms.memos[i].pair.Num >= n })
+}
+
+// Returns the memo for n, or nil.  The lock must be held.
+func (ms *MapStore) find(n int) *memo {
+	if i := ms.search(n); i < len(ms.memos) && ms.memos[i].pair.Num == n {
+		return ms.memos[i]
+	}
+	return nil
+}
+
+// Returns the index of the first value at least the given one.  The lock
+// must be held.
+func (ms *MapStore) searchValue(v uint64) int {
+	return sort.Search(len(ms.values), func(i int) bool { return ms.values[i] >= v })
 }
 
 // Memo gets a memoized fibonacci value
 func (ms *MapStore) Memo(ctx context.Context, n int) (uint64, bool, error) {
-	ms.mu.Lock()
-	v, ok := ms.tab[n]
-	ms.mu.Unlock()
-	return v, ok, nil
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	m := ms.find(n)
+	if m == nil {
+		return 0, false, nil
+	}
+	atomic.StoreInt64(&m.accessed, time.Now().UnixNano())
+	atomic.AddInt64(&m.hits, 1)
+	atomic.AddUint64(&ms.reads, 1)
+	return m.pair.Value, true, nil
 }
 
-// Memoize stores a memoized value
+// Memoize stores a memoized value.  As with the database, an existing
+// memo is left untouched.
 func (ms *MapStore) Memoize(ctx context.Context, n int, val uint64) error {
 	ms.mu.Lock()
-	ms.tab[n] = val
-	ms.mu.Unlock()
+	defer ms.mu.Unlock()
+	i := ms.search(n)
+	if i < len(ms.memos) && ms.memos[i].pair.Num == n {
+		return nil
+	}
+	ms.memos = append(ms.memos, nil)
+	copy(ms.memos[i+1:], ms.memos[i:])
+	ms.memos[i] = &memo{pair: FibPair{Num: n, Value: val, CreatedAt: time.Now()}}
+
+	j := ms.searchValue(val)
+	ms.values = append(ms.values, 0)
+	copy(ms.values[j+1:], ms.values[j:])
+	ms.values[j] = val
+	ms.changed()
 	return nil
 }
 
-// MemoCount returns the number of memoizations whose value is less than or
-// equal to the target.
+// MemoDetail returns a copy of the memo and its metadata
+func (ms *MapStore) MemoDetail(ctx context.Context, n int) (FibPair, bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	m := ms.find(n)
+	if m == nil {
+		return FibPair{}, false, nil
+	}
+	return m.detail(), true, nil
+}
+
+// MemoCount returns the number of memoizations whose value is less than
+// the target.
 func (ms *MapStore) MemoCount(ctx context.Context, target uint64) (int, error) {
-	cnt := 0
-	for _, v := range ms.tab {
-		if v < target {
-			cnt++
-		}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.searchValue(target), nil
+}
+
+// List returns a page of memos in order of n.
+func (ms *MapStore) List(ctx context.Context, after, limit int) ([]FibPair, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	i := ms.search(after + 1)
+	end := len(ms.memos)
+	if limit < end-i {
+		end = i + limit
 	}
-	return cnt, nil
+	res := make([]FibPair, 0, end-i)
+	for _, m := range ms.memos[i:end] {
+		res = append(res, m.detail())
+	}
+	return res, nil
 }
 
 // Clear clears the map
 func (ms *MapStore) Clear(ctx context.Context) error {
 	ms.mu.Lock()
-	ms.tab = make(map[int]uint64)
+	ms.memos, ms.values = nil, nil
+	ms.changed()
 	ms.mu.Unlock()
 	return nil
 }
+
+// ClearRange removes the memos with n in the range [from, to], or just
+// counts them for a dry run.
+func (ms *MapStore) ClearRange(ctx context.Context, from, to int, dryRun bool) (int, error) {
+	if dryRun {
+		return ms.CountRange(ctx, from, to)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	i, j := ms.searchRange(from, to)
+	if i == j {
+		return 0, nil
+	}
+	for _, m := range ms.memos[i:j] {
+		k := ms.searchValue(m.pair.Value)
+		ms.values = append(ms.values[:k], ms.values[k+1:]...)
+	}
+	ms.memos = append(ms.memos[:i], ms.memos[j:]...)
+	ms.changed()
+	return j - i, nil
+}
+
+// CountRange returns the number of memos with n in the range [from, to].
+func (ms *MapStore) CountRange(ctx context.Context, from, to int) (int, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	i, j := ms.searchRange(from, to)
+	return j - i, nil
+}
+
+// Returns the indices of the memos from the first with n >= from, to the
+// first with n > to.  Must be called with the lock held.
+func (ms *MapStore) searchRange(from, to int) (int, int) {
+	if from > to {
+		return 0, 0
+	}
+	i := ms.search(from)
+	return i, i + sort.Search(len(ms.memos)-i, func(k int) bool { return ms.memos[i+k].pair.Num > to })
+}
+
+// AddUsage adds to a client's usage for a day, for quotas.
+func (ms *MapStore) AddUsage(ctx context.Context, key string, day time.Time, n int) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	d := day.UTC().Format("2006-01-02")
+	u := ms.usage[key]
+	if u.day != d {
+		u = dayUsage{day: d}
+	}
+	u.used += n
+	ms.usage[key] = u
+	ms.changed()
+	return u.used, nil
+}
+
+// Enqueue adds a job to the queue.
+func (ms *MapStore) Enqueue(ctx context.Context, payload []byte, maxAttempts int) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.lastJob++
+	now := time.Now()
+	ms.queue[ms.lastJob] = &QueuedJob{ID: ms.lastJob, Payload: payload, State: QueuePending,
+		MaxAttempts: maxAttempts, RunAt: now, CreatedAt: now, UpdatedAt: now}
+	ms.changed()
+	return ms.lastJob, nil
+}
+
+// Claim leases the next ready job, in the same order as the database.
+func (ms *MapStore) Claim(ctx context.Context, owner string, lease time.Duration) (QueuedJob, bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	var next *QueuedJob
+	for _, j := range ms.queue {
+		expired := j.State == QueueRunning && j.LeaseUntil.Before(now)
+		if expired && j.Attempts >= j.MaxAttempts {
+			ms.endJob(j, QueueDead, "lease expired on the last attempt")
+			continue
+		}
+		if !expired && (j.State != QueuePending || j.RunAt.After(now)) {
+			continue
+		}
+		if next == nil || j.RunAt.Before(next.RunAt) || (j.RunAt.Equal(next.RunAt) && j.ID < next.ID) {
+			next = j
+		}
+	}
+	if next == nil {
+		return QueuedJob{}, false, nil
+	}
+	until := now.Add(lease)
+	next.State, next.LeaseOwner, next.LeaseUntil = QueueRunning, owner, &until
+	next.Attempts++
+	next.UpdatedAt = now
+	ms.changed()
+	return *next, true, nil
+}
+
+// Extend renews the lease of a job.
+func (ms *MapStore) Extend(ctx context.Context, id int64, owner string, lease time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	j, err := ms.heldJob(id, owner)
+	if err != nil {
+		return err
+	}
+	until := time.Now().Add(lease)
+	j.LeaseUntil, j.UpdatedAt = &until, time.Now()
+	ms.changed()
+	return nil
+}
+
+// Complete marks a job as done.
+func (ms *MapStore) Complete(ctx context.Context, id int64, owner string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	j, err := ms.heldJob(id, owner)
+	if err != nil {
+		return err
+	}
+	ms.endJob(j, QueueDone, j.LastError)
+	return nil
+}
+
+// Fail records a failed attempt of a job.
+func (ms *MapStore) Fail(ctx context.Context, id int64, owner, cause string, delay time.Duration) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	j, err := ms.heldJob(id, owner)
+	if err != nil {
+		return "", err
+	}
+	state := QueuePending
+	if j.Attempts >= j.MaxAttempts {
+		state = QueueDead
+	}
+	ms.endJob(j, state, cause)
+	j.RunAt = time.Now().Add(delay)
+	return state, nil
+}
+
+// DeadLetter fails a job permanently.
+func (ms *MapStore) DeadLetter(ctx context.Context, id int64, owner, cause string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	j, err := ms.heldJob(id, owner)
+	if err != nil {
+		return err
+	}
+	ms.endJob(j, QueueDead, cause)
+	return nil
+}
+
+// Release returns a job to the queue.
+func (ms *MapStore) Release(ctx context.Context, id int64, owner string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	j, err := ms.heldJob(id, owner)
+	if err != nil {
+		return err
+	}
+	ms.endJob(j, QueuePending, j.LastError)
+	j.Attempts--
+	j.RunAt = time.Now()
+	return nil
+}
+
+// QueuedJob returns a copy of a job in the queue.
+func (ms *MapStore) QueuedJob(ctx context.Context, id int64) (QueuedJob, bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	j, ok := ms.queue[id]
+	if !ok {
+		return QueuedJob{}, false, nil
+	}
+	return *j, true, nil
+}
+
+// Returns a running job leased to the owner.  The lock must be held.
+func (ms *MapStore) heldJob(id int64, owner string) (*QueuedJob, error) {
+	j, ok := ms.queue[id]
+	if !ok || j.State != QueueRunning || j.LeaseOwner != owner {
+		return nil, ErrLeaseLost
+	}
+	return j, nil
+}
+
+// Ends the lease of a job, moving it to the state.  The lock must be held.
+func (ms *MapStore) endJob(j *QueuedJob, state, cause string) {
+	j.State, j.LastError = state, cause
+	j.LeaseOwner, j.LeaseUntil = "", nil
+	j.UpdatedAt = time.Now()
+	ms.changed()
+}
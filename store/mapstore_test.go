@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// Tests the order of the memos, and the counts and ranges over them.
+func TestMapStore(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMap()
+	fib := []uint64{0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55}
+	for _, n := range []int{7, 3, 10, 0, 5, 1, 2, 9, 4, 8, 6} {
+		if err := ms.Memoize(ctx, n, fib[n]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ms.Memoize(ctx, 5, 99); err != nil {
+		t.Fatal(err)
+	}
+
+	memos, err := ms.List(ctx, -1, 100)
+	if err != nil || len(memos) != len(fib) {
+		t.Fatalf("unexpected list: %v %v", memos, err)
+	}
+	for i, m := range memos {
+		if m.Num != i || m.Value != fib[i] {
+			t.Fatalf("%d: unexpected memo %+v", i, m)
+		}
+	}
+	if memos, _ := ms.List(ctx, 8, 5); len(memos) != 2 || memos[0].Num != 9 {
+		t.Fatalf("unexpected page: %v", memos)
+	}
+	if memos, _ := ms.List(ctx, 2, 3); len(memos) != 3 || memos[2].Num != 5 {
+		t.Fatalf("unexpected page: %v", memos)
+	}
+
+	for i, v := range []struct {
+		target uint64
+		cnt    int
+	}{
+		{0, 0}, {1, 1}, {2, 3}, {13, 7}, {14, 8}, {55, 10}, {1000, 11},
+	} {
+		if cnt, _ := ms.MemoCount(ctx, v.target); cnt != v.cnt {
+			t.Fatalf("%d: expected %d memos less than %d, got %d", i, v.cnt, v.target, cnt)
+		}
+	}
+
+	if _, ok, _ := ms.Memo(ctx, 4); !ok {
+		t.Fatal("expected memo 4")
+	}
+	if m, ok, _ := ms.MemoDetail(ctx, 4); !ok || m.HitCount != 1 || m.LastAccessedAt == nil {
+		t.Fatalf("unexpected detail: %+v", m)
+	}
+
+	if cnt, _ := ms.CountRange(ctx, 2, 5); cnt != 4 {
+		t.Fatalf("expected 4 memos in range, got %d", cnt)
+	}
+	if cnt, _ := ms.CountRange(ctx, 9, 20); cnt != 2 {
+		t.Fatalf("expected 2 memos in range, got %d", cnt)
+	}
+	if cnt, _ := ms.ClearRange(ctx, 2, 5, true); cnt != 4 {
+		t.Fatalf("expected 4 memos to clear, got %d", cnt)
+	}
+	if cnt, _ := ms.ClearRange(ctx, 2, 5, false); cnt != 4 {
+		t.Fatalf("expected 4 memos cleared, got %d", cnt)
+	}
+	if cnt, _ := ms.MemoCount(ctx, 14); cnt != 4 {
+		t.Fatalf("expected 4 memos less than 14, got %d", cnt)
+	}
+	if _, ok, _ := ms.Memo(ctx, 3); ok {
+		t.Fatal("expected memo 3 to be cleared")
+	}
+	if cnt, _ := ms.ClearRange(ctx, 20, 10, false); cnt != 0 {
+		t.Fatalf("expected an empty range, got %d", cnt)
+	}
+	if err := ms.Clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if memos, _ := ms.List(ctx, -1, 100); len(memos) != 0 {
+		t.Fatalf("expected no memos, got %v", memos)
+	}
+}
+
+// Run with -race, to check the reads and writes are synchronized.
+func TestMapStoreConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMap()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				n := (i*7 + w) % 50
+				ms.Memoize(ctx, n, uint64(n))
+				ms.Memo(ctx, n)
+				ms.MemoDetail(ctx, n)
+				ms.MemoCount(ctx, uint64(n))
+				ms.List(ctx, n-10, 10)
+				if i%50 == 0 {
+					ms.ClearRange(ctx, n, n+5, w%2 == 0)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	memos, _ := ms.List(ctx, -1, 100)
+	cnt, _ := ms.MemoCount(ctx, 50)
+	if cnt != len(memos) {
+		t.Fatalf("the value index has %d memos, the list %d", cnt, len(memos))
+	}
+	for i := 1; i < len(memos); i++ {
+		if memos[i-1].Num >= memos[i].Num {
+			t.Fatalf("memos out of order: %v", memos)
+		}
+	}
+}
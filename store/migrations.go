@@ -0,0 +1,116 @@
+package store
+
+// The Postgres schema is versioned, and upgraded at startup by applying
+// each migration newer than the version recorded in the database.
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// the single row table holding the current schema version
+	createVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER NOT NULL
+	);`
+
+	// serializes migrations run by concurrently starting instances
+	migrationLock = `SELECT pg_advisory_xact_lock(4242);`
+)
+
+// migrations are the schema changes in order.  The version of the schema
+// is the number of migrations applied, so entries may only be appended.
+var migrations = []string{
+	// 1: the table has the "n" of fib(n) as the primary key, and the value
+	// of fib(n) stored as a big integer.
+	`CREATE TABLE IF NOT EXISTS fibtab (
+	num INTEGER PRIMARY KEY,
+	value BIGINT
+	);`,
+
+	// 2: memo metadata: when and by which instance it was written, and
+	// how often and when it was last read.
+	`ALTER TABLE fibtab
+	ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	ADD COLUMN IF NOT EXISTS last_accessed_at TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS hit_count BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS writer_id TEXT NOT NULL DEFAULT '';`,
+
+	// 3: the daily request usage of each client, for quotas.
+	`CREATE TABLE IF NOT EXISTS quota_usage (
+	client TEXT NOT NULL,
+	day DATE NOT NULL,
+	used INTEGER NOT NULL,
+	PRIMARY KEY (client, day)
+	);`,
+
+	// 4: the durable job queue.  Workers claim ready jobs with SKIP LOCKED,
+	// holding a lease while they run them.
+	`CREATE TABLE IF NOT EXISTS job_queue (
+	id BIGSERIAL PRIMARY KEY,
+	payload JSONB NOT NULL,
+	state TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	lease_owner TEXT NOT NULL DEFAULT '',
+	lease_until TIMESTAMPTZ,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS job_queue_ready ON job_queue (run_at, id)
+	WHERE state IN ('pending', 'running');`,
+}
+
+// LatestSchemaVersion is the version of the schema this build migrates
+// the database to.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// migrate brings the schema up to date, returning the resulting version.
+func migrate(ctx context.Context, db *sqlx.DB) (int, error) {
+	if _, err := db.ExecContext(ctx, createVersionTable); err != nil {
+		return 0, err
+	}
+	for {
+		ver, done, err := migrateOne(ctx, db)
+		if err != nil || done {
+			return ver, err
+		}
+	}
+}
+
+// Applies the next migration, if any, in its own transaction.
+func migrateOne(ctx context.Context, db *sqlx.DB) (int, bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migrationLock); err != nil {
+		return 0, false, err
+	}
+	var ver int
+	if err := tx.GetContext(ctx, &ver,
+		`SELECT coalesce(max(version), 0) FROM schema_version;`); err != nil {
+		return 0, false, err
+	}
+	if ver >= len(migrations) {
+		return ver, true, nil
+	}
+	if _, err := tx.ExecContext(ctx, migrations[ver]); err != nil {
+		return ver, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_version;`); err != nil {
+		return ver, false, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_version (version) VALUES ($1);`, ver+1); err != nil {
+		return ver, false, err
+	}
+	return ver + 1, false, tx.Commit()
+}
@@ -0,0 +1,155 @@
+package store
+
+// This is the durable job queue of the Postgres store.  Workers claim the
+// ready jobs with FOR UPDATE SKIP LOCKED, so concurrent workers on any
+// number of instances each get a different job without waiting on one
+// another.
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	// the columns of a queued job
+	queueCols = `id, payload, state, attempts, max_attempts, run_at, lease_owner,
+	    lease_until, last_error, created_at, updated_at`
+
+	// add a job
+	enqueueJob = `INSERT INTO job_queue (payload, max_attempts) VALUES ($1, $2) RETURNING id;`
+
+	// dead-letter the jobs whose lease expired on their last attempt
+	expireJobs = `UPDATE job_queue SET state = 'dead',
+	    last_error = 'lease expired on the last attempt',
+	    lease_owner = '', lease_until = NULL, updated_at = now()
+	    WHERE state = 'running' AND lease_until < now() AND attempts >= max_attempts;`
+
+	// lease the next ready job: a pending one that is due, or a running
+	// one whose worker let its lease expire
+	claimJob = `UPDATE job_queue SET state = 'running', attempts = attempts + 1,
+	    lease_owner = $1, lease_until = now() + $2 * interval '1 millisecond',
+	    updated_at = now()
+	    WHERE id = (SELECT id FROM job_queue
+	        WHERE (state = 'pending' AND run_at <= now())
+	            OR (state = 'running' AND lease_until < now() AND attempts < max_attempts)
+	        ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+	    RETURNING ` + queueCols + `;`
+
+	// renew a lease
+	extendJob = `UPDATE job_queue SET lease_until = now() + $3 * interval '1 millisecond',
+	    updated_at = now()
+	    WHERE id = $1 AND lease_owner = $2 AND state = 'running';`
+
+	// finish a job
+	completeJob = `UPDATE job_queue SET state = 'done', lease_owner = '', lease_until = NULL,
+	    updated_at = now()
+	    WHERE id = $1 AND lease_owner = $2 AND state = 'running';`
+
+	// record a failed attempt, for a retry or the dead letters
+	failJob = `UPDATE job_queue
+	    SET state = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+	    run_at = now() + $4 * interval '1 millisecond', last_error = $3,
+	    lease_owner = '', lease_until = NULL, updated_at = now()
+	    WHERE id = $1 AND lease_owner = $2 AND state = 'running'
+	    RETURNING state;`
+
+	// fail a job permanently
+	deadLetterJob = `UPDATE job_queue SET state = 'dead', last_error = $3,
+	    lease_owner = '', lease_until = NULL, updated_at = now()
+	    WHERE id = $1 AND lease_owner = $2 AND state = 'running';`
+
+	// return a job to the queue, without counting the attempt
+	releaseJob = `UPDATE job_queue SET state = 'pending', attempts = attempts - 1,
+	    run_at = now(), lease_owner = '', lease_until = NULL, updated_at = now()
+	    WHERE id = $1 AND lease_owner = $2 AND state = 'running';`
+
+	// query a job
+	findJob = `SELECT ` + queueCols + ` FROM job_queue WHERE id = $1;`
+)
+
+// Compile time interface implementation check.
+var _ Queue = (*PostgresStore)(nil)
+
+// Enqueue adds a job to the queue.
+func (ps *PostgresStore) Enqueue(ctx context.Context, payload []byte, maxAttempts int) (int64, error) {
+	var id int64
+	err := ps.db.GetContext(ctx, &id, enqueueJob, string(payload), maxAttempts)
+	return id, err
+}
+
+// Claim leases the next ready job.
+func (ps *PostgresStore) Claim(ctx context.Context, owner string, lease time.Duration) (QueuedJob, bool, error) {
+	if _, err := ps.db.ExecContext(ctx, expireJobs); err != nil {
+		return QueuedJob{}, false, err
+	}
+	var job QueuedJob
+	if err := ps.db.GetContext(ctx, &job, claimJob, owner, lease.Milliseconds()); err != nil {
+		if err == sql.ErrNoRows {
+			return QueuedJob{}, false, nil
+		}
+		return QueuedJob{}, false, err
+	}
+	return job, true, nil
+}
+
+// Extend renews the lease of a job.
+func (ps *PostgresStore) Extend(ctx context.Context, id int64, owner string, lease time.Duration) error {
+	return ps.updateJob(ctx, extendJob, id, owner, lease.Milliseconds())
+}
+
+// Complete marks a job as done.
+func (ps *PostgresStore) Complete(ctx context.Context, id int64, owner string) error {
+	return ps.updateJob(ctx, completeJob, id, owner)
+}
+
+// Fail records a failed attempt of a job.
+func (ps *PostgresStore) Fail(ctx context.Context, id int64, owner, cause string, delay time.Duration) (string, error) {
+	var state string
+	if err := ps.db.GetContext(ctx, &state, failJob, id, owner, cause, delay.Milliseconds()); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrLeaseLost
+		}
+		return "", err
+	}
+	return state, nil
+}
+
+// DeadLetter fails a job permanently.
+func (ps *PostgresStore) DeadLetter(ctx context.Context, id int64, owner, cause string) error {
+	return ps.updateJob(ctx, deadLetterJob, id, owner, cause)
+}
+
+// Release returns a job to the queue.
+func (ps *PostgresStore) Release(ctx context.Context, id int64, owner string) error {
+	return ps.updateJob(ctx, releaseJob, id, owner)
+}
+
+// QueuedJob returns a job in the queue.
+func (ps *PostgresStore) QueuedJob(ctx context.Context, id int64) (QueuedJob, bool, error) {
+	var job QueuedJob
+	if err := ps.db.GetContext(ctx, &job, findJob, id); err != nil {
+		if err == sql.ErrNoRows {
+			return QueuedJob{}, false, nil
+		}
+		return QueuedJob{}, false, err
+	}
+	return job, true, nil
+}
+
+// Runs an update of a job held by the owner, which is no longer the case
+// if no row matches.
+func (ps *PostgresStore) updateJob(ctx context.Context, query string, args ...interface{}) error {
+	r, err := ps.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	cnt, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
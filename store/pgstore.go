@@ -8,23 +8,19 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
-
-	// Load Postgres driver
-	_ "github.com/lib/pq"
 )
 
-const (
-	// the table has the "n" of fib(n) as the primary key, and the value
-	// of fib(n) stored as a big integer.
-	createTable = `CREATE TABLE IF NOT EXISTS fibtab (
-	num INTEGER PRIMARY KEY,
-	value BIGINT
-	);`
+// DefaultHitFlushInterval is how often the buffered memo hit counts are
+// written to the database if not configured.
+const DefaultHitFlushInterval = 5 * time.Second
 
+const (
 	// The main queries are prepared at initialization time for efficiency.
 
 	// query to select a memo by fibonacci number
@@ -35,8 +31,34 @@ const (
 	memoCount = `SELECT count(*) as count FROM fibtab WHERE value < $1;`
 
 	// store a memo (ignore a duplicate update, say on multiple concurrent clients)
-	store = `INSERT INTO fibtab (num, value) VALUES ($1, $2)
+	store = `INSERT INTO fibtab (num, value, writer_id) VALUES ($1, $2, $3)
 	    ON CONFLICT (num) DO NOTHING;`
+
+	// query to select a memo with its metadata
+	findDetail = `SELECT num, value, created_at, last_accessed_at, hit_count, writer_id
+	    FROM fibtab WHERE num = $1;`
+
+	// a page of memos, using the primary key index for keyset pagination
+	listMemos = `SELECT num, value, created_at, last_accessed_at, hit_count, writer_id
+	    FROM fibtab WHERE num > $1 ORDER BY num LIMIT $2;`
+
+	// apply a batch of buffered hit counts and access times
+	updateHits = `UPDATE fibtab SET hit_count = fibtab.hit_count + h.cnt,
+	    last_accessed_at = greatest(fibtab.last_accessed_at, to_timestamp(h.at))
+	    FROM (SELECT unnest($1::integer[]) AS num, unnest($2::bigint[]) AS cnt,
+	        unnest($3::float8[]) AS at) AS h
+	    WHERE fibtab.num = h.num;`
+
+	// count the memos in a range of fibonacci numbers (for a dry run)
+	rangeCount = `SELECT count(*) as count FROM fibtab WHERE num BETWEEN $1 AND $2;`
+
+	// remove the memos in a range of fibonacci numbers
+	rangeDelete = `DELETE FROM fibtab WHERE num BETWEEN $1 AND $2;`
+
+	// add to a client's usage for a day, returning the total
+	addUsage = `INSERT INTO quota_usage (client, day, used) VALUES ($1, $2, $3)
+	    ON CONFLICT (client, day) DO UPDATE SET used = quota_usage.used + EXCLUDED.used
+	    RETURNING used;`
 )
 
 // PostgresConfig defines the parameters needed to initialize the
@@ -47,19 +69,46 @@ type PostgresConfig struct {
 	User     string
 	Password string
 	DBName   string
+
+	// InstanceID is recorded as the writer of the memos this
+	// instance stores.
+	InstanceID string
+
+	// HitFlushInterval is how often buffered hit counts are written,
+	// DefaultHitFlushInterval if zero.
+	HitFlushInterval time.Duration
 }
 
 // PostgresStore if the type implementing the Store interface for Postgres.
 type PostgresStore struct {
-	db        *sqlx.DB
-	findStmt  *sqlx.Stmt
-	storeStmt *sqlx.Stmt
-	memoStmt  *sqlx.Stmt
-	log       *zap.SugaredLogger
+	db         *sqlx.DB
+	findStmt   *sqlx.Stmt
+	storeStmt  *sqlx.Stmt
+	memoStmt   *sqlx.Stmt
+	log        *zap.SugaredLogger
+	instanceID string
+	schemaVer  int
+
+	// Hits are buffered here and flushed in batches, so reads don't
+	// pay for a write.
+	hitMu   sync.Mutex
+	hits    map[int]*hitCount
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// The buffered hits for a memo.
+type hitCount struct {
+	cnt  int64
+	last time.Time
 }
 
-// Compile time interface implementation check.
-var _ Store = (*PostgresStore)(nil)
+// Compile time interface implementation checks.
+var (
+	_ Store  = (*PostgresStore)(nil)
+	_ Pinger = (*PostgresStore)(nil)
+	_ Quotas = (*PostgresStore)(nil)
+)
 
 // NewPostgres return a new Postgres store
 func NewPostgres(ctx context.Context, cfg PostgresConfig, log *zap.SugaredLogger) (Store, error) {
@@ -81,11 +130,12 @@ func NewPostgres(ctx context.Context, cfg PostgresConfig, log *zap.SugaredLogger
 	}
 	log.Infow("DB connection", "status", "connected to DB")
 
-	// Create the table if it doesn't exist.
-	_, err = db.ExecContext(ctx, createTable)
+	// Create or upgrade the schema.
+	ver, err := migrate(ctx, db)
 	if err != nil {
 		return nil, err
 	}
+	log.Infow("DB schema", "version", ver)
 
 	// Prepare the other statements for better performance.
 	find, err := db.PreparexContext(ctx, findMemo)
@@ -104,12 +154,22 @@ func NewPostgres(ctx context.Context, cfg PostgresConfig, log *zap.SugaredLogger
 	}
 
 	ps := &PostgresStore{
-		db:        db,
-		findStmt:  find,
-		storeStmt: store,
-		memoStmt:  mcnt,
-		log:       log,
+		db:         db,
+		findStmt:   find,
+		storeStmt:  store,
+		memoStmt:   mcnt,
+		log:        log,
+		instanceID: cfg.InstanceID,
+		schemaVer:  ver,
+		hits:       make(map[int]*hitCount),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
 	}
+	interval := cfg.HitFlushInterval
+	if interval <= 0 {
+		interval = DefaultHitFlushInterval
+	}
+	go ps.flushLoop(interval)
 	return ps, nil
 }
 
@@ -125,15 +185,38 @@ func (ps *PostgresStore) Memo(ctx context.Context, n int) (uint64, bool, error)
 		}
 		return 0, false, err
 	}
+	ps.recordHit(n)
 	return res, true, nil
 }
 
 // Memoize stores a memoized value
 func (ps *PostgresStore) Memoize(ctx context.Context, n int, val uint64) error {
-	_, err := ps.storeStmt.ExecContext(ctx, n, val)
+	_, err := ps.storeStmt.ExecContext(ctx, n, val, ps.instanceID)
 	return err
 }
 
+// MemoDetail gets a memo with its metadata.  Hits not yet flushed to the
+// database are included.
+func (ps *PostgresStore) MemoDetail(ctx context.Context, n int) (FibPair, bool, error) {
+	var res FibPair
+	if err := ps.db.GetContext(ctx, &res, findDetail, n); err != nil {
+		if err == sql.ErrNoRows {
+			return FibPair{}, false, nil
+		}
+		return FibPair{}, false, err
+	}
+	ps.hitMu.Lock()
+	if h, ok := ps.hits[n]; ok {
+		res.HitCount += h.cnt
+		if res.LastAccessedAt == nil || h.last.After(*res.LastAccessedAt) {
+			last := h.last
+			res.LastAccessedAt = &last
+		}
+	}
+	ps.hitMu.Unlock()
+	return res, true, nil
+}
+
 // MemoCount returns the number of memoizations whose value is less than or
 // equal to the target.
 func (ps *PostgresStore) MemoCount(ctx context.Context, target uint64) (int, error) {
@@ -155,8 +238,129 @@ func (ps *PostgresStore) Clear(ctx context.Context) error {
 	return err
 }
 
+// List returns a page of memos in order of n.  The hit counts do not
+// include those not yet flushed.
+func (ps *PostgresStore) List(ctx context.Context, after, limit int) ([]FibPair, error) {
+	res := []FibPair{}
+	if err := ps.db.SelectContext(ctx, &res, listMemos, after, limit); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ClearRange deletes the rows with num in [from, to].  For a dry run, the
+// rows are only counted.
+func (ps *PostgresStore) ClearRange(ctx context.Context, from, to int, dryRun bool) (int, error) {
+	if dryRun {
+		return ps.CountRange(ctx, from, to)
+	}
+	r, err := ps.db.ExecContext(ctx, rangeDelete, from, to)
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := r.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(cnt), nil
+}
+
+// CountRange counts the rows with num in [from, to].
+func (ps *PostgresStore) CountRange(ctx context.Context, from, to int) (int, error) {
+	var res int
+	if err := ps.db.QueryRowContext(ctx, rangeCount, from, to).Scan(&res); err != nil {
+		return 0, err
+	}
+	return res, nil
+}
+
+// Ping checks the database connection.
+func (ps *PostgresStore) Ping(ctx context.Context) error {
+	return ps.db.PingContext(ctx)
+}
+
+// AddUsage adds to a client's usage for a day, for quotas.
+func (ps *PostgresStore) AddUsage(ctx context.Context, key string, day time.Time, n int) (int, error) {
+	var used int
+	err := ps.db.GetContext(ctx, &used, addUsage, key, day.UTC().Format("2006-01-02"), n)
+	return used, err
+}
+
+// SchemaVersion returns the version of the schema, after migrations.
+func (ps *PostgresStore) SchemaVersion() int {
+	return ps.schemaVer
+}
+
+// Stats returns the statistics of the database connection pool.
+func (ps *PostgresStore) Stats() sql.DBStats {
+	return ps.db.Stats()
+}
+
+// Buffers a hit on a memo.
+func (ps *PostgresStore) recordHit(n int) {
+	ps.hitMu.Lock()
+	h, ok := ps.hits[n]
+	if !ok {
+		h = &hitCount{}
+		ps.hits[n] = h
+	}
+	h.cnt++
+	h.last = time.Now()
+	ps.hitMu.Unlock()
+}
+
+// Periodically flushes the buffered hits until the store is shut down,
+// at which point any remaining hits are flushed.
+func (ps *PostgresStore) flushLoop(interval time.Duration) {
+	defer close(ps.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ps.flushHits(context.Background()); err != nil {
+				ps.log.Errorw("flushing hit counts", "error", err)
+			}
+		case <-ps.stop:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := ps.flushHits(ctx); err != nil {
+				ps.log.Errorw("flushing hit counts", "error", err)
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+// Writes the buffered hits in a single statement.  On failure the hits
+// are dropped, as they are only statistics.
+func (ps *PostgresStore) flushHits(ctx context.Context) error {
+	ps.hitMu.Lock()
+	hits := ps.hits
+	ps.hits = make(map[int]*hitCount)
+	ps.hitMu.Unlock()
+	if len(hits) == 0 {
+		return nil
+	}
+
+	nums := make([]int64, 0, len(hits))
+	cnts := make([]int64, 0, len(hits))
+	ats := make([]float64, 0, len(hits))
+	for n, h := range hits {
+		nums = append(nums, int64(n))
+		cnts = append(cnts, h.cnt)
+		ats = append(ats, float64(h.last.UnixNano())/1e9)
+	}
+	_, err := ps.db.ExecContext(ctx, updateHits,
+		pq.Array(nums), pq.Array(cnts), pq.Array(ats))
+	return err
+}
+
 // Shutdown shuts down the store
 func (ps *PostgresStore) Shutdown() error {
+	close(ps.stop)
+	<-ps.stopped
+
 	var buf bytes.Buffer
 	err1 := ps.findStmt.Close()
 	if err1 != nil {
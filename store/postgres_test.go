@@ -10,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/ory/dockertest"
 	"github.com/ory/dockertest/docker"
@@ -63,11 +64,12 @@ func TestMain(m *testing.M) {
 	if err = pool.Retry(func() error {
 		repo, err = NewPostgres(context.Background(),
 			PostgresConfig{
-				Host:     "localhost",
-				Port:     portNum,
-				User:     user,
-				Password: password,
-				DBName:   db,
+				Host:       "localhost",
+				Port:       portNum,
+				User:       user,
+				Password:   password,
+				DBName:     db,
+				InstanceID: "test",
 			},
 			newDebugLogger(),
 		)
@@ -100,7 +102,10 @@ func TestStore(t *testing.T) {
 		missingKeys []int
 	}{
 		{
-			pairs:       []FibPair{{0, 0}, {1, 1}, {2, 1}, {3, 2}, {4, 3}},
+			pairs: []FibPair{
+				{Num: 0, Value: 0}, {Num: 1, Value: 1}, {Num: 2, Value: 1},
+				{Num: 3, Value: 2}, {Num: 4, Value: 3},
+			},
 			missingKeys: []int{5, 7},
 		},
 	} {
@@ -129,13 +134,38 @@ func TestStore(t *testing.T) {
 				t.Fatalf("%d: couldn't retrieve memo %d: %v", i, p.Num, err)
 			}
 			if val != p.Value {
-				t.Fatalf("%d: retrieving memo %d, expected value %d, got %d", i, p, p.Value, val)
+				t.Fatalf("%d: retrieving memo %d, expected value %d, got %d", i, p.Num, p.Value, val)
 			}
 			if i == len(v.pairs)-1 {
 				lastVal = p.Value
 			}
 		}
 
+		// The reads above must show up in the metadata, even before
+		// the hit counts are flushed.
+		for _, p := range v.pairs {
+			d, ok, err := repo.MemoDetail(ctx, p.Num)
+			if err != nil {
+				t.Fatalf("%d: error retrieving memo detail %d: %v", i, p.Num, err)
+			}
+			if !ok {
+				t.Fatalf("%d: couldn't retrieve memo detail %d", i, p.Num)
+			}
+			if d.Value != p.Value || d.HitCount != 1 || d.LastAccessedAt == nil ||
+				d.WriterID != "test" || d.CreatedAt.IsZero() {
+				t.Fatalf("%d: unexpected memo detail %+v", i, d)
+			}
+		}
+
+		// Page through the memos in order.
+		page, err := repo.List(ctx, 1, 2)
+		if err != nil {
+			t.Fatalf("%d: error listing memos: %v", i, err)
+		}
+		if len(page) != 2 || page[0].Num != 2 || page[1].Num != 3 {
+			t.Fatalf("%d: unexpected page of memos: %+v", i, page)
+		}
+
 		// Make sure we handle missng memos ok.
 		for _, m := range v.missingKeys {
 			_, ok, err := repo.Memo(ctx, m)
@@ -156,6 +186,28 @@ func TestStore(t *testing.T) {
 			t.Fatalf("%d: retrieving memo coiunt, expected value %d, got %d", i, len(v.pairs)-1, cnt)
 		}
 
+		// A dry run of a range clear must not remove anything.
+		rcnt, err := repo.ClearRange(ctx, 1, 2, true)
+		if err != nil {
+			t.Fatalf("%d: error clearing range: %v", i, err)
+		}
+		if rcnt != 2 {
+			t.Fatalf("%d: dry run range clear, expected 2, got %d", i, rcnt)
+		}
+		if rcnt, err = repo.CountRange(ctx, 1, 2); err != nil || rcnt != 2 {
+			t.Fatalf("%d: range count, expected 2, got %d (%v)", i, rcnt, err)
+		}
+		rcnt, err = repo.ClearRange(ctx, 1, 2, false)
+		if err != nil {
+			t.Fatalf("%d: error clearing range: %v", i, err)
+		}
+		if rcnt != 2 {
+			t.Fatalf("%d: range clear, expected 2, got %d", i, rcnt)
+		}
+		if _, ok, _ := repo.Memo(ctx, 1); ok {
+			t.Fatalf("%d: memo 1 should have been cleared", i)
+		}
+
 		if err := repo.Clear(ctx); err != nil {
 			t.Fatalf("%d: error clearing store: %v", i, err)
 		}
@@ -171,6 +223,114 @@ func TestStore(t *testing.T) {
 	}
 }
 
+// Tests the daily usage accumulates per client and day.
+func TestQuotaUsage(t *testing.T) {
+	ctx := context.Background()
+	q := repo.(Quotas)
+	day := time.Now()
+	key := fmt.Sprintf("test-%d", day.UnixNano())
+	for i, v := range []struct {
+		day      time.Time
+		n        int
+		expected int
+	}{
+		{day, 1, 1},
+		{day, 2, 3},
+		{day.AddDate(0, 0, 1), 1, 1},
+	} {
+		used, err := q.AddUsage(ctx, key, v.day, v.n)
+		if err != nil {
+			t.Fatalf("%d: error adding usage: %v", i, err)
+		}
+		if used != v.expected {
+			t.Fatalf("%d: expected usage %d, got %d", i, v.expected, used)
+		}
+	}
+}
+
+// Tests concurrent workers claim different jobs, and jobs are retried,
+// reclaimed when their lease expires, and dead-lettered.
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	q := repo.(Queue)
+	a, err := q.Enqueue(ctx, []byte(`{"from": 0, "to": 10}`), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := q.Enqueue(ctx, []byte(`{"from": 0, "to": 20}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The two workers skip the job locked by the other.
+	claimed := make(chan QueuedJob, 2)
+	for _, owner := range []string{"w1", "w2"} {
+		go func(owner string) {
+			j, ok, err := q.Claim(ctx, owner, time.Minute)
+			if err != nil || !ok {
+				t.Errorf("%s: expected to claim a job, got %v, %v", owner, ok, err)
+			}
+			claimed <- j
+		}(owner)
+	}
+	j1, j2 := <-claimed, <-claimed
+	if j1.ID == j2.ID || j1.State != QueueRunning || j1.Attempts != 1 {
+		t.Fatalf("expected two different running jobs, got %+v and %+v", j1, j2)
+	}
+	if _, ok, err := q.Claim(ctx, "w3", time.Minute); err != nil || ok {
+		t.Fatalf("expected no job to claim, got %v, %v", ok, err)
+	}
+	owners := map[int64]string{j1.ID: j1.LeaseOwner, j2.ID: j2.LeaseOwner}
+
+	// The first job is retried once, then dead-lettered.
+	if state, err := q.Fail(ctx, a, owners[a], "boom", 0); err != nil || state != QueuePending {
+		t.Fatalf("expected the job to be retried, got %s, %v", state, err)
+	}
+	j, ok, err := q.Claim(ctx, "w3", time.Minute)
+	if err != nil || !ok || j.ID != a || j.Attempts != 2 || j.LastError != "boom" {
+		t.Fatalf("expected to claim the retry, got %+v, %v", j, err)
+	}
+	if state, err := q.Fail(ctx, a, "w3", "boom again", 0); err != nil || state != QueueDead {
+		t.Fatalf("expected the job to be dead, got %s, %v", state, err)
+	}
+
+	// The second job is released, then claimed again with a short lease,
+	// which expires on its last attempt.
+	if err := q.Release(ctx, b, owners[b]); err != nil {
+		t.Fatal(err)
+	}
+	if j, ok, err = q.Claim(ctx, "w4", time.Millisecond); err != nil || !ok || j.ID != b || j.Attempts != 1 {
+		t.Fatalf("expected to claim the released job, got %+v, %v", j, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := q.Extend(ctx, b, "w1", time.Minute); err != ErrLeaseLost {
+		t.Fatalf("expected the lease to be held by another worker, got %v", err)
+	}
+	if _, ok, err := q.Claim(ctx, "w5", time.Minute); err != nil || ok {
+		t.Fatalf("expected no job to claim, got %v, %v", ok, err)
+	}
+	if j, _, err = q.QueuedJob(ctx, b); err != nil || j.State != QueueDead {
+		t.Fatalf("expected the expired job to be dead, got %+v, %v", j, err)
+	}
+	if err := q.Complete(ctx, b, "w4"); err != ErrLeaseLost {
+		t.Fatalf("expected the lease to be lost, got %v", err)
+	}
+
+	c, err := q.Enqueue(ctx, []byte(`{"from": 5, "to": 5}`), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := q.Claim(ctx, "w6", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Complete(ctx, c, "w6"); err != nil {
+		t.Fatal(err)
+	}
+	if j, ok, err = q.QueuedJob(ctx, c); err != nil || !ok || j.State != QueueDone || j.LeaseUntil != nil {
+		t.Fatalf("expected the job to be done, got %+v, %v", j, err)
+	}
+}
+
 func newDebugLogger() *zap.SugaredLogger {
 	config := zap.NewProductionConfig()
 	lg, _ := config.Build()
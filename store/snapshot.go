@@ -0,0 +1,283 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SnapshotVersion is the version of the snapshot format written.
+const SnapshotVersion = 1
+
+// ErrBadSnapshot is returned for a snapshot that is not in the format, or
+// whose checksum doesn't match its contents.
+var ErrBadSnapshot = errors.New("invalid snapshot")
+
+// A snapshot starts with a fixed size header, followed by the contents as
+// JSON.  The header gives the format version, and the length and SHA-256
+// checksum of the contents, so a truncated or damaged file is detected.
+var snapshotMagic = [8]byte{'f', 'i', 'b', 's', 'n', 'a', 'p', '\n'}
+
+type snapshotHeader struct {
+	Magic   [8]byte
+	Version uint32
+	Length  uint64
+	Sum     [sha256.Size]byte
+}
+
+// The contents of a snapshot: the memos with their metadata, the quota
+// usage and the queued jobs.
+type snapshotData struct {
+	Memos   []FibPair                `json:"memos"`
+	Usage   map[string]snapshotUsage `json:"usage"`
+	Jobs    []QueuedJob              `json:"jobs"`
+	LastJob int64                    `json:"last_job"`
+}
+
+type snapshotUsage struct {
+	Day  string `json:"day"`
+	Used int    `json:"used"`
+}
+
+// SnapshotConfig configures the snapshots of a MapStore.
+type SnapshotConfig struct {
+	// Path is the snapshot file.
+	Path string
+
+	// Interval is how often the store is written if it changed.  If zero,
+	// it is only written at shutdown.  Reads only change the hit counts of
+	// the memos, so they don't cause a periodic write, and are written
+	// with the next change or at shutdown.
+	Interval time.Duration
+}
+
+// NewSnapshotMap returns an in-memory store persisted to a snapshot file.
+// The store is loaded from the file if it exists, and written to it
+// periodically and at Shutdown.  Each write replaces the file atomically,
+// so a crash loses at most the changes since the last snapshot.
+func NewSnapshotMap(cfg SnapshotConfig, log *zap.SugaredLogger) (*MapStore, error) {
+	ms := NewMap().(*MapStore)
+	err := ms.LoadSnapshot(cfg.Path)
+	switch {
+	case err == nil:
+		ms.saved, ms.savedReads = atomic.LoadUint64(&ms.gen), atomic.LoadUint64(&ms.reads)
+		log.Infow("Snapshot loaded", "path", cfg.Path, "memos", len(ms.memos), "jobs", len(ms.queue))
+	case os.IsNotExist(err):
+		log.Infow("No snapshot, starting empty", "path", cfg.Path)
+	default:
+		return nil, err
+	}
+	ms.snap, ms.log = cfg, log
+	ms.stop, ms.stopped = make(chan struct{}), make(chan struct{})
+	go ms.snapshotLoop()
+	return ms, nil
+}
+
+// Periodically writes the store if it changed, until it is shut down, at
+// which point it is written a last time if it changed or was read.
+func (ms *MapStore) snapshotLoop() {
+	defer close(ms.stopped)
+	var tick <-chan time.Time
+	if ms.snap.Interval > 0 {
+		ticker := time.NewTicker(ms.snap.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			if err := ms.saveIfChanged(false); err != nil {
+				ms.log.Errorw("writing snapshot", "error", err)
+			}
+		case <-ms.stop:
+			ms.stopErr = ms.saveIfChanged(true)
+			return
+		}
+	}
+}
+
+// Writes the store if it changed, or also if it was read for the last
+// snapshot.
+func (ms *MapStore) saveIfChanged(last bool) error {
+	ms.saveMu.Lock()
+	saved, savedReads := ms.saved, ms.savedReads
+	ms.saveMu.Unlock()
+	if atomic.LoadUint64(&ms.gen) == saved && (!last || atomic.LoadUint64(&ms.reads) == savedReads) {
+		return nil
+	}
+	return ms.SaveSnapshot(ms.snap.Path)
+}
+
+// Shutdown writes the last snapshot, if the store has snapshots, and
+// returns the error writing it.  Later calls return the same error.
+func (ms *MapStore) Shutdown() error {
+	if ms.stop == nil {
+		return nil
+	}
+	ms.stopOnce.Do(func() {
+		close(ms.stop)
+		<-ms.stopped
+	})
+	return ms.stopErr
+}
+
+// SaveSnapshot writes the store to the file, through a temporary file in
+// the same directory that is renamed over it, so the file is always
+// complete.
+func (ms *MapStore) SaveSnapshot(path string) error {
+	ms.saveMu.Lock()
+	defer ms.saveMu.Unlock()
+
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	gen, reads, err := ms.writeSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	// The rename is only durable once the directory is synced, which
+	// isn't supported everywhere.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	ms.saved, ms.savedReads = gen, reads
+	return nil
+}
+
+// LoadSnapshot replaces the contents of the store with those of the file.
+// A missing file is reported as such by os.IsNotExist.
+func (ms *MapStore) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := ms.ReadSnapshot(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// WriteSnapshot writes the store as a snapshot.
+func (ms *MapStore) WriteSnapshot(w io.Writer) error {
+	_, _, err := ms.writeSnapshot(w)
+	return err
+}
+
+// Writes the store, returning the gen and reads it was written at.
+func (ms *MapStore) writeSnapshot(w io.Writer) (uint64, uint64, error) {
+	ms.mu.RLock()
+	gen, reads := atomic.LoadUint64(&ms.gen), atomic.LoadUint64(&ms.reads)
+	data := snapshotData{Usage: make(map[string]snapshotUsage, len(ms.usage)), LastJob: ms.lastJob}
+	data.Memos = make([]FibPair, len(ms.memos))
+	for i, m := range ms.memos {
+		data.Memos[i] = m.detail()
+	}
+	for k, u := range ms.usage {
+		data.Usage[k] = snapshotUsage{Day: u.day, Used: u.used}
+	}
+	for _, j := range ms.queue {
+		data.Jobs = append(data.Jobs, *j)
+	}
+	ms.mu.RUnlock()
+	sort.Slice(data.Jobs, func(i, j int) bool { return data.Jobs[i].ID < data.Jobs[j].ID })
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	hdr := snapshotHeader{Magic: snapshotMagic, Version: SnapshotVersion,
+		Length: uint64(len(b)), Sum: sha256.Sum256(b)}
+	if err := binary.Write(w, binary.BigEndian, hdr); err != nil {
+		return 0, 0, err
+	}
+	_, err = w.Write(b)
+	return gen, reads, err
+}
+
+// ReadSnapshot replaces the contents of the store with those of the
+// snapshot.  The store is unchanged if the snapshot is invalid.
+func (ms *MapStore) ReadSnapshot(r io.Reader) error {
+	var hdr snapshotHeader
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return fmt.Errorf("%w: reading header: %v", ErrBadSnapshot, err)
+	}
+	if hdr.Magic != snapshotMagic {
+		return fmt.Errorf("%w: not a snapshot", ErrBadSnapshot)
+	}
+	if hdr.Version != SnapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, hdr.Version)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(r, int64(hdr.Length))); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	if uint64(len(b)) != hdr.Length {
+		return fmt.Errorf("%w: truncated, %d of %d bytes", ErrBadSnapshot, len(b), hdr.Length)
+	}
+	if sha256.Sum256(b) != hdr.Sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+	var data snapshotData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+
+	sort.Slice(data.Memos, func(i, j int) bool { return data.Memos[i].Num < data.Memos[j].Num })
+	memos := make([]*memo, 0, len(data.Memos))
+	values := make([]uint64, 0, len(data.Memos))
+	for i, p := range data.Memos {
+		if i > 0 && p.Num == data.Memos[i-1].Num {
+			return fmt.Errorf("%w: duplicate memo for %d", ErrBadSnapshot, p.Num)
+		}
+		m := &memo{hits: p.HitCount, pair: p}
+		if p.LastAccessedAt != nil {
+			m.accessed = p.LastAccessedAt.UnixNano()
+		}
+		m.pair.HitCount, m.pair.LastAccessedAt = 0, nil
+		memos = append(memos, m)
+		values = append(values, p.Value)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	usage := make(map[string]dayUsage, len(data.Usage))
+	for k, u := range data.Usage {
+		usage[k] = dayUsage{day: u.Day, used: u.Used}
+	}
+	queue := make(map[int64]*QueuedJob, len(data.Jobs))
+	for i := range data.Jobs {
+		queue[data.Jobs[i].ID] = &data.Jobs[i]
+	}
+
+	ms.mu.Lock()
+	ms.memos, ms.values, ms.usage, ms.queue, ms.lastJob = memos, values, usage, queue, data.LastJob
+	ms.changed()
+	ms.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Tests a snapshot restores the memos, quotas and jobs.
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	src := NewMap().(*MapStore)
+	for n, v := range []uint64{0, 1, 1, 2, 3, 5} {
+		src.Memoize(ctx, n, v)
+	}
+	src.Memo(ctx, 3)
+	src.AddUsage(ctx, "client", time.Now(), 7)
+	src.Enqueue(ctx, []byte(`{"from":1,"to":2}`), 3)
+	id, _ := src.Enqueue(ctx, []byte(`{"from":3,"to":4}`), 3)
+
+	var buf bytes.Buffer
+	if err := src.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewMap().(*MapStore)
+	dst.Memoize(ctx, 50, 1)
+	if err := dst.ReadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	memos, _ := dst.List(ctx, -1, 100)
+	if len(memos) != 6 || memos[5].Value != 5 {
+		t.Fatalf("unexpected memos: %v", memos)
+	}
+	if m, _, _ := dst.MemoDetail(ctx, 3); m.HitCount != 1 || m.LastAccessedAt == nil {
+		t.Fatalf("unexpected memo metadata: %+v", m)
+	}
+	if cnt, _ := dst.MemoCount(ctx, 3); cnt != 4 {
+		t.Fatalf("expected 4 memos less than 3, got %d", cnt)
+	}
+	if used, _ := dst.AddUsage(ctx, "client", time.Now(), 1); used != 8 {
+		t.Fatalf("expected the usage to be restored, got %d", used)
+	}
+	if j, ok, _ := dst.QueuedJob(ctx, id); !ok || string(j.Payload) != `{"from":3,"to":4}` {
+		t.Fatalf("unexpected job: %+v", j)
+	}
+	if next, _ := dst.Enqueue(ctx, nil, 1); next != id+1 {
+		t.Fatalf("expected job %d, got %d", id+1, next)
+	}
+
+	// Damaged snapshots are rejected, leaving the store unchanged.
+	b := buf.Bytes()
+	for i, bad := range [][]byte{
+		nil,
+		b[:20],
+		b[:len(b)-1],
+		append(append([]byte{}, b[:len(b)-2]...), '}', '}'),
+		append([]byte("fibsnop\n"), b[8:]...),
+		append(append([]byte{}, b[:11]...), append([]byte{2}, b[12:]...)...),
+	} {
+		if err := dst.ReadSnapshot(bytes.NewReader(bad)); !errors.Is(err, ErrBadSnapshot) {
+			t.Fatalf("%d: expected a bad snapshot, got %v", i, err)
+		}
+	}
+	if memos, _ := dst.List(ctx, -1, 100); len(memos) != 6 {
+		t.Fatalf("expected the store to be unchanged, got %v", memos)
+	}
+}
+
+// Tests the snapshots are written periodically and at shutdown, and loaded
+// at startup.
+func TestSnapshotFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "memos.snap")
+	log := zap.NewNop().Sugar()
+
+	ms, err := NewSnapshotMap(SnapshotConfig{Path: path, Interval: 10 * time.Millisecond}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.Memoize(ctx, 10, 55)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a periodic snapshot")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ms.Memoize(ctx, 11, 89)
+	if err := ms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the snapshot remains, without temporary files.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("unexpected files: %v %v", files, err)
+	}
+
+	ms, err = NewSnapshotMap(SnapshotConfig{Path: path}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memos, _ := ms.List(ctx, -1, 100); len(memos) != 2 || memos[1].Value != 89 {
+		t.Fatalf("unexpected memos: %v", memos)
+	}
+	ms.Shutdown()
+
+	// Reads are only written at shutdown.
+	ms, err = NewSnapshotMap(SnapshotConfig{Path: path, Interval: 10 * time.Millisecond}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(path)
+	ms.Memo(ctx, 10)
+	time.Sleep(50 * time.Millisecond)
+	if after, _ := os.Stat(path); !after.ModTime().Equal(before.ModTime()) {
+		t.Fatal("expected reads not to be written periodically")
+	}
+	if err := ms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	ms, err = NewSnapshotMap(SnapshotConfig{Path: path}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _, _ := ms.MemoDetail(ctx, 10); m.HitCount != 1 {
+		t.Fatalf("expected the read to be written at shutdown, got %+v", m)
+	}
+	ms.Shutdown()
+
+	// The last snapshot failing fails the shutdown.
+	gone := filepath.Join(dir, "gone")
+	if err := os.Mkdir(gone, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ms, err = NewSnapshotMap(SnapshotConfig{Path: filepath.Join(gone, "memos.snap")}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.Memoize(ctx, 1, 1)
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Shutdown(); err == nil {
+		t.Fatal("expected the last snapshot to fail")
+	}
+	if err := ms.Shutdown(); err == nil {
+		t.Fatal("expected a second shutdown to return the same error")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSnapshotMap(SnapshotConfig{Path: path}, log); !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("expected a bad snapshot, got %v", err)
+	}
+}
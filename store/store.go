@@ -11,12 +11,29 @@
 // code logic before attemtping the Postgress store.
 package store
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
-// FibPair is a fibonacci offset number and it value
+// FibPair is a fibonacci offset number and it value, along with
+// the metadata kept for the memo.
 type FibPair struct {
-	Num   int    `db:"num"`
-	Value uint64 `db:"value"`
+	Num   int    `db:"num" json:"n"`
+	Value uint64 `db:"value" json:"value"`
+
+	// CreatedAt is when the memo was first written.
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+
+	// LastAccessedAt is when the memo was last read, nil if never.
+	LastAccessedAt *time.Time `db:"last_accessed_at" json:"last_accessed_at,omitempty"`
+
+	// HitCount is the number of times the memo was read.
+	HitCount int64 `db:"hit_count" json:"hit_count"`
+
+	// WriterID identifies the server instance that wrote the memo.
+	WriterID string `db:"writer_id" json:"writer_id"`
 }
 
 // Store is the wrapper around a store such as a database
@@ -29,10 +46,109 @@ type Store interface {
 	// Memoize stores a fibonacci number/value pair.
 	Memoize(context.Context, int, uint64) error
 
+	// MemoDetail fetches a memo along with its metadata.  The bool
+	// return is false if the entry does not exist.
+	MemoDetail(context.Context, int) (FibPair, bool, error)
+
 	// MemoCount finds the number of memoized values whose value
 	// is less than or equal to a target value.
 	MemoCount(context.Context, uint64) (int, error)
 
 	// Clear clears all rows from the store.
 	Clear(context.Context) error
+
+	// List returns up to limit memos in ascending order of their number,
+	// starting with the first one whose number is greater than after.
+	List(ctx context.Context, after, limit int) ([]FibPair, error)
+
+	// ClearRange removes the memos whose fibonacci number falls in the
+	// inclusive range [from, to].  If dryRun is true, nothing is removed.
+	// It returns the number of memos removed (or that would be removed).
+	ClearRange(ctx context.Context, from, to int, dryRun bool) (int, error)
+
+	// CountRange returns the number of memos whose fibonacci number falls
+	// in the inclusive range [from, to].
+	CountRange(ctx context.Context, from, to int) (int, error)
+}
+
+// Pinger is implemented by stores that depend on a connection to a
+// database, so their availability can be checked.
+type Pinger interface {
+
+	// Ping verifies the connection to the database is alive.
+	Ping(context.Context) error
+}
+
+// Quotas is implemented by stores that can persist the daily request
+// quotas of clients, so they survive restarts and are shared by instances.
+type Quotas interface {
+
+	// AddUsage adds n to the usage of the key on the (UTC) day, and
+	// returns the resulting total.
+	AddUsage(ctx context.Context, key string, day time.Time, n int) (int, error)
+}
+
+// The states of a job in the durable queue.
+const (
+	QueuePending = "pending" // waiting to be claimed, possibly for a retry
+	QueueRunning = "running" // claimed by a worker, under a lease
+	QueueDone    = "done"    // completed
+	QueueDead    = "dead"    // failed permanently, or out of attempts
+)
+
+// ErrLeaseLost is returned when a worker acts on a job it no longer
+// holds the lease for, as the lease expired and the job was claimed again.
+var ErrLeaseLost = errors.New("job lease lost")
+
+// QueuedJob is a job in the durable queue.  The payload is JSON.
+type QueuedJob struct {
+	ID          int64      `db:"id"`
+	Payload     []byte     `db:"payload"`
+	State       string     `db:"state"`
+	Attempts    int        `db:"attempts"`
+	MaxAttempts int        `db:"max_attempts"`
+	RunAt       time.Time  `db:"run_at"`
+	LeaseOwner  string     `db:"lease_owner"`
+	LeaseUntil  *time.Time `db:"lease_until"`
+	LastError   string     `db:"last_error"`
+	CreatedAt   time.Time  `db:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at"`
+}
+
+// Queue is implemented by stores that keep a durable job queue, which
+// workers on several instances take jobs from.  A claimed job is leased to
+// its worker, and is claimed again by another once the lease expires, so
+// the jobs of a worker that dies are retried.
+type Queue interface {
+
+	// Enqueue adds a job, returning its ID.
+	Enqueue(ctx context.Context, payload []byte, maxAttempts int) (int64, error)
+
+	// Claim leases the next ready job to the owner, counting an attempt.
+	// The bool return is false if there is none.  Jobs whose lease
+	// expired on their last attempt are dead-lettered rather than claimed.
+	Claim(ctx context.Context, owner string, lease time.Duration) (QueuedJob, bool, error)
+
+	// Extend renews the lease of a running job.
+	Extend(ctx context.Context, id int64, owner string, lease time.Duration) error
+
+	// Complete marks a running job as done.
+	Complete(ctx context.Context, id int64, owner string) error
+
+	// Fail records a failed attempt.  The job is retried after the delay,
+	// or dead-lettered if it is out of attempts.  The resulting state is
+	// returned.
+	Fail(ctx context.Context, id int64, owner, cause string, delay time.Duration) (string, error)
+
+	// DeadLetter fails a job permanently, such as when retrying it
+	// can't help.
+	DeadLetter(ctx context.Context, id int64, owner, cause string) error
+
+	// Release returns a running job to the queue without counting the
+	// attempt, such as when its worker shuts down.
+	Release(ctx context.Context, id int64, owner string) error
+
+	// QueuedJob returns a job.  The bool return is false if there is
+	// no such job.
+	QueuedJob(ctx context.Context, id int64) (QueuedJob, bool, error)
 }
@@ -4,22 +4,17 @@
 package integration
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"strconv"
 	"testing"
 	"time"
 
-	"github.com/gdotgordon/fibsrv/api"
+	"github.com/gdotgordon/fibsrv/client"
 )
 
-var (
-	fibAddr   string
-	fibClient *http.Client
-)
+var fibClient *client.Client
 
 // This test is similar to the unit tests in service, but invokes everything
 // going through the live server via the REST API.
@@ -35,29 +30,26 @@ func TestMain(m *testing.M) {
 			os.Exit(1)
 		}
 	}
-	fibAddr = fmt.Sprintf("http://localhost:%d/v1/", port)
 
-	fibClient = http.DefaultClient
+	// The client retries while the server starts.
+	fibClient, err = client.New(fmt.Sprintf("http://localhost:%d", port),
+		client.WithRetries(10), client.WithBackoff(time.Second, time.Second))
+	if err != nil {
+		fmt.Println("cannot create client:", err)
+		os.Exit(1)
+	}
 
 	// Make sure we can reach the server.
-	connected := false
-	for i := 0; i < 10; i++ {
-		if err := invokeClear(); err != nil {
-			time.Sleep(1 * time.Second)
-		} else {
-			connected = true
-			break
-		}
-	}
-	if !connected {
-		fmt.Println("cannot connect to server")
+	if _, err := fibClient.Clear(context.Background()); err != nil {
+		fmt.Println("cannot connect to server:", err)
 		os.Exit(1)
 	}
 	os.Exit(m.Run())
 }
 
 func TestFibInteg(t *testing.T) {
-	if err := invokeClear(); err != nil {
+	ctx := context.Background()
+	if _, err := fibClient.Clear(ctx); err != nil {
 		t.Fatal("error clearing database:", err)
 	}
 
@@ -74,33 +66,20 @@ func TestFibInteg(t *testing.T) {
 		{n: 20, result: 6765},
 		{n: 8, result: 21},
 	} {
-		resp, err := http.Get(fmt.Sprintf("%s%s?n=%d", fibAddr, "fib", v.n))
+		res, err := fibClient.Fib(ctx, v.n)
 		if err != nil {
-			t.Fatal(err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			t.Fatalf("Bad status code getting fib %dd: %d", v.n, resp.StatusCode)
-		}
-
-		b, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		var res api.ResultResponse
-		if err = json.Unmarshal(b, &res); err != nil {
-			t.Fatal(err)
+			t.Fatalf("%d: error getting fib %d: %v", i, v.n, err)
 		}
-		if res.Result != v.result {
-			t.Fatalf("%d: less(%d), expected %d, got %d", i, v.n, v.result, res)
+		if res != v.result {
+			t.Fatalf("%d: fib(%d), expected %d, got %d", i, v.n, v.result, res)
 		}
 	}
 
 }
 
 func TestFibLessInteg(t *testing.T) {
-	if err := invokeClear(); err != nil {
+	ctx := context.Background()
+	if _, err := fibClient.Clear(ctx); err != nil {
 		t.Fatal("error clearing database:", err)
 	}
 
@@ -115,39 +94,12 @@ func TestFibLessInteg(t *testing.T) {
 		{target: 120, result: 12},
 		{target: 58, result: 11},
 	} {
-		resp, err := http.Get(fmt.Sprintf("%s%s?target=%d", fibAddr, "fibless", v.target))
+		res, err := fibClient.FibLess(ctx, v.target)
 		if err != nil {
-			t.Fatal(err)
+			t.Fatalf("%d: error getting fibless %d: %v", i, v.target, err)
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			t.Fatalf("Bad status code getting fibless %dd: %d", v.target, resp.StatusCode)
-		}
-
-		b, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		var res api.ResultResponse
-		if err = json.Unmarshal(b, &res); err != nil {
-			t.Fatal(err)
-		}
-		if int(res.Result) != v.result {
-			t.Fatalf("%d: less(%d), expected %d, got %d", i, v.result, res.Result, res)
+		if res != v.result {
+			t.Fatalf("%d: less(%d), expected %d, got %d", i, v.target, v.result, res)
 		}
 	}
 }
-
-func invokeClear() error {
-	resp, err := http.Get(fibAddr + "clear")
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Bad status code clearing db: %d", resp.StatusCode)
-	}
-	return nil
-}
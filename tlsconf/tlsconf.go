@@ -0,0 +1,266 @@
+// Package tlsconf builds the TLS configuration of the HTTP server from
+// certificate and key files, with optional verification of client
+// certificates against a CA bundle (mutual TLS).  The files are watched,
+// so renewed certificates are picked up without a restart.
+package tlsconf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The supported client authentication modes.
+const (
+	ClientAuthNone          = "none"            // no client certificate is requested
+	ClientAuthRequest       = "request"         // requested, but not verified
+	ClientAuthVerifyIfGiven = "verify-if-given" // verified if one is presented
+	ClientAuthRequire       = "require"         // required and verified
+)
+
+// The supported cipher policies.
+const (
+	// PolicyIntermediate allows TLS 1.2 with forward secret AEAD ciphers
+	// only, as well as TLS 1.3.
+	PolicyIntermediate = "intermediate"
+
+	// PolicyModern only allows TLS 1.3.
+	PolicyModern = "modern"
+
+	// PolicyGo uses the defaults of the Go standard library.
+	PolicyGo = "go"
+)
+
+// DefaultReloadInterval is how often the files are checked for changes,
+// if not configured.
+const DefaultReloadInterval = 30 * time.Second
+
+// The TLS 1.2 cipher suites allowed by the intermediate policy.  TLS 1.3
+// suites are not configurable.
+var intermediateCiphers = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// Config defines the TLS settings.
+type Config struct {
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is "1.2" or "1.3", "1.2" if empty.
+	MinVersion string
+
+	// CipherPolicy is one of the Policy constants, PolicyIntermediate if empty.
+	CipherPolicy string
+
+	// ClientCAFile is the bundle of CAs client certificates are verified
+	// against.  It is required if client certificates are verified.
+	ClientCAFile string
+
+	// ClientAuth is one of the ClientAuth constants, ClientAuthNone if empty.
+	ClientAuth string
+
+	// ReloadInterval is how often the files are checked for changes,
+	// DefaultReloadInterval if zero.
+	ReloadInterval time.Duration
+}
+
+// Validate checks the settings, without loading the files.
+func (c Config) Validate() error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("TLS requires both a certificate and a key file")
+	}
+	if _, err := minVersion(c.MinVersion); err != nil {
+		return err
+	}
+	switch c.CipherPolicy {
+	case "", PolicyIntermediate, PolicyModern, PolicyGo:
+	default:
+		return fmt.Errorf("unknown TLS cipher policy: %q", c.CipherPolicy)
+	}
+	auth, err := clientAuth(c.ClientAuth)
+	if err != nil {
+		return err
+	}
+	if auth >= tls.VerifyClientCertIfGiven && c.ClientCAFile == "" {
+		return fmt.Errorf("TLS client auth %q requires a client CA file", c.ClientAuth)
+	}
+	return nil
+}
+
+func minVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS minimum version: %q", v)
+}
+
+func clientAuth(a string) (tls.ClientAuthType, error) {
+	switch a {
+	case "", ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthRequest:
+		return tls.RequestClientCert, nil
+	case ClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return 0, fmt.Errorf("unknown TLS client auth mode: %q", a)
+}
+
+// Manager holds the current certificate and client CAs, and reloads them
+// when the files change.
+type Manager struct {
+	cfg  Config
+	base *tls.Config
+	log  *zap.SugaredLogger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// New validates the settings and loads the files.
+func New(cfg Config, log *zap.SugaredLogger) (*Manager, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	minVer, _ := minVersion(cfg.MinVersion)
+	auth, _ := clientAuth(cfg.ClientAuth)
+	base := &tls.Config{
+		MinVersion: minVer,
+		ClientAuth: auth,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	switch cfg.CipherPolicy {
+	case "", PolicyIntermediate:
+		base.CipherSuites = intermediateCiphers
+	case PolicyModern:
+		base.MinVersion = tls.VersionTLS13
+	}
+
+	m := &Manager{cfg: cfg, base: base, log: log}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TLSConfig returns the configuration for the server.  The certificate
+// and client CAs are looked up on each handshake, so reloads take effect
+// for new connections.
+func (m *Manager) TLSConfig() *tls.Config {
+	cfg := m.base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		c := m.base.Clone()
+		c.Certificates = []tls.Certificate{*m.cert}
+		c.ClientCAs = m.clientCA
+		return c, nil
+	}
+	return cfg
+}
+
+// Reload loads the certificate, key and client CA files.  On error, the
+// previously loaded ones are kept.
+func (m *Manager) Reload() error {
+	cert, err := tls.LoadX509KeyPair(m.cfg.CertFile, m.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS key pair: %v", err)
+	}
+	var pool *x509.CertPool
+	if m.cfg.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(m.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading TLS client CAs: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", m.cfg.ClientCAFile)
+		}
+	}
+	modTimes, err := m.fileModTimes()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.cert, m.clientCA, m.modTimes = &cert, pool, modTimes
+	m.mu.Unlock()
+	return nil
+}
+
+// Run checks the files for changes until the context is done, reloading
+// them if any has changed.
+func (m *Manager) Run(ctx context.Context) {
+	interval := m.cfg.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !m.changed() {
+				continue
+			}
+			if err := m.Reload(); err != nil {
+				m.log.Errorw("Reloading TLS files", "error", err)
+			} else {
+				m.log.Infow("Reloaded TLS files", "cert", m.cfg.CertFile)
+			}
+		}
+	}
+}
+
+func (m *Manager) changed() bool {
+	cur, err := m.fileModTimes()
+	if err != nil {
+		// Possibly in the middle of being replaced, try again later.
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for f, t := range cur {
+		if !t.Equal(m.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) fileModTimes() (map[string]time.Time, error) {
+	res := make(map[string]time.Time)
+	for _, f := range []string{m.cfg.CertFile, m.cfg.KeyFile, m.cfg.ClientCAFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		res[f] = fi.ModTime()
+	}
+	return res, nil
+}
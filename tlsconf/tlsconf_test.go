@@ -0,0 +1,199 @@
+package tlsconf_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/api"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gdotgordon/fibsrv/tlsconf"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// A certificate and its key.
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// Issues a certificate, self-signed if there is no parent.
+func issue(t *testing.T, tmpl *x509.Certificate, parent *keyPair) *keyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &keyPair{cert: cert, key: key, der: der}
+}
+
+// Writes the certificate and key as PEM files.
+func (kp *keyPair) write(t *testing.T, certFile, keyFile string) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: kp.der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	b, err := x509.MarshalECPrivateKey(kp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (kp *keyPair) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{kp.der}, PrivateKey: kp.key}
+}
+
+// Tests mutual TLS passes the client identity to the handlers, and that a
+// renewed server certificate is picked up.
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	serverTmpl := func(serial int64) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "fibsrv"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+	}
+	server := issue(t, serverTmpl(2), ca)
+	client := issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "billing"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	ca.write(t, caFile, "")
+	server.write(t, certFile, keyFile)
+
+	mgr, err := tlsconf.New(tlsconf.Config{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		ClientAuth:   tlsconf.ClientAuthRequire,
+	}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, err := service.NewFib(store.NewMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	if err := api.Init(context.Background(), r, svc, zap.NewNop().Sugar(), api.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	r.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := api.ClientIdentity(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(id.CommonName))
+	})
+	srv := httptest.NewUnstartedServer(r)
+	srv.TLS = mgr.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+	}
+
+	// No client certificate: the handshake fails.
+	if _, err := newClient().Get(srv.URL + "/whoami"); err == nil {
+		t.Fatal("expected handshake to fail without a client certificate")
+	}
+
+	resp, err := newClient(client.tlsCert()).Get(srv.URL + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "billing" {
+		t.Fatalf("expected identity 'billing', got %d %q", resp.StatusCode, body)
+	}
+
+	// Renew the server certificate and reload it.
+	renewed := issue(t, serverTmpl(4), ca)
+	renewed.write(t, certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if err := mgr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = newClient(client.tlsCert()).Get(srv.URL + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if sn := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); sn != 4 {
+		t.Fatalf("expected renewed certificate with serial 4, got %d", sn)
+	}
+}
+
+// Tests invalid settings are rejected.
+func TestValidate(t *testing.T) {
+	for i, cfg := range []tlsconf.Config{
+		{CertFile: "c"},
+		{CertFile: "c", KeyFile: "k", MinVersion: "1.1"},
+		{CertFile: "c", KeyFile: "k", CipherPolicy: "weak"},
+		{CertFile: "c", KeyFile: "k", ClientAuth: tlsconf.ClientAuthRequire},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%d: expected invalid config %+v", i, cfg)
+		}
+	}
+}
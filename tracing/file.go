@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Compile time interface implementation check.
+var _ otlptrace.Client = (*fileClient)(nil)
+
+// fileClient is an OTLP client that appends each batch of spans to a file
+// as one line of JSON, encoded as an OTLP ExportTraceServiceRequest.
+type fileClient struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func newFileClient(path string) *fileClient {
+	return &fileClient{path: path}
+}
+
+// Start opens the file for appending.
+func (fc *fileClient) Start(ctx context.Context) error {
+	f, err := os.OpenFile(fc.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fc.f = f
+	return nil
+}
+
+// Stop closes the file.
+func (fc *fileClient) Stop(ctx context.Context) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.f.Close()
+}
+
+// UploadTraces writes a batch of spans.
+func (fc *fileClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	b, err := protojson.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	_, err = fc.f.Write(append(b, '\n'))
+	return err
+}
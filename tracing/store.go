@@ -0,0 +1,236 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gdotgordon/fibsrv/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Compile time interface implementation checks.
+var (
+	_ store.Store  = (*Store)(nil)
+	_ store.Quotas = (*Store)(nil)
+	_ store.Queue  = (*Store)(nil)
+)
+
+// Store is a store.Store decorator starting a client span for each
+// operation, including those of the quotas and the queue, which fail if
+// the wrapped store has none.
+type Store struct {
+	store.Store
+	tracer trace.Tracer
+}
+
+// NewStore wraps a store with tracing.
+func NewStore(s store.Store) *Store {
+	return &Store{Store: s, tracer: otel.Tracer(instrumentation + "/store")}
+}
+
+// Memo implements store.Store.
+func (s *Store) Memo(ctx context.Context, n int) (uint64, bool, error) {
+	ctx, span := s.start(ctx, "Memo", attribute.Int("fib.n", n))
+	val, ok, err := s.Store.Memo(ctx, n)
+	span.SetAttributes(attribute.Bool("fib.memo.hit", ok))
+	end(span, err)
+	return val, ok, err
+}
+
+// Memoize implements store.Store.
+func (s *Store) Memoize(ctx context.Context, n int, val uint64) error {
+	ctx, span := s.start(ctx, "Memoize", attribute.Int("fib.n", n))
+	err := s.Store.Memoize(ctx, n, val)
+	end(span, err)
+	return err
+}
+
+// MemoDetail implements store.Store.
+func (s *Store) MemoDetail(ctx context.Context, n int) (store.FibPair, bool, error) {
+	ctx, span := s.start(ctx, "MemoDetail", attribute.Int("fib.n", n))
+	p, ok, err := s.Store.MemoDetail(ctx, n)
+	end(span, err)
+	return p, ok, err
+}
+
+// MemoCount implements store.Store.
+func (s *Store) MemoCount(ctx context.Context, target uint64) (int, error) {
+	ctx, span := s.start(ctx, "MemoCount", attribute.Int64("fib.target", int64(target)))
+	cnt, err := s.Store.MemoCount(ctx, target)
+	end(span, err)
+	return cnt, err
+}
+
+// List implements store.Store.
+func (s *Store) List(ctx context.Context, after, limit int) ([]store.FibPair, error) {
+	ctx, span := s.start(ctx, "List",
+		attribute.Int("fib.after", after), attribute.Int("fib.limit", limit))
+	res, err := s.Store.List(ctx, after, limit)
+	end(span, err)
+	return res, err
+}
+
+// Clear implements store.Store.
+func (s *Store) Clear(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Clear")
+	err := s.Store.Clear(ctx)
+	end(span, err)
+	return err
+}
+
+// ClearRange implements store.Store.
+func (s *Store) ClearRange(ctx context.Context, from, to int, dryRun bool) (int, error) {
+	ctx, span := s.start(ctx, "ClearRange", attribute.Int("fib.from", from),
+		attribute.Int("fib.to", to), attribute.Bool("fib.dry_run", dryRun))
+	cnt, err := s.Store.ClearRange(ctx, from, to, dryRun)
+	end(span, err)
+	return cnt, err
+}
+
+// CountRange implements store.Store.
+func (s *Store) CountRange(ctx context.Context, from, to int) (int, error) {
+	ctx, span := s.start(ctx, "CountRange", attribute.Int("fib.from", from),
+		attribute.Int("fib.to", to))
+	cnt, err := s.Store.CountRange(ctx, from, to)
+	end(span, err)
+	return cnt, err
+}
+
+// AddUsage implements store.Quotas.
+func (s *Store) AddUsage(ctx context.Context, key string, day time.Time, n int) (int, error) {
+	q, ok := s.Store.(store.Quotas)
+	if !ok {
+		return 0, fmt.Errorf("%T does not keep quotas", s.Store)
+	}
+	ctx, span := s.start(ctx, "AddUsage")
+	used, err := q.AddUsage(ctx, key, day, n)
+	end(span, err)
+	return used, err
+}
+
+// Returns the queue of the wrapped store.
+func (s *Store) queue() (store.Queue, error) {
+	q, ok := s.Store.(store.Queue)
+	if !ok {
+		return nil, fmt.Errorf("%T has no queue", s.Store)
+	}
+	return q, nil
+}
+
+// Enqueue implements store.Queue.
+func (s *Store) Enqueue(ctx context.Context, payload []byte, maxAttempts int) (int64, error) {
+	q, err := s.queue()
+	if err != nil {
+		return 0, err
+	}
+	ctx, span := s.start(ctx, "Enqueue")
+	id, err := q.Enqueue(ctx, payload, maxAttempts)
+	span.SetAttributes(attribute.Int64("fib.job.id", id))
+	end(span, err)
+	return id, err
+}
+
+// Claim implements store.Queue.
+func (s *Store) Claim(ctx context.Context, owner string, lease time.Duration) (store.QueuedJob, bool, error) {
+	q, err := s.queue()
+	if err != nil {
+		return store.QueuedJob{}, false, err
+	}
+	ctx, span := s.start(ctx, "Claim")
+	job, ok, err := q.Claim(ctx, owner, lease)
+	if ok {
+		span.SetAttributes(attribute.Int64("fib.job.id", job.ID))
+	}
+	end(span, err)
+	return job, ok, err
+}
+
+// Extend implements store.Queue.
+func (s *Store) Extend(ctx context.Context, id int64, owner string, lease time.Duration) error {
+	q, err := s.queue()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.start(ctx, "Extend", attribute.Int64("fib.job.id", id))
+	err = q.Extend(ctx, id, owner, lease)
+	end(span, err)
+	return err
+}
+
+// Complete implements store.Queue.
+func (s *Store) Complete(ctx context.Context, id int64, owner string) error {
+	q, err := s.queue()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.start(ctx, "Complete", attribute.Int64("fib.job.id", id))
+	err = q.Complete(ctx, id, owner)
+	end(span, err)
+	return err
+}
+
+// Fail implements store.Queue.
+func (s *Store) Fail(ctx context.Context, id int64, owner, cause string, delay time.Duration) (string, error) {
+	q, err := s.queue()
+	if err != nil {
+		return "", err
+	}
+	ctx, span := s.start(ctx, "Fail", attribute.Int64("fib.job.id", id))
+	state, err := q.Fail(ctx, id, owner, cause, delay)
+	end(span, err)
+	return state, err
+}
+
+// DeadLetter implements store.Queue.
+func (s *Store) DeadLetter(ctx context.Context, id int64, owner, cause string) error {
+	q, err := s.queue()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.start(ctx, "DeadLetter", attribute.Int64("fib.job.id", id))
+	err = q.DeadLetter(ctx, id, owner, cause)
+	end(span, err)
+	return err
+}
+
+// Release implements store.Queue.
+func (s *Store) Release(ctx context.Context, id int64, owner string) error {
+	q, err := s.queue()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.start(ctx, "Release", attribute.Int64("fib.job.id", id))
+	err = q.Release(ctx, id, owner)
+	end(span, err)
+	return err
+}
+
+// QueuedJob implements store.Queue.
+func (s *Store) QueuedJob(ctx context.Context, id int64) (store.QueuedJob, bool, error) {
+	q, err := s.queue()
+	if err != nil {
+		return store.QueuedJob{}, false, err
+	}
+	ctx, span := s.start(ctx, "QueuedJob", attribute.Int64("fib.job.id", id))
+	job, ok, err := q.QueuedJob(ctx, id)
+	end(span, err)
+	return job, ok, err
+}
+
+func (s *Store) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "store."+method,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// Ends a span, recording the error if any.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
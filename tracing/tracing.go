@@ -0,0 +1,80 @@
+// Package tracing sets up OpenTelemetry tracing for the fibonacci service.
+// Spans are started by a middleware for the HTTP handlers, by the service
+// itself, and by a decorator wrapping any store.Store, so the time spent
+// computing can be told apart from the time spent in the store.
+//
+// The W3C traceparent header of incoming requests is honoured.  Spans may
+// be exported to stdout, or to a file as OTLP JSON lines, so tracing can
+// be used without running a collector.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// The supported exporters.
+const (
+	ExporterNone     = "none"      // no spans are exported
+	ExporterStdout   = "stdout"    // spans are printed as JSON to stdout
+	ExporterOTLPFile = "otlp-file" // spans are written as OTLP JSON lines to a file
+)
+
+// Config defines how spans are exported.
+type Config struct {
+	// Exporter is one of the Exporter constants, ExporterNone if empty.
+	Exporter string
+
+	// File is the path written by the OTLP file exporter.
+	File string
+
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator.  The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exp sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLPFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("the %s trace exporter requires a file", cfg.Exporter)
+		}
+		exp, err = otlptrace.New(ctx, newFileClient(cfg.File))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name := cfg.ServiceName
+	if name == "" {
+		name = "fibsrv"
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(name))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
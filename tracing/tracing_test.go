@@ -0,0 +1,128 @@
+package tracing_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gdotgordon/fibsrv/api"
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/store"
+	"github.com/gdotgordon/fibsrv/tracing"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+// Tests a request continues the caller's trace, and that the service and
+// store spans are nested under the request span.
+func TestRequestSpans(t *testing.T) {
+	ctx := context.Background()
+	if _, err := tracing.Setup(ctx, tracing.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+
+	st := tracing.NewStore(store.NewMap())
+	svc, err := service.NewFib(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	if err := api.Init(ctx, r, svc, zap.NewNop().Sugar(), api.Config{Tracing: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/v1/fib?n=3", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+
+	spans := rec.Ended()
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range spans {
+		if s.SpanContext().TraceID().String() != traceID {
+			t.Fatalf("span %s has trace ID %s", s.Name(), s.SpanContext().TraceID())
+		}
+		byName[s.Name()] = s
+	}
+	root, ok := byName["GET /v1/fib"]
+	if !ok {
+		t.Fatalf("no request span in %v", byName)
+	}
+	fib, ok := byName["FibService.Fib"]
+	if !ok {
+		t.Fatal("no service span")
+	}
+	if fib.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Fatal("service span is not a child of the request span")
+	}
+	memoize, ok := byName["store.Memoize"]
+	if !ok {
+		t.Fatal("no store span")
+	}
+	if memoize.Parent().SpanID() != fib.SpanContext().SpanID() {
+		t.Fatal("store span is not a child of the service span")
+	}
+
+	// The queue of the store is traced too.
+	if _, err := st.Enqueue(ctx, []byte("{}"), 1); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, s := range rec.Ended() {
+		found = found || s.Name() == "store.Enqueue"
+	}
+	if !found {
+		t.Fatal("no queue span")
+	}
+}
+
+// Tests the OTLP file exporter writes a JSON line per batch.
+func TestOTLPFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := tracing.Setup(ctx, tracing.Config{
+		Exporter: tracing.ExporterOTLPFile,
+		File:     path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := otel.Tracer("test").Start(ctx, "test-span")
+	span.End()
+	if err := shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		t.Fatal("no spans written")
+	}
+	var req struct {
+		ResourceSpans []json.RawMessage `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ResourceSpans) != 1 {
+		t.Fatalf("expected 1 resource span, got %d", len(req.ResourceSpans))
+	}
+}
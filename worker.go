@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/gdotgordon/fibsrv/service"
+	"github.com/gdotgordon/fibsrv/tracing"
+)
+
+// Runs the workers of the durable job queue, without the HTTP or gRPC
+// servers, until a signal is received.  The jobs running then are
+// returned to the queue.
+func worker(ctx context.Context, args []string) int {
+	fs := newFlagSet("worker")
+	cfg, code := loadConfig(fs, args)
+	if code != exitOK || cfg == nil {
+		return code
+	}
+	// The memory store's queue is only seen by its server, which runs the
+	// jobs itself.
+	if cfg.Store.Memory() {
+		return fail(exitUsage, "invalid store", errors.New("workers require the postgres store"))
+	}
+	log, err := initLogging(cfg.LogLevel)
+	if err != nil {
+		return fail(exitFailure, "error creating logger", err)
+	}
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter: cfg.Tracing.Exporter,
+		File:     cfg.Tracing.File,
+	})
+	if err != nil {
+		return fail(exitFailure, "error setting up tracing", err)
+	}
+	pg, err := openPostgres(ctx, cfg, log)
+	if err != nil {
+		return fail(exitFailure, "error opening store", err)
+	}
+	dataStore := tracing.NewStore(pg)
+	svc, err := service.NewFib(dataStore, service.WithLimits(limitSettings(cfg.Limits)))
+	if err != nil {
+		return fail(exitFailure, "error creating service", err)
+	}
+
+	// Each process is a distinct owner of the leases.
+	owner := fmt.Sprintf("%s-%d", cfg.InstanceID, os.Getpid())
+	workers := service.NewQueueWorkers(svc, dataStore, log, queueSettings(cfg.Queue, owner, cfg.Jobs.Timeout))
+
+	ctx, cancel := interruptible(ctx, log)
+	defer cancel()
+
+	log.Infow("Running queue workers", "workers", cfg.Queue.Workers, "owner", owner)
+	workers.Run(ctx)
+
+	for _, t := range []cleanupTask{pg.Shutdown, func() error {
+		return shutdownTracing(context.Background())
+	}} {
+		if err := t(); err != nil {
+			log.Infow("Shutdown error", "error", err)
+		}
+	}
+	log.Infow("Worker shutting down")
+	return exitOK
+}